@@ -0,0 +1,303 @@
+package xhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+// Default values for [AdminConfig].
+const (
+	DefaultAdminAddr           = "localhost:6060"
+	DefaultAdminProfileSeconds = 30
+	DefaultAdminTraceSeconds   = 1
+)
+
+var processStart = time.Now()
+
+// AdminConfig holds configuration options for the admin server, see [ServerConfig.Admin].
+type AdminConfig struct {
+	// Addr is the TCP address of the admin listener. Default is "localhost:6060".
+	// Keep it private, the endpoints expose internals and control logging.
+	Addr string
+
+	SocketPath string // Unix socket to listen on instead of Addr, e.g. "/run/myapp/admin.sock".
+
+	Logger *xlog.Logger // Enables the log level and flush endpoints.
+}
+
+// adminIndex lists the admin endpoints, served at the root.
+const adminIndex = `GET  /debug/pprof/             profiles and their counts
+GET  /debug/pprof/{profile}    e.g. heap, allocs, block, mutex; ?debug=1 for text, ?gc=1 to collect first
+GET  /debug/pprof/profile      CPU profile, ?seconds=30
+GET  /debug/pprof/trace        execution trace, ?seconds=1
+GET  /debug/pprof/cmdline      command line
+GET  /debug/goroutines         full goroutine dump
+GET  /debug/runtime            runtime and GC stats
+GET  /debug/build              build info
+GET  /debug/log/level          current log level
+PUT  /debug/log/level          set log level, e.g. body "debug" or ?level=debug
+POST /debug/log/flush          flush buffered log lines to disk
+`
+
+// newAdminServer creates the admin http server and its listen function.
+func newAdminServer(cfg *AdminConfig) (*http.Server, func() (net.Listener, error), error) {
+	copy := *cfg
+
+	if copy.Addr != "" && copy.SocketPath != "" {
+		return nil, nil, fmt.Errorf("admin Addr and SocketPath are mutually exclusive")
+	}
+
+	// set defaults
+
+	if copy.Addr == "" {
+		copy.Addr = DefaultAdminAddr
+	}
+
+	srv := &http.Server{
+		Handler:           adminMux(copy.Logger),
+		ReadHeaderTimeout: DefaultReadTimeout,
+		IdleTimeout:       DefaultIdleTimeout,
+		// no WriteTimeout, profiles and traces stream for as long as requested
+	}
+	listen := func() (net.Listener, error) {
+		if copy.SocketPath == "" {
+			return net.Listen("tcp", copy.Addr)
+		}
+		// remove a socket left by a crash, failing on anything else
+		if fi, err := os.Lstat(copy.SocketPath); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(copy.SocketPath)
+		}
+		ln, err := net.Listen("unix", copy.SocketPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(copy.SocketPath, 0o600); err != nil {
+			ln.Close()
+			return nil, err
+		}
+		return ln, nil
+	}
+	return srv, listen, nil
+}
+
+// adminMux serves pprof through runtime/pprof directly, as importing net/http/pprof registers
+// its handlers on [http.DefaultServeMux], exposing them wherever that is served.
+func adminMux(logger *xlog.Logger) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, adminIndex)
+	})
+	mux.HandleFunc("GET /debug/pprof/{$}", adminPprofIndex)
+	mux.HandleFunc("GET /debug/pprof/{profile}", adminPprof)
+	mux.HandleFunc("GET /debug/pprof/profile", adminCPUProfile)
+	mux.HandleFunc("GET /debug/pprof/trace", adminTrace)
+	mux.HandleFunc("GET /debug/pprof/cmdline", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, strings.Join(os.Args, "\x00"))
+	})
+	mux.HandleFunc("GET /debug/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		pprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	mux.HandleFunc("GET /debug/runtime", adminRuntime)
+	mux.HandleFunc("GET /debug/build", adminBuild)
+	if logger != nil {
+		mux.HandleFunc("GET /debug/log/level", func(w http.ResponseWriter, r *http.Request) {
+			writeAdminJSON(w, map[string]string{"level": logger.Level()})
+		})
+		mux.HandleFunc("PUT /debug/log/level", func(w http.ResponseWriter, r *http.Request) {
+			level := r.URL.Query().Get("level")
+			if level == "" {
+				b, _ := io.ReadAll(io.LimitReader(r.Body, 64))
+				level = strings.TrimSpace(string(b))
+			}
+			if err := logger.SetLevel(level); err != nil {
+				e := &Err{Code: http.StatusBadRequest, Msg: "Invalid log level", Err: fmt.Errorf("admin: %w", err)}
+				if errors.Is(err, xlog.ErrClosed) {
+					e.Code, e.Msg = http.StatusServiceUnavailable, "Logger closed"
+				}
+				Error(r.Context(), w, e)
+				return
+			}
+			writeAdminJSON(w, map[string]string{"level": logger.Level()})
+		})
+		mux.HandleFunc("POST /debug/log/flush", func(w http.ResponseWriter, r *http.Request) {
+			if err := logger.Flush(); err != nil {
+				Error(r.Context(), w, fmt.Errorf("admin: %w", err))
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	return mux
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func adminPprofIndex(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, p := range pprof.Profiles() {
+		fmt.Fprintf(w, "%-14s %d\n", p.Name(), p.Count())
+	}
+	io.WriteString(w, "\nprofile?seconds=30\ntrace?seconds=1\ncmdline\n")
+}
+
+func adminPprof(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("profile")
+	p := pprof.Lookup(name)
+	if p == nil {
+		Error(r.Context(), w, &Err{Code: http.StatusNotFound, Msg: "Unknown profile", Err: fmt.Errorf("admin: unknown profile %q", name)})
+		return
+	}
+	debugLevel, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+	if name == "heap" && r.URL.Query().Get("gc") == "1" {
+		runtime.GC()
+	}
+	if debugLevel > 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	}
+	p.WriteTo(w, debugLevel)
+}
+
+// adminSeconds parses the seconds query parameter.
+func adminSeconds(r *http.Request, def int) (time.Duration, error) {
+	s := r.URL.Query().Get("seconds")
+	if s == "" {
+		return time.Duration(def) * time.Second, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, &Err{Code: http.StatusBadRequest, Msg: "Invalid seconds", Err: fmt.Errorf("admin: invalid seconds %q", s)}
+	}
+	return time.Duration(n) * time.Second, nil
+}
+
+// sleepCtx waits for d or until ctx is done.
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+func adminCPUProfile(w http.ResponseWriter, r *http.Request) {
+	d, err := adminSeconds(r, DefaultAdminProfileSeconds)
+	if err != nil {
+		Error(r.Context(), w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="profile"`)
+	if err := pprof.StartCPUProfile(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		Error(r.Context(), w, &Err{Code: http.StatusConflict, Msg: "CPU profiling already in progress", Err: fmt.Errorf("admin: %w", err)})
+		return
+	}
+	sleepCtx(r.Context(), d)
+	pprof.StopCPUProfile()
+}
+
+func adminTrace(w http.ResponseWriter, r *http.Request) {
+	d, err := adminSeconds(r, DefaultAdminTraceSeconds)
+	if err != nil {
+		Error(r.Context(), w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="trace"`)
+	if err := trace.Start(w); err != nil {
+		w.Header().Del("Content-Disposition")
+		Error(r.Context(), w, &Err{Code: http.StatusConflict, Msg: "Tracing already in progress", Err: fmt.Errorf("admin: %w", err)})
+		return
+	}
+	sleepCtx(r.Context(), d)
+	trace.Stop()
+}
+
+func adminRuntime(w http.ResponseWriter, r *http.Request) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	var lastGC string
+	if ms.LastGC > 0 {
+		lastGC = time.Unix(0, int64(ms.LastGC)).UTC().Format(time.RFC3339Nano)
+	}
+	writeAdminJSON(w, map[string]any{
+		"go_version":     runtime.Version(),
+		"goos":           runtime.GOOS,
+		"goarch":         runtime.GOARCH,
+		"num_cpu":        runtime.NumCPU(),
+		"gomaxprocs":     runtime.GOMAXPROCS(0),
+		"goroutines":     runtime.NumGoroutine(),
+		"uptime_seconds": time.Since(processStart).Seconds(),
+		"memory": map[string]uint64{
+			"alloc_bytes":       ms.Alloc,
+			"total_alloc_bytes": ms.TotalAlloc,
+			"sys_bytes":         ms.Sys,
+			"heap_alloc_bytes":  ms.HeapAlloc,
+			"heap_inuse_bytes":  ms.HeapInuse,
+			"heap_objects":      ms.HeapObjects,
+			"stack_inuse_bytes": ms.StackInuse,
+			"mallocs":           ms.Mallocs,
+			"frees":             ms.Frees,
+		},
+		"gc": map[string]any{
+			"num_gc":         ms.NumGC,
+			"num_forced_gc":  ms.NumForcedGC,
+			"next_gc_bytes":  ms.NextGC,
+			"pause_total_ns": ms.PauseTotalNs,
+			"last_pause_ns":  ms.PauseNs[(ms.NumGC+255)%256],
+			"last_gc":        lastGC,
+			"cpu_fraction":   ms.GCCPUFraction,
+			"memory_limit":   debug.SetMemoryLimit(-1), // negative only reads it
+		},
+	})
+}
+
+func adminBuild(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		Error(r.Context(), w, &Err{Code: http.StatusNotFound, Msg: "Build info unavailable", Err: errors.New("admin: binary built without module support")})
+		return
+	}
+	settings := make(map[string]string, len(info.Settings))
+	for _, s := range info.Settings {
+		settings[s.Key] = s.Value
+	}
+	deps := make([]string, 0, len(info.Deps))
+	for _, d := range info.Deps {
+		deps = append(deps, d.Path+"@"+d.Version)
+	}
+	writeAdminJSON(w, map[string]any{
+		"go_version": info.GoVersion,
+		"path":       info.Path,
+		"main":       info.Main.Path + "@" + info.Main.Version,
+		"settings":   settings,
+		"deps":       deps,
+	})
+}
@@ -0,0 +1,140 @@
+package xhttp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+func TestAdminEndpoints(t *testing.T) {
+	logger, _ := xlog.New(t.TempDir(), "info")
+	defer logger.Close()
+	mux := adminMux(logger)
+
+	tests := []struct {
+		method, path, body string
+		code               int
+		contains           string
+	}{
+		{"GET", "/", "", 200, "/debug/goroutines"},
+		{"GET", "/debug/pprof/", "", 200, "goroutine"},
+		{"GET", "/debug/pprof/heap?debug=1&gc=1", "", 200, "heap profile"},
+		{"GET", "/debug/pprof/bogus", "", 404, "Unknown profile"},
+		{"GET", "/debug/pprof/profile?seconds=0", "", 400, "Invalid seconds"},
+		{"GET", "/debug/goroutines", "", 200, "TestAdminEndpoints"},
+		{"GET", "/debug/runtime", "", 200, `"goroutines"`},
+		{"GET", "/debug/build", "", 200, `"go_version"`},
+		{"GET", "/debug/log/level", "", 200, `"level": "info"`},
+		{"PUT", "/debug/log/level", "debug\n", 200, `"level": "debug"`},
+		{"PUT", "/debug/log/level?level=warn", "", 200, `"level": "warn"`},
+		{"PUT", "/debug/log/level", "loud", 400, "Invalid log level"},
+		{"POST", "/debug/log/flush", "", 204, ""},
+		{"POST", "/debug/runtime", "", 405, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if rec.Code != tt.code || !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Fatalf("want %d containing %q, got %d %q", tt.code, tt.contains, rec.Code, rec.Body.String())
+			}
+		})
+	}
+	if logger.Level() != "warn" {
+		t.Fatalf("level not applied, got %q", logger.Level())
+	}
+
+	// log endpoints are only served with a logger
+	rec := httptest.NewRecorder()
+	adminMux(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/log/level", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("want 404 without logger, got %d", rec.Code)
+	}
+
+	// nothing is registered on the default mux
+	if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest("GET", "/debug/pprof/", nil)); pattern != "" {
+		t.Fatalf("pprof registered on http.DefaultServeMux as %q", pattern)
+	}
+}
+
+func TestAdminRuntime(t *testing.T) {
+	rec := httptest.NewRecorder()
+	adminMux(nil).ServeHTTP(rec, httptest.NewRequest("GET", "/debug/runtime", nil))
+	var stats struct {
+		Goroutines int               `json:"goroutines"`
+		Memory     map[string]uint64 `json:"memory"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if stats.Goroutines == 0 || stats.Memory["sys_bytes"] == 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestNewServerAdminValidation(t *testing.T) {
+	_, err := NewServer(&ServerConfig{Handler: noopHandler(), Admin: &AdminConfig{Addr: ":6060", SocketPath: "/tmp/a.sock"}})
+	if err == nil {
+		t.Fatal("expected error for both Addr and SocketPath")
+	}
+}
+
+func TestServerAdminLifecycle(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "admin.sock")
+	srv, err := NewServer(&ServerConfig{
+		Addr:    "127.0.0.1:0",
+		Handler: noopHandler(),
+		Admin:   &AdminConfig{SocketPath: sock},
+	})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- srv.Listen() }()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	var resp *http.Response
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if resp, err = client.Get("http://admin/debug/pprof/"); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("admin server not reachable: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "goroutine") {
+		t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+	}
+	if fi, err := os.Stat(sock); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("want socket with mode 0600, got %v, %v", fi, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := srv.ShutdownWithContext(ctx); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Fatalf("socket should be removed on shutdown, got %v", err)
+	}
+}
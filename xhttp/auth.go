@@ -0,0 +1,337 @@
+package xhttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultAPIKeyHeader is the default header read by [APIKeyAuth].
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// DefaultPBKDF2Iterations is the PBKDF2-SHA256 iteration count used by [HashPassword], per OWASP guidance.
+const DefaultPBKDF2Iterations = 600_000
+
+// ErrNoCredentials is returned by an [Authenticator] when the request carries no credentials
+// for it, letting [Auth] try the next one.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Principal is the authenticated identity of a request.
+type Principal struct {
+	ID     string         // Username, API key name, or token subject.
+	Method string         // Authenticator that accepted the request: "basic", "apikey", or "bearer".
+	Claims map[string]any // Token claims for "bearer", nil otherwise.
+}
+
+// Authenticator authenticates requests for [Auth].
+type Authenticator interface {
+	// Authenticate returns the principal for r, [ErrNoCredentials] if r has no credentials
+	// for this authenticator, or another error if the credentials are invalid.
+	Authenticate(r *http.Request) (*Principal, error)
+	// Challenge returns the WWW-Authenticate value sent with 401 responses, or "" for none.
+	Challenge() string
+}
+
+// AuthConfig holds configuration options for [Auth].
+type AuthConfig struct {
+	// Authenticators are tried in order, the first with credentials present decides. Required.
+	Authenticators []Authenticator
+
+	// Optional lets requests without any credentials through unauthenticated, so handlers can
+	// serve both. Requests with invalid credentials are still rejected.
+	Optional bool
+
+	// ErrorHandler sends the 401 [Err] for rejected requests. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// Auth is a middleware that authenticates requests with pluggable [Authenticator]s, storing
+// the [Principal] in the request context for [PrincipalFromContext]. Failures are sent as a
+// 401 [Err] with a WWW-Authenticate header.
+//
+// Usage:
+//
+//	keys, _ := xhttp.NewAPIKeyAuth(&xhttp.APIKeyAuthConfig{KeysFile: "./keys.txt"})
+//	bearer, _ := xhttp.NewBearerAuth(&xhttp.BearerAuthConfig{HMACKey: secret, Issuer: "my-app"})
+//	auth, _ := xhttp.NewAuth(&xhttp.AuthConfig{Authenticators: []xhttp.Authenticator{keys, bearer}})
+//	handler = auth.Middleware(handler)
+type Auth struct {
+	cfg *AuthConfig
+}
+
+type principalKey struct{}
+
+// NewAuth creates a new Auth with the provided configuration.
+func NewAuth(cfg *AuthConfig) (*Auth, error) {
+	copy := *cfg
+
+	if len(copy.Authenticators) == 0 {
+		return nil, fmt.Errorf("at least one authenticator must be provided")
+	}
+
+	// set defaults
+
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	return &Auth{cfg: &copy}, nil
+}
+
+// Middleware authenticates requests before they reach next.
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, authn := range a.cfg.Authenticators {
+			p, err := authn.Authenticate(r)
+			if errors.Is(err, ErrNoCredentials) {
+				continue
+			}
+			if err != nil {
+				a.reject(w, r, authn.Challenge(), err)
+				return
+			}
+			ctx := context.WithValue(r.Context(), principalKey{}, p)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		if a.cfg.Optional {
+			next.ServeHTTP(w, r)
+			return
+		}
+		a.reject(w, r, "", ErrNoCredentials)
+	})
+}
+
+// reject sends a 401. challenge is the failing authenticator's, or "" to offer all of them.
+func (a *Auth) reject(w http.ResponseWriter, r *http.Request, challenge string, err error) {
+	h := w.Header()
+	if challenge != "" {
+		h.Set("WWW-Authenticate", challenge)
+	} else {
+		for _, authn := range a.cfg.Authenticators {
+			if c := authn.Challenge(); c != "" {
+				h.Add("WWW-Authenticate", c)
+			}
+		}
+	}
+	a.cfg.ErrorHandler(r.Context(), w, &Err{
+		Code: http.StatusUnauthorized,
+		Msg:  "Unauthorized",
+		Err:  fmt.Errorf("authentication failed for %s %s: %w", r.Method, r.URL.Path, err),
+	})
+}
+
+// PrincipalFromContext returns the authenticated [Principal], or nil for anonymous requests.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// APIKeyAuthConfig holds configuration options for [APIKeyAuth].
+type APIKeyAuthConfig struct {
+	Keys map[string]string // Key name to key. The name becomes [Principal.ID].
+
+	// KeysFile is a file of "name:key" lines merged into Keys. Blank lines and lines
+	// starting with "#" are ignored.
+	KeysFile string
+
+	Header string // Request header carrying the key. Default is "X-API-Key".
+}
+
+// APIKeyAuth authenticates requests by a static API key header. Keys are compared by their
+// SHA-256 digests in constant time.
+type APIKeyAuth struct {
+	header string
+	keys   map[[sha256.Size]byte]string // digest to name
+}
+
+// NewAPIKeyAuth creates a new APIKeyAuth with the provided configuration.
+func NewAPIKeyAuth(cfg *APIKeyAuthConfig) (*APIKeyAuth, error) {
+	keys := make(map[string]string, len(cfg.Keys))
+	for name, key := range cfg.Keys {
+		keys[name] = key
+	}
+	if cfg.KeysFile != "" {
+		if err := readKeysFile(cfg.KeysFile, keys); err != nil {
+			return nil, err
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one API key must be provided")
+	}
+
+	ka := &APIKeyAuth{header: cfg.Header, keys: make(map[[sha256.Size]byte]string, len(keys))}
+	if ka.header == "" {
+		ka.header = DefaultAPIKeyHeader
+	}
+	for name, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("API key %q is empty", name)
+		}
+		ka.keys[sha256.Sum256([]byte(key))] = name
+	}
+	return ka, nil
+}
+
+func readKeysFile(path string, keys map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open API keys file: %w", err)
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, key, ok := strings.Cut(line, ":")
+		if !ok {
+			return fmt.Errorf("API keys file %s:%d: expected name:key", path, n)
+		}
+		keys[strings.TrimSpace(name)] = strings.TrimSpace(key)
+	}
+	return sc.Err()
+}
+
+// Authenticate implements [Authenticator].
+func (ka *APIKeyAuth) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(ka.header)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	// looking up a digest is constant time with respect to the key's content
+	if name, ok := ka.keys[sha256.Sum256([]byte(key))]; ok {
+		return &Principal{ID: name, Method: "apikey"}, nil
+	}
+	return nil, errors.New("invalid API key")
+}
+
+// Challenge implements [Authenticator]. API keys have no standard challenge.
+func (ka *APIKeyAuth) Challenge() string { return "" }
+
+// BasicAuthConfig holds configuration options for [BasicAuth].
+type BasicAuthConfig struct {
+	// Users maps usernames to password hashes from [HashPassword]. Required.
+	Users map[string]string
+	Realm string // Realm sent in the challenge. Default is "restricted".
+
+	// Verify, if non-nil, replaces the built-in PBKDF2 verification, e.g. to check bcrypt or
+	// argon2 hashes with golang.org/x/crypto. It must run in constant time.
+	Verify func(hash, password string) bool
+}
+
+// BasicAuth authenticates requests with HTTP Basic auth against salted password hashes.
+// Unknown users are verified against a dummy hash, so response timing doesn't reveal which
+// usernames exist.
+type BasicAuth struct {
+	cfg   *BasicAuthConfig
+	dummy string
+}
+
+// NewBasicAuth creates a new BasicAuth with the provided configuration.
+func NewBasicAuth(cfg *BasicAuthConfig) (*BasicAuth, error) {
+	copy := *cfg
+
+	if len(copy.Users) == 0 {
+		return nil, fmt.Errorf("at least one user must be provided")
+	}
+	if copy.Verify == nil {
+		for user, hash := range copy.Users {
+			if _, _, _, err := parsePasswordHash(hash); err != nil {
+				return nil, fmt.Errorf("user %q: %w", user, err)
+			}
+		}
+		copy.Verify = VerifyPassword
+	}
+
+	// set defaults
+
+	if copy.Realm == "" {
+		copy.Realm = "restricted"
+	}
+
+	ba := &BasicAuth{cfg: &copy}
+	for _, hash := range copy.Users { // dummy uses the same cost as a real user
+		ba.dummy = hash
+		break
+	}
+	return ba, nil
+}
+
+// Authenticate implements [Authenticator].
+func (ba *BasicAuth) Authenticate(r *http.Request) (*Principal, error) {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	hash, known := ba.cfg.Users[user]
+	if !known {
+		hash = ba.dummy
+	}
+	if !ba.cfg.Verify(hash, pass) || !known {
+		return nil, fmt.Errorf("invalid credentials for user %q", user)
+	}
+	return &Principal{ID: user, Method: "basic"}, nil
+}
+
+// Challenge implements [Authenticator].
+func (ba *BasicAuth) Challenge() string {
+	return `Basic realm="` + ba.cfg.Realm + `", charset="UTF-8"`
+}
+
+// HashPassword returns a salted PBKDF2-SHA256 hash of password for [BasicAuthConfig.Users],
+// in the form "$pbkdf2-sha256$<iterations>$<salt>$<hash>".
+func HashPassword(password string) (string, error) {
+	return hashPassword(password, DefaultPBKDF2Iterations)
+}
+
+func hashPassword(password string, iter int) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, iter, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawStdEncoding
+	return fmt.Sprintf("$pbkdf2-sha256$%d$%s$%s", iter, enc.EncodeToString(salt), enc.EncodeToString(key)), nil
+}
+
+// VerifyPassword reports whether password matches a hash from [HashPassword], in constant time.
+func VerifyPassword(hash, password string) bool {
+	iter, salt, want, err := parsePasswordHash(hash)
+	if err != nil {
+		return false
+	}
+	got, err := pbkdf2.Key(sha256.New, password, salt, iter, len(want))
+	return err == nil && subtle.ConstantTimeCompare(got, want) == 1
+}
+
+func parsePasswordHash(hash string) (iter int, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 5 || parts[0] != "" || parts[1] != "pbkdf2-sha256" {
+		return 0, nil, nil, errors.New("unsupported password hash format, use HashPassword")
+	}
+	if iter, err = strconv.Atoi(parts[2]); err != nil || iter <= 0 {
+		return 0, nil, nil, errors.New("invalid password hash iterations")
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return 0, nil, nil, errors.New("invalid password hash salt")
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(key) == 0 {
+		return 0, nil, nil, errors.New("invalid password hash")
+	}
+	return iter, salt, key, nil
+}
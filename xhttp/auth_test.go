@@ -0,0 +1,138 @@
+package xhttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewAuthValidation(t *testing.T) {
+	if _, err := NewAuth(&AuthConfig{}); err == nil {
+		t.Fatal("expected error without authenticators")
+	}
+	if _, err := NewAPIKeyAuth(&APIKeyAuthConfig{}); err == nil {
+		t.Fatal("expected error without keys")
+	}
+	if _, err := NewBasicAuth(&BasicAuthConfig{Users: map[string]string{"bob": "plaintext"}}); err == nil {
+		t.Fatal("expected error for unsupported hash")
+	}
+}
+
+func TestPasswordHashing(t *testing.T) {
+	hash, err := hashPassword("hunter2", 1000)
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$pbkdf2-sha256$1000$") {
+		t.Fatalf("unexpected hash format: %q", hash)
+	}
+	if !VerifyPassword(hash, "hunter2") {
+		t.Fatal("correct password should verify")
+	}
+	if VerifyPassword(hash, "hunter3") || VerifyPassword("garbage", "hunter2") {
+		t.Fatal("wrong password or hash should not verify")
+	}
+	other, _ := hashPassword("hunter2", 1000)
+	if other == hash {
+		t.Fatal("hashes should be salted")
+	}
+}
+
+func TestAPIKeyAuthFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.txt")
+	os.WriteFile(path, []byte("# comment\n\nci: key-one\ndeploy:key-two\n"), 0o600)
+
+	ka, err := NewAPIKeyAuth(&APIKeyAuthConfig{KeysFile: path, Keys: map[string]string{"admin": "key-three"}})
+	if err != nil {
+		t.Fatalf("new api key auth: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := ka.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("want ErrNoCredentials, got %v", err)
+	}
+	for key, name := range map[string]string{"key-one": "ci", "key-two": "deploy", "key-three": "admin"} {
+		r.Header.Set("X-API-Key", key)
+		p, err := ka.Authenticate(r)
+		if err != nil || p.ID != name || p.Method != "apikey" {
+			t.Fatalf("key %q: want %q, got %+v %v", key, name, p, err)
+		}
+	}
+	r.Header.Set("X-API-Key", "nope")
+	if _, err := ka.Authenticate(r); err == nil || errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("want invalid key error, got %v", err)
+	}
+
+	os.WriteFile(path, []byte("no-colon\n"), 0o600)
+	if _, err := NewAPIKeyAuth(&APIKeyAuthConfig{KeysFile: path}); err == nil {
+		t.Fatal("expected error for malformed keys file")
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	hash, _ := hashPassword("secret", 1000)
+	basic, _ := NewBasicAuth(&BasicAuthConfig{Users: map[string]string{"alice": hash}, Realm: "admin"})
+	keys, _ := NewAPIKeyAuth(&APIKeyAuthConfig{Keys: map[string]string{"ci": "k1"}})
+	auth, _ := NewAuth(&AuthConfig{Authenticators: []Authenticator{keys, basic}})
+
+	var got *Principal
+	h := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = PrincipalFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name      string
+		build     func(r *http.Request)
+		code      int
+		id        string
+		challenge string
+	}{
+		{"api key", func(r *http.Request) { r.Header.Set("X-API-Key", "k1") }, 200, "ci", ""},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("alice", "secret") }, 200, "alice", ""},
+		{"bad password", func(r *http.Request) { r.SetBasicAuth("alice", "wrong") }, 401, "", `Basic realm="admin", charset="UTF-8"`},
+		{"unknown user", func(r *http.Request) { r.SetBasicAuth("mallory", "secret") }, 401, "", `Basic realm="admin", charset="UTF-8"`},
+		{"no credentials", func(r *http.Request) {}, 401, "", `Basic realm="admin", charset="UTF-8"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got = nil
+			r := httptest.NewRequest("GET", "/", nil)
+			tt.build(r)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.code {
+				t.Fatalf("want %d, got %d", tt.code, rec.Code)
+			}
+			if tt.id != "" && (got == nil || got.ID != tt.id) {
+				t.Fatalf("want principal %q, got %+v", tt.id, got)
+			}
+			if c := rec.Header().Get("WWW-Authenticate"); c != tt.challenge {
+				t.Fatalf("want challenge %q, got %q", tt.challenge, c)
+			}
+		})
+	}
+}
+
+func TestAuthOptional(t *testing.T) {
+	keys, _ := NewAPIKeyAuth(&APIKeyAuthConfig{Keys: map[string]string{"ci": "k1"}})
+	auth, _ := NewAuth(&AuthConfig{Authenticators: []Authenticator{keys}, Optional: true})
+	h := auth.Middleware(noopHandler())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("anonymous request: want 200, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-API-Key", "bad")
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("invalid credentials: want 401, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,298 @@
+package xhttp
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default values for [CacheConfig].
+const (
+	DefaultCacheMaxEntries = 1000
+	DefaultCacheMaxBody    = 1 << 20 // 1 MiB
+)
+
+// CacheConfig holds configuration options for [Cache].
+type CacheConfig struct {
+	MaxEntries  int   // Max responses kept, evicting the least recently used. Default is 1000.
+	MaxBodySize int64 // Largest response body cached. Default is 1 MiB.
+
+	// KeyFunc returns the key responses are cached by. Default is the host and request URI.
+	// Requests for which it returns an empty string are not cached.
+	KeyFunc func(r *http.Request) string
+
+	// ErrorHandler sends the 304 and 412 [Err]s for conditional requests served from the
+	// cache. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// Cache is an in-process shared cache middleware for GET responses, following the RFC 9111
+// rules for shared caches, so handlers opt in per response with Cache-Control.
+//
+// Responses are stored when they have a positive s-maxage or max-age and no no-store,
+// no-cache, or private directive, for as long as that says. Responses with Set-Cookie or
+// "Vary: *" aren't stored, nor responses to requests with Authorization unless public or
+// s-maxage allow it. Vary is honored by comparing the listed request headers.
+//
+// Hits are served with an Age header, and conditional requests answered against their ETag
+// and Last-Modified with [CheckPreconditions]. Requests with "Cache-Control: no-cache" skip
+// the cache for a fresh response, and successful unsafe requests invalidate the cached
+// response for their URI. A Cache-Status header reports hits and misses.
+//
+// Usage:
+//
+//	cache, _ := xhttp.NewCache(&xhttp.CacheConfig{})
+//	mux.Handle("GET /api/products", cache.Middleware(products)) // products sets "Cache-Control: public, max-age=60"
+type Cache struct {
+	cfg     *CacheConfig
+	mu      sync.Mutex
+	entries map[string]*list.Element // of *cacheEntry
+	lru     list.List                // most recently used first
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	key     string
+	status  int
+	header  http.Header
+	body    []byte
+	vary    map[string]string // request header values the response varies on
+	stored  time.Time
+	age     time.Duration // age when stored, from an upstream Age header
+	expires time.Time
+}
+
+// cacheableStatus are the status codes cacheable by default, per RFC 9110 section 15.1.
+var cacheableStatus = map[int]bool{200: true, 203: true, 204: true, 300: true, 301: true, 308: true, 404: true, 405: true, 410: true, 414: true, 501: true}
+
+// NewCache creates a new Cache with the provided configuration.
+func NewCache(cfg *CacheConfig) (*Cache, error) {
+	copy := *cfg
+
+	if copy.MaxEntries < 0 || copy.MaxBodySize < 0 {
+		return nil, fmt.Errorf("cache max entries and max body size must not be negative")
+	}
+
+	// set defaults
+
+	if copy.MaxEntries == 0 {
+		copy.MaxEntries = DefaultCacheMaxEntries
+	}
+	if copy.MaxBodySize == 0 {
+		copy.MaxBodySize = DefaultCacheMaxBody
+	}
+	if copy.KeyFunc == nil {
+		copy.KeyFunc = func(r *http.Request) string { return r.Host + r.URL.RequestURI() }
+	}
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	return &Cache{cfg: &copy, entries: make(map[string]*list.Element), now: time.Now}, nil
+}
+
+// Purge removes all cached responses, e.g. after a deploy or data import.
+func (c *Cache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.entries)
+	c.lru.Init()
+}
+
+// Len returns the number of cached responses.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Middleware returns the cache middleware wrapping next.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := c.cfg.KeyFunc(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !isSafeMethod(r.Method) {
+			cw := &captureWriter{ResponseWriter: w}
+			next.ServeHTTP(cw, r)
+			if cw.status >= 200 && cw.status < 400 {
+				c.delete(key)
+			}
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		reqCC := parseCacheControl(r.Header)
+		if _, ok := reqCC["no-store"]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		_, noCache := reqCC["no-cache"]
+		if !noCache && r.Header.Get("Pragma") != "no-cache" {
+			if e := c.get(key, r); e != nil {
+				c.serve(w, r, e)
+				return
+			}
+		}
+
+		w.Header().Set("Cache-Status", "xhttp; fwd=miss")
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &captureWriter{ResponseWriter: w, max: c.cfg.MaxBodySize}
+		next.ServeHTTP(cw, r)
+		if cw.status == 0 || cw.overflow {
+			return
+		}
+		if ttl, ok := c.freshness(r, cw.status, cw.header); ok {
+			c.store(key, r, cw, ttl)
+		}
+	})
+}
+
+// freshness returns how long a response may be served from the cache, and whether it may be
+// stored at all.
+func (c *Cache) freshness(r *http.Request, status int, h http.Header) (time.Duration, bool) {
+	if !cacheableStatus[status] || h.Get("Set-Cookie") != "" || h.Get("Vary") == "*" {
+		return 0, false
+	}
+	cc := parseCacheControl(h)
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[d]; ok {
+			return 0, false
+		}
+	}
+	_, public := cc["public"]
+	sMaxAge, hasS := cc["s-maxage"]
+	if r.Header.Get("Authorization") != "" && !public && !hasS {
+		return 0, false
+	}
+	lifetime := cc["max-age"]
+	if hasS {
+		lifetime = sMaxAge
+	}
+	secs, err := strconv.Atoi(lifetime)
+	if err != nil || secs <= 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+func (c *Cache) store(key string, r *http.Request, cw *captureWriter, ttl time.Duration) {
+	now := c.now()
+	age, _ := strconv.Atoi(cw.header.Get("Age"))
+	e := &cacheEntry{
+		key:     key,
+		status:  cw.status,
+		header:  cw.header,
+		body:    cw.body.Bytes(),
+		stored:  now,
+		age:     time.Duration(age) * time.Second,
+		expires: now.Add(ttl - time.Duration(age)*time.Second),
+	}
+	if !e.expires.After(now) {
+		return
+	}
+	e.header.Del("Cache-Status")
+	if vary := e.header.Values("Vary"); len(vary) > 0 {
+		e.vary = make(map[string]string)
+		for _, v := range vary {
+			for name := range strings.SplitSeq(v, ",") {
+				if name = strings.TrimSpace(name); name != "" {
+					e.vary[http.CanonicalHeaderKey(name)] = strings.Join(r.Header.Values(name), ",")
+				}
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	for len(c.entries) > c.cfg.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// get returns the fresh entry for key matching the request's varied headers, or nil.
+func (c *Cache) get(key string, r *http.Request) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	e := el.Value.(*cacheEntry)
+	if !c.now().Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil
+	}
+	for name, v := range e.vary {
+		if strings.Join(r.Header.Values(name), ",") != v {
+			return nil
+		}
+	}
+	c.lru.MoveToFront(el)
+	return e
+}
+
+func (c *Cache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// serve sends a cached response, or a 304 or 412 if the request is conditional.
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, e *cacheEntry) {
+	h := w.Header()
+	maps.Copy(h, e.header.Clone())
+	now := c.now()
+	h.Set("Age", strconv.Itoa(int((now.Sub(e.stored) + e.age).Seconds())))
+	h.Set("Cache-Status", "xhttp; hit; ttl="+strconv.Itoa(ceilSeconds(e.expires.Sub(now))))
+
+	etag := e.header.Get("ETag")
+	modTime, _ := http.ParseTime(e.header.Get("Last-Modified"))
+	if e.status == http.StatusOK && (etag != "" || !modTime.IsZero()) {
+		if err := CheckPreconditions(w, r, etag, modTime); err != nil {
+			c.cfg.ErrorHandler(r.Context(), w, err)
+			return
+		}
+	}
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// parseCacheControl returns the Cache-Control directives in h, with lowercase names and
+// unquoted values.
+func parseCacheControl(h http.Header) map[string]string {
+	cc := make(map[string]string)
+	for _, line := range h.Values("Cache-Control") {
+		for d := range strings.SplitSeq(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				cc[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+	return cc
+}
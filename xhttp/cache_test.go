@@ -0,0 +1,123 @@
+package xhttp
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	var calls atomic.Int32
+	cache, _ := NewCache(&CacheConfig{})
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	h := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		switch r.URL.Path {
+		case "/private":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+		case "/cookie":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Set-Cookie", "a=b")
+		case "/none":
+		case "/lang":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+		default:
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+		}
+		fmt.Fprintf(w, "response %d", n)
+	}))
+	do := func(method, path string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	do("GET", "/items")
+	now = now.Add(5 * time.Second)
+	hit := do("GET", "/items")
+	if calls.Load() != 1 || hit.Body.String() != "response 1" || hit.Header().Get("Age") != "5" || hit.Header().Get("Cache-Status") != "xhttp; hit; ttl=55" {
+		t.Fatalf("want hit, got %q %v after %d calls", hit.Body, hit.Header(), calls.Load())
+	}
+	if rec := do("HEAD", "/items"); calls.Load() != 1 || rec.Code != http.StatusOK {
+		t.Fatalf("want HEAD served from cache, got %d after %d calls", rec.Code, calls.Load())
+	}
+	if rec := do("GET", "/items", "If-None-Match", `"1"`); rec.Code != http.StatusNotModified || calls.Load() != 1 {
+		t.Fatalf("want 304 from cache, got %d", rec.Code)
+	}
+
+	// request no-cache revalidates, expiry refetches
+	if rec := do("GET", "/items", "Cache-Control", "no-cache"); rec.Body.String() != "response 2" {
+		t.Fatalf("want fresh response for no-cache, got %q", rec.Body)
+	}
+	now = now.Add(61 * time.Second)
+	if rec := do("GET", "/items"); rec.Body.String() != "response 3" || rec.Header().Get("Cache-Status") != "xhttp; fwd=miss" {
+		t.Fatalf("want miss after expiry, got %q %v", rec.Body, rec.Header())
+	}
+
+	// unsafe requests invalidate
+	do("POST", "/items")
+	if rec := do("GET", "/items"); rec.Body.String() != "response 5" {
+		t.Fatalf("want miss after POST, got %q", rec.Body)
+	}
+
+	// not storable
+	for _, tt := range []struct{ path, header, value string }{
+		{"/private", "", ""},
+		{"/cookie", "", ""},
+		{"/none", "", ""},
+		{"/items?auth", "Authorization", "Bearer x"}, // public allows it
+	} {
+		before := calls.Load()
+		do("GET", tt.path, tt.header, tt.value)
+		do("GET", tt.path, tt.header, tt.value)
+		want := int32(2)
+		if tt.header == "Authorization" {
+			want = 1
+		}
+		if got := calls.Load() - before; got != want {
+			t.Fatalf("%s: want %d calls, got %d", tt.path, want, got)
+		}
+	}
+
+	// vary
+	before := calls.Load()
+	do("GET", "/lang", "Accept-Language", "en")
+	do("GET", "/lang", "Accept-Language", "en")
+	do("GET", "/lang", "Accept-Language", "de")
+	if got := calls.Load() - before; got != 2 {
+		t.Fatalf("want cache per Accept-Language, got %d calls", got)
+	}
+
+	cache.Purge()
+	if cache.Len() != 0 {
+		t.Fatalf("want empty cache after purge, got %d", cache.Len())
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	cache, _ := NewCache(&CacheConfig{MaxEntries: 2, MaxBodySize: 8})
+	h := cache.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.URL.Path))
+	}))
+	for _, p := range []string{"/a", "/b", "/a", "/c", "/too-large"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", p, nil))
+	}
+	if cache.Len() != 2 || cache.get("example.com/b", httptest.NewRequest("GET", "/b", nil)) != nil {
+		t.Fatalf("want least recently used evicted, got %d entries", cache.Len())
+	}
+
+	if _, err := NewCache(&CacheConfig{MaxEntries: -1}); err == nil {
+		t.Fatal("expected error for negative max entries")
+	}
+}
@@ -0,0 +1,438 @@
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xnet"
+)
+
+// Default values for [ClientConfig].
+const (
+	DefaultClientTimeout         = 10 * time.Second
+	DefaultClientMaxRetries      = 3
+	DefaultClientMaxRetryAfter   = 30 * time.Second
+	DefaultClientBreakerFailures = 5
+	DefaultClientBreakerCooldown = 30 * time.Second
+	DefaultClientMaxErrorBody    = 64 << 10
+)
+
+// ErrCircuitOpen is returned by [Client] for requests to a host whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// ClientConfig holds configuration options for [Client].
+type ClientConfig struct {
+	Timeout time.Duration // Max duration of each attempt, including reading the body. Default is 10 seconds. Negative to disable.
+
+	// MaxRetries is the max number of retries of idempotent requests after network errors and
+	// 429, 502, 503, and 504 responses. Default is 3. Negative to disable.
+	//
+	// Requests are idempotent if their method is GET, HEAD, OPTIONS, TRACE, PUT, or DELETE, or
+	// they have an Idempotency-Key header. Bodies must be replayable, see [http.Request.GetBody].
+	MaxRetries int
+
+	Backoff xnet.Backoff // Delays between retries. Default is the zero [xnet.Backoff].
+
+	// MaxRetryAfter is the longest Retry-After delay waited for. Longer ones fail immediately
+	// with the response's error. Default is 30 seconds.
+	MaxRetryAfter time.Duration
+
+	// BreakerFailures is the number of consecutive failures, network errors or 5xx responses,
+	// after which requests to a host fail with [ErrCircuitOpen]. Default is 5. Negative to disable.
+	BreakerFailures int
+
+	// BreakerCooldown is how long a breaker stays open, after which a single trial request is
+	// let through, closing it on success. Default is 30 seconds.
+	BreakerCooldown time.Duration
+
+	MaxErrorBody int64 // Max bytes of error responses read for [ResponseError]. Default is 64 KiB.
+
+	Transport http.RoundTripper // Default is [http.DefaultTransport].
+}
+
+// Client wraps [http.Client] for calls between services, with per-attempt timeouts, retries
+// with jittered exponential backoff honoring Retry-After, a circuit breaker per host, and
+// trace context propagation from [Tracer] spans.
+//
+// Unlike [http.Client], 4xx and 5xx responses are returned as a [*ResponseError], decoded
+// from an [ErrorBody] or a problem+json (RFC 9457) body, so errors cross service boundaries.
+//
+// Usage:
+//
+//	client, _ := xhttp.NewClient(&xhttp.ClientConfig{})
+//	var user User
+//	err := client.DoJSON(ctx, "GET", "http://users/v1/users/7", nil, &user)
+//	var re *xhttp.ResponseError
+//	if errors.As(err, &re) && re.Code == http.StatusNotFound { ... }
+//
+//	// or pass upstream errors on to the caller
+//	xhttp.ErrorJSON(ctx, w, xhttp.UpstreamErr(err))
+type Client struct {
+	cfg *ClientConfig
+	hc  *http.Client
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewClient creates a new Client with the provided configuration.
+func NewClient(cfg *ClientConfig) (*Client, error) {
+	copy := *cfg
+
+	if copy.MaxRetryAfter < 0 || copy.BreakerCooldown < 0 || copy.MaxErrorBody < 0 {
+		return nil, fmt.Errorf("max retry after, breaker cooldown, and max error body must not be negative")
+	}
+
+	// set defaults
+
+	if copy.Timeout == 0 {
+		copy.Timeout = DefaultClientTimeout
+	}
+	if copy.MaxRetries == 0 {
+		copy.MaxRetries = DefaultClientMaxRetries
+	}
+	if copy.MaxRetryAfter == 0 {
+		copy.MaxRetryAfter = DefaultClientMaxRetryAfter
+	}
+	if copy.BreakerFailures == 0 {
+		copy.BreakerFailures = DefaultClientBreakerFailures
+	}
+	if copy.BreakerCooldown == 0 {
+		copy.BreakerCooldown = DefaultClientBreakerCooldown
+	}
+	if copy.MaxErrorBody == 0 {
+		copy.MaxErrorBody = DefaultClientMaxErrorBody
+	}
+	if copy.Transport == nil {
+		copy.Transport = http.DefaultTransport
+	}
+
+	return &Client{
+		cfg:      &copy,
+		hc:       &http.Client{Transport: copy.Transport},
+		breakers: make(map[string]*breaker),
+	}, nil
+}
+
+// Do sends req, retrying as configured. Responses with status 400 and up are returned as
+// a [*ResponseError] with the body closed, others must be closed by the caller.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	retries := 0
+	if c.cfg.MaxRetries > 0 && isIdempotent(req) && (req.Body == nil || req.Body == http.NoBody || req.GetBody != nil) {
+		retries = c.cfg.MaxRetries
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(req, attempt)
+		if err == nil {
+			return resp, nil
+		}
+
+		delay := c.cfg.Backoff.Delay(attempt)
+		var re *ResponseError
+		switch {
+		case attempt >= retries || ctx.Err() != nil || errors.Is(err, ErrCircuitOpen):
+			return nil, err
+		case errors.As(err, &re):
+			if !retryableStatus(re.Code) {
+				return nil, err
+			}
+			if after, ok := parseRetryAfter(re.Header.Get("Retry-After")); ok {
+				if after > c.cfg.MaxRetryAfter {
+					return nil, err
+				}
+				delay = max(delay, after)
+			}
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+	}
+}
+
+// attempt sends a single attempt of req through the host's breaker.
+func (c *Client) attempt(req *http.Request, attempt int) (*http.Response, error) {
+	body := req.Body
+	if attempt > 0 && req.GetBody != nil {
+		var err error
+		if body, err = req.GetBody(); err != nil {
+			return nil, fmt.Errorf("failed to replay request body: %w", err)
+		}
+	}
+
+	b := c.breaker(req.URL.Host)
+	if !b.allow(time.Now()) {
+		if body != nil && body != http.NoBody {
+			body.Close()
+		}
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Redacted(), ErrCircuitOpen)
+	}
+
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if c.cfg.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+	}
+	r := req.Clone(ctx)
+	r.Body = body
+	InjectTraceContext(ctx, r.Header)
+
+	resp, err := c.hc.Do(r)
+	if err != nil {
+		cancel()
+		if req.Context().Err() != nil {
+			// canceled by the caller, not the host's fault
+			b.release()
+		} else {
+			b.record(false, time.Now())
+		}
+		return nil, err
+	}
+	b.record(resp.StatusCode < 500, time.Now())
+	if resp.StatusCode < 400 {
+		resp.Body = &cancelBody{ReadCloser: resp.Body, cancel: cancel}
+		return resp, nil
+	}
+	defer cancel()
+	defer resp.Body.Close()
+	return nil, decodeResponseError(resp, c.cfg.MaxErrorBody)
+}
+
+// cancelBody cancels the attempt's timeout context once the body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// DoJSON sends a request with in encoded as JSON, if non-nil, and decodes the response into
+// out, if non-nil. The encoded body is replayed when retrying idempotent methods like PUT.
+func (c *Client) DoJSON(ctx context.Context, method, url string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter parses a Retry-After header in seconds or as an HTTP date.
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// ResponseError is a 4xx or 5xx response received by [Client], decoded from an [ErrorBody],
+// a problem+json body (RFC 9457), or plain text.
+type ResponseError struct {
+	Code   int         // HTTP status code.
+	Msg    string      // ErrorBody message, problem title and detail, text body, or status text.
+	Type   string      // Problem type URI, if any.
+	Fields FieldErrors // ErrorBody fields, if any.
+
+	Method, URL string      // The request, with any password redacted.
+	Header      http.Header // The response headers.
+	Body        []byte      // The response body, up to [ClientConfig.MaxErrorBody] bytes.
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf(`%s %s: status: %d message: "%s"`, e.Method, e.URL, e.Code, e.Msg)
+}
+
+// Unwrap returns Fields, if any, so [ErrorJSON] can pass them on.
+func (e *ResponseError) Unwrap() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e.Fields
+}
+
+// UpstreamErr converts a [*ResponseError] in err's tree to an [Err] with the same status and
+// message, wrapping err, so handlers pass upstream errors on to their own callers with [Error]
+// or [ErrorJSON]. Other errors become a 502 Err, as the upstream call failed.
+func UpstreamErr(err error) *Err {
+	var re *ResponseError
+	if errors.As(err, &re) {
+		return &Err{Code: re.Code, Msg: re.Msg, Err: err}
+	}
+	return &Err{Code: http.StatusBadGateway, Msg: "Bad gateway", Err: err}
+}
+
+type problemBody struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+}
+
+func decodeResponseError(resp *http.Response, limit int64) *ResponseError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, limit))
+	e := &ResponseError{
+		Code:   resp.StatusCode,
+		Msg:    http.StatusText(resp.StatusCode),
+		Method: resp.Request.Method,
+		URL:    resp.Request.URL.Redacted(),
+		Header: resp.Header,
+		Body:   body,
+	}
+
+	ctype := strings.ToLower(resp.Header.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(ctype, "application/problem+json"):
+		var p problemBody
+		if json.Unmarshal(body, &p) == nil {
+			e.Type = p.Type
+			if msg := strings.Join(nonEmpty(p.Title, p.Detail), ": "); msg != "" {
+				e.Msg = msg
+			}
+		}
+	case strings.HasPrefix(ctype, "application/json"):
+		var b ErrorBody
+		if json.Unmarshal(body, &b) == nil && b.Message != "" {
+			e.Msg, e.Fields = b.Message, b.Fields
+		}
+	case strings.HasPrefix(ctype, "text/plain"):
+		if msg := strings.TrimSpace(string(body)); msg != "" && len(msg) <= 512 {
+			e.Msg = msg
+		}
+	}
+	return e
+}
+
+func nonEmpty(strs ...string) []string {
+	out := strs[:0]
+	for _, s := range strs {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func (c *Client) breaker(host string) *breaker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b := c.breakers[host]
+	if b == nil {
+		b = &breaker{threshold: c.cfg.BreakerFailures, cooldown: c.cfg.BreakerCooldown}
+		c.breakers[host] = b
+	}
+	return b
+}
+
+// breaker is a per-host circuit breaker. It opens after threshold consecutive failures, and
+// after cooldown lets a single trial request through, closing on its success.
+type breaker struct {
+	threshold int // negative to disable
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time // zero when closed
+	trial    bool      // a trial request is in flight
+}
+
+func (b *breaker) allow(now time.Time) bool {
+	if b.threshold < 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openedAt.IsZero() {
+		return true
+	}
+	if b.trial || now.Sub(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// release ends a trial request without an outcome, e.g. when the caller canceled it.
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+func (b *breaker) record(ok bool, now time.Time) {
+	if b.threshold < 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+	if ok {
+		b.failures, b.openedAt = 0, time.Time{}
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = now
+	}
+}
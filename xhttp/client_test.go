@@ -0,0 +1,260 @@
+package xhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xnet"
+)
+
+// fastBackoff keeps retry tests quick.
+var fastBackoff = xnet.Backoff{Base: time.Millisecond, Max: 5 * time.Millisecond}
+
+func TestClientRetries(t *testing.T) {
+	var calls atomic.Int32
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"name":"ada"}`))
+	}))
+	defer srv.Close()
+
+	c, _ := NewClient(&ClientConfig{Backoff: fastBackoff})
+	var out struct{ Name string }
+	if err := c.DoJSON(context.Background(), "PUT", srv.URL, map[string]int{"n": 1}, &out); err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if out.Name != "ada" || calls.Load() != 3 {
+		t.Fatalf("want success on 3rd attempt, got %q after %d", out.Name, calls.Load())
+	}
+	for _, b := range bodies {
+		if b != `{"n":1}` {
+			t.Fatalf("body not replayed: %q", bodies)
+		}
+	}
+
+	// non-idempotent requests are sent once
+	calls.Store(0)
+	err := c.DoJSON(context.Background(), "POST", srv.URL, map[string]int{"n": 1}, nil)
+	var re *ResponseError
+	if !errors.As(err, &re) || re.Code != http.StatusServiceUnavailable || calls.Load() != 1 {
+		t.Fatalf("want single 503, got %v after %d calls", err, calls.Load())
+	}
+
+	// unless they have an idempotency key
+	calls.Store(0)
+	req, _ := http.NewRequest("POST", srv.URL, strings.NewReader("x"))
+	req.Header.Set("Idempotency-Key", "abc")
+	resp, err := c.Do(req)
+	if err != nil || calls.Load() != 3 {
+		t.Fatalf("want success after retries, got %v after %d calls", err, calls.Load())
+	}
+	resp.Body.Close()
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	var calls atomic.Int32
+	var first, second time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			second = time.Now()
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}))
+	defer srv.Close()
+
+	c, _ := NewClient(&ClientConfig{Backoff: fastBackoff})
+	err := c.DoJSON(context.Background(), "GET", srv.URL, nil, nil)
+	var re *ResponseError
+	if !errors.As(err, &re) || re.Code != http.StatusTooManyRequests {
+		t.Fatalf("want 429 error, got %v", err)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("want no retry past MaxRetryAfter, got %d calls", calls.Load())
+	}
+	if waited := second.Sub(first); waited < time.Second {
+		t.Fatalf("Retry-After not honored, waited %s", waited)
+	}
+}
+
+func TestClientTimeoutRetry(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			<-r.Context().Done() // hang until the attempt times out
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	c, _ := NewClient(&ClientConfig{Timeout: 50 * time.Millisecond, Backoff: fastBackoff})
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	defer resp.Body.Close()
+	if b, _ := io.ReadAll(resp.Body); string(b) != "ok" || calls.Load() != 2 {
+		t.Fatalf("want ok on 2nd attempt, got %q after %d", b, calls.Load())
+	}
+}
+
+func TestClientResponseErrors(t *testing.T) {
+	tests := []struct {
+		name, ctype, body string
+		code              int
+		msg, typ          string
+		fields            int
+	}{
+		{"error body", "application/json; charset=utf-8", `{"code":422,"message":"Invalid input","fields":[{"field":"email","rule":"email","message":"must be an email"}]}`, 422, "Invalid input", "", 1},
+		{"problem", "application/problem+json", `{"type":"https://example.com/out-of-credit","title":"Out of credit","detail":"Balance is 30"}`, 403, "Out of credit: Balance is 30", "https://example.com/out-of-credit", 0},
+		{"text", "text/plain; charset=utf-8", "Not found\n", 404, "Not found", "", 0},
+		{"html", "text/html", "<h1>oops</h1>", 500, "Internal Server Error", "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.ctype)
+				w.WriteHeader(tt.code)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c, _ := NewClient(&ClientConfig{MaxRetries: -1})
+			err := c.DoJSON(context.Background(), "GET", srv.URL, nil, nil)
+			var re *ResponseError
+			if !errors.As(err, &re) {
+				t.Fatalf("want ResponseError, got %v", err)
+			}
+			if re.Code != tt.code || re.Msg != tt.msg || re.Type != tt.typ || len(re.Fields) != tt.fields || string(re.Body) != tt.body {
+				t.Fatalf("unexpected error %+v", re)
+			}
+
+			// passed on to the caller
+			rec := httptest.NewRecorder()
+			ErrorJSON(context.Background(), rec, UpstreamErr(err))
+			if rec.Code != tt.code || !strings.Contains(rec.Body.String(), tt.msg) {
+				t.Fatalf("want %d %q passed on, got %d %q", tt.code, tt.msg, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	if e := UpstreamErr(errors.New("dial tcp: connection refused")); e.Code != http.StatusBadGateway {
+		t.Fatalf("want 502 for transport errors, got %d", e.Code)
+	}
+}
+
+func TestClientBreaker(t *testing.T) {
+	var calls atomic.Int32
+	var healthy atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	c, _ := NewClient(&ClientConfig{MaxRetries: -1, BreakerFailures: 2, BreakerCooldown: 50 * time.Millisecond})
+	get := func() error { return c.DoJSON(context.Background(), "GET", srv.URL, nil, nil) }
+
+	get()
+	get()
+	if err := get(); !errors.Is(err, ErrCircuitOpen) || calls.Load() != 2 {
+		t.Fatalf("want open breaker after 2 failures, got %v after %d calls", err, calls.Load())
+	}
+
+	// a failed trial reopens it
+	time.Sleep(60 * time.Millisecond)
+	if err := get(); errors.Is(err, ErrCircuitOpen) || calls.Load() != 3 {
+		t.Fatalf("want trial request after cooldown, got %v", err)
+	}
+	if err := get(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("want reopened breaker, got %v", err)
+	}
+
+	// a successful trial closes it
+	healthy.Store(true)
+	time.Sleep(60 * time.Millisecond)
+	for range 3 {
+		if err := get(); err != nil {
+			t.Fatalf("want closed breaker, got %v", err)
+		}
+	}
+}
+
+func TestClientBreakerIgnoresCancel(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer srv.Close()
+
+	c, _ := NewClient(&ClientConfig{MaxRetries: -1, BreakerFailures: 2})
+	for range 3 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		err := c.DoJSON(ctx, "GET", srv.URL+"/slow", nil, nil)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("want caller deadline error, got %v", err)
+		}
+	}
+	if err := c.DoJSON(context.Background(), "GET", srv.URL, nil, nil); err != nil {
+		t.Fatalf("want closed breaker after canceled requests, got %v", err)
+	}
+
+	// a canceled trial request lets the next one through
+	b := c.breaker(strings.TrimPrefix(srv.URL, "http://"))
+	b.record(false, time.Now().Add(-time.Hour))
+	b.record(false, time.Now().Add(-time.Hour))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.DoJSON(ctx, "GET", srv.URL+"/slow", nil, nil)
+	if err := c.DoJSON(context.Background(), "GET", srv.URL, nil, nil); err != nil {
+		t.Fatalf("want trial request after a canceled one, got %v", err)
+	}
+}
+
+func TestClientTracePropagation(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("traceparent")
+	}))
+	defer srv.Close()
+
+	tracer, _ := NewTracer(&TracerConfig{})
+	ctx, span := tracer.Start(context.Background(), "call")
+	defer span.End()
+	c, _ := NewClient(&ClientConfig{})
+	if err := c.DoJSON(ctx, "GET", srv.URL, nil, nil); err != nil {
+		t.Fatalf("do: %v", err)
+	}
+	if got != span.SpanContext().Traceparent() {
+		t.Fatalf("want traceparent %q, got %q", span.SpanContext().Traceparent(), got)
+	}
+}
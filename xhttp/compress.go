@@ -0,0 +1,291 @@
+package xhttp
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressMinSize is the default minimum response size for compression.
+const DefaultCompressMinSize = 1024
+
+// DefaultCompressTypes are the default content types eligible for compression. Entries
+// ending in "/" match any subtype.
+var DefaultCompressTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/manifest+json",
+	"application/problem+json",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+// CompressConfig holds configuration options for [Compressor].
+type CompressConfig struct {
+	// Level is the compression level, from [gzip.BestSpeed] to [gzip.BestCompression], or
+	// [gzip.HuffmanOnly], see [compress/flate]. Default is [gzip.DefaultCompression], also for
+	// 0, as [gzip.NoCompression] would only add framing to responses.
+	Level int
+
+	MinSize      int      // Responses smaller than this are sent uncompressed. Default is 1 KB.
+	ContentTypes []string // Content types eligible for compression. Default is [DefaultCompressTypes].
+}
+
+// Compressor is a middleware that transparently compresses responses with gzip or deflate,
+// based on the request's Accept-Encoding header.
+//
+// Responses are buffered until MinSize bytes are written, so small responses are sent as
+// is. Calling Flush, e.g. for streaming, commits to compression early. Responses that
+// already have a Content-Encoding, requests with a Range header, and content types not in
+// ContentTypes are passed through untouched. Strong ETags of compressed responses are made
+// weak, as the encoded bytes differ from the identity response. Compressors are pooled.
+type Compressor struct {
+	cfg       *CompressConfig
+	gzipPool  sync.Pool
+	flatePool sync.Pool
+}
+
+// NewCompressor creates a new Compressor with the provided configuration.
+func NewCompressor(cfg *CompressConfig) (*Compressor, error) {
+	copy := *cfg
+
+	if copy.Level == 0 {
+		copy.Level = gzip.DefaultCompression
+	}
+	if copy.Level < gzip.HuffmanOnly || copy.Level > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid compression level %d", copy.Level)
+	}
+
+	// set defaults
+
+	if copy.MinSize <= 0 {
+		copy.MinSize = DefaultCompressMinSize
+	}
+	if len(copy.ContentTypes) == 0 {
+		copy.ContentTypes = DefaultCompressTypes
+	}
+
+	c := &Compressor{cfg: &copy}
+	c.gzipPool.New = func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, copy.Level) // level is validated above
+		return w
+	}
+	c.flatePool.New = func() any {
+		w, _ := flate.NewWriter(io.Discard, copy.Level)
+		return w
+	}
+	return c, nil
+}
+
+// Middleware compresses responses from next.
+func (c *Compressor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Header.Get("Range") != "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, c: c, encoding: encoding}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+func (c *Compressor) allowedType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	for _, t := range c.cfg.ContentTypes {
+		if strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) || mediaType == t {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header, by quality value
+// with gzip winning ties. Returns "" if neither is acceptable.
+func negotiateEncoding(header string) string {
+	gzipQ, deflateQ := encodingQ(header, "gzip"), encodingQ(header, "deflate")
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	}
+	return ""
+}
+
+// encodingQ returns the quality value of coding in an Accept-Encoding header, falling back to
+// that of "*", or 0 if coding isn't acceptable.
+func encodingQ(header, coding string) float64 {
+	q, starQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		v := 1.0
+		if k, val, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil {
+				v = f
+			}
+		}
+		switch name = strings.ToLower(strings.TrimSpace(name)); {
+		case name == coding, coding == "gzip" && name == "x-gzip":
+			q = v
+		case name == "*":
+			starQ = v
+		}
+	}
+	if q < 0 {
+		q = starQ
+	}
+	return max(q, 0)
+}
+
+// compressWriter buffers the start of a response to decide whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	c        *Compressor
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	enc      interface {
+		io.WriteCloser
+		Flush() error
+		Reset(io.Writer)
+	}
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided || cw.status != 0 {
+		if cw.decided {
+			cw.ResponseWriter.WriteHeader(code) // let net/http report superfluous calls
+		}
+		return
+	}
+	if code >= 100 && code < 200 { // informational, pass through
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+	if !bodyAllowed(code) {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if cw.decided {
+		if cw.enc != nil {
+			return cw.enc.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= cw.c.cfg.MinSize {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush commits to a decision, compressing regardless of size if otherwise eligible,
+// then flushes the compressor and the underlying writer.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+		cw.decide(true)
+	}
+	if cw.enc != nil {
+		cw.enc.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Unwrap allows [http.ResponseController] to reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// decide writes the header and any buffered data, compressing if sizeOK and the
+// response is otherwise eligible.
+func (cw *compressWriter) decide(sizeOK bool) error {
+	cw.decided = true
+	h := cw.Header()
+
+	compress := sizeOK && bodyAllowed(cw.status) && h.Get("Content-Encoding") == ""
+	if compress {
+		if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+			h.Set("Content-Type", http.DetectContentType(cw.buf)) // what net/http would do anyway
+		}
+		compress = cw.c.allowedType(h.Get("Content-Type"))
+	}
+
+	if compress {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+		if cw.encoding == "gzip" {
+			cw.enc = cw.c.gzipPool.Get().(*gzip.Writer)
+		} else {
+			cw.enc = cw.c.flatePool.Get().(*flate.Writer)
+		}
+		cw.enc.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(cw.buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(cw.buf)
+	}
+	cw.buf = nil
+	return err
+}
+
+// close flushes anything still buffered and returns the compressor to its pool.
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		if cw.status == 0 {
+			return // nothing written, let net/http send its implicit 200
+		}
+		cw.decide(false)
+	}
+	if cw.enc == nil {
+		return
+	}
+	cw.enc.Close()
+	cw.enc.Reset(io.Discard) // drop the reference to the response writer
+	if cw.encoding == "gzip" {
+		cw.c.gzipPool.Put(cw.enc)
+	} else {
+		cw.c.flatePool.Put(cw.enc)
+	}
+	cw.enc = nil
+}
+
+// bodyAllowed reports whether a response with the status code may have a body.
+func bodyAllowed(code int) bool {
+	return code != http.StatusNoContent && code != http.StatusNotModified && (code < 100 || code >= 200)
+}
@@ -0,0 +1,180 @@
+package xhttp
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                         "",
+		"identity":                 "",
+		"gzip":                     "gzip",
+		"deflate":                  "deflate",
+		"gzip, deflate, br":        "gzip",
+		"gzip;q=0.5, deflate":      "deflate",
+		"gzip;q=0, deflate;q=0":    "",
+		"*":                        "gzip",
+		"*;q=0.1, gzip;q=0":        "deflate",
+		"br, x-gzip;q=0.8":         "gzip",
+		" GZIP ; q=1.0 , deflate ": "gzip",
+	}
+	for header, want := range tests {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q): want %q, got %q", header, want, got)
+		}
+	}
+}
+
+func serveCompressed(t *testing.T, c *Compressor, acceptEncoding string, h http.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	c.Middleware(h).ServeHTTP(rec, r)
+	return rec
+}
+
+func TestCompressorGzip(t *testing.T) {
+	c, _ := NewCompressor(&CompressConfig{MinSize: 16})
+	body := strings.Repeat(`{"hello":"world"}`, 10)
+	rec := serveCompressed(t, c, "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", "170")
+		io.WriteString(w, body)
+	})
+
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("want gzip encoding, got %q", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Fatalf("want Vary Accept-Encoding, got %q", got)
+	}
+	if rec.Header().Get("Content-Length") != "" {
+		t.Fatal("Content-Length should be removed")
+	}
+	zr, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("gzip reader: %v", err)
+	}
+	got, _ := io.ReadAll(zr)
+	if string(got) != body {
+		t.Fatalf("body mismatch: %q", got)
+	}
+}
+
+func TestCompressorWeakensETag(t *testing.T) {
+	c, _ := NewCompressor(&CompressConfig{MinSize: 16})
+	body := strings.Repeat("hello world ", 10)
+	for _, tt := range []struct{ etag, want, encoding string }{
+		{`"v1"`, `W/"v1"`, "gzip"},
+		{`W/"v1"`, `W/"v1"`, "gzip"},
+		{`"v1"`, `"v1"`, ""},
+	} {
+		rec := serveCompressed(t, c, tt.encoding, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", tt.etag)
+			io.WriteString(w, body)
+		})
+		if got := rec.Header().Get("ETag"); got != tt.want {
+			t.Fatalf("ETag %s with encoding %q: want %s, got %s", tt.etag, tt.encoding, tt.want, got)
+		}
+	}
+}
+
+func TestCompressorDeflate(t *testing.T) {
+	c, _ := NewCompressor(&CompressConfig{MinSize: 16})
+	body := strings.Repeat("hello world ", 10)
+	rec := serveCompressed(t, c, "deflate", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body) // content type sniffed as text/plain
+	})
+
+	if got := rec.Header().Get("Content-Encoding"); got != "deflate" {
+		t.Fatalf("want deflate encoding, got %q", got)
+	}
+	got, _ := io.ReadAll(flate.NewReader(rec.Body))
+	if string(got) != body {
+		t.Fatalf("body mismatch: %q", got)
+	}
+}
+
+func TestCompressorSkips(t *testing.T) {
+	c, _ := NewCompressor(&CompressConfig{MinSize: 16})
+	long := strings.Repeat("a", 64)
+
+	tests := []struct {
+		name   string
+		accept string
+		h      http.HandlerFunc
+	}{
+		{"not accepted", "", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, long) }},
+		{"too small", "gzip", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "tiny") }},
+		{"wrong type", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, long)
+		}},
+		{"already encoded", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, long)
+		}},
+		{"no content", "gzip", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveCompressed(t, c, tt.accept, tt.h)
+			if enc := rec.Header().Get("Content-Encoding"); enc == "gzip" {
+				t.Fatalf("response should not be gzipped")
+			}
+		})
+	}
+}
+
+func TestCompressorStatusPreserved(t *testing.T) {
+	c, _ := NewCompressor(&CompressConfig{MinSize: 16})
+	rec := serveCompressed(t, c, "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "small")
+	})
+	if rec.Code != http.StatusCreated || rec.Body.String() != "small" {
+		t.Fatalf("unexpected response: %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCompressorFlushStreams(t *testing.T) {
+	c, _ := NewCompressor(&CompressConfig{})
+	rec := serveCompressed(t, c, "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		if !underlyingFlushed(w) {
+			t.Error("underlying writer should have been flushed")
+		}
+		io.WriteString(w, "data: 2\n\n")
+	})
+
+	if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("flush should commit to compression, got %q", got)
+	}
+	zr, _ := gzip.NewReader(rec.Body)
+	got, _ := io.ReadAll(zr)
+	if string(got) != "data: 1\n\ndata: 2\n\n" {
+		t.Fatalf("body mismatch: %q", got)
+	}
+}
+
+func underlyingFlushed(w http.ResponseWriter) bool {
+	rec, ok := w.(*compressWriter).ResponseWriter.(*httptest.ResponseRecorder)
+	return ok && rec.Flushed
+}
+
+func TestNewCompressorInvalidLevel(t *testing.T) {
+	if _, err := NewCompressor(&CompressConfig{Level: 42}); err == nil {
+		t.Fatal("expected error for invalid level")
+	}
+}
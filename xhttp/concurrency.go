@@ -0,0 +1,205 @@
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default values for [ConcurrencyLimitConfig].
+const (
+	DefaultConcurrencyQueueTimeout = 1 * time.Second
+	DefaultConcurrencyRetryAfter   = 1 * time.Second
+)
+
+// ConcurrencyLimitConfig holds configuration options for [ConcurrencyLimiter].
+type ConcurrencyLimitConfig struct {
+	Limit int // Max number of in-flight requests. Required. In adaptive mode this is the upper bound.
+
+	// QueueSize is the max number of requests waiting for a slot. Default is Limit. Negative to
+	// disable queuing, shedding excess requests immediately.
+	QueueSize    int
+	QueueTimeout time.Duration // Max time a request waits in the queue before being shed. Default is 1 second.
+	RetryAfter   time.Duration // Value of the Retry-After header sent with shed requests. Default is 1 second.
+
+	// Adaptive, if true, shrinks the limit when request latency rises above TargetLatency
+	// and slowly grows it back toward Limit while latency stays below it (AIMD).
+	Adaptive      bool
+	TargetLatency time.Duration // Latency above which the adaptive limit shrinks. Required if Adaptive is true.
+	MinLimit      int           // Lower bound for the adaptive limit. Default is 1.
+
+	// ErrorHandler sends the 503 [Err] for shed requests. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// ConcurrencyLimiter caps the number of in-flight requests, queuing excess requests for a
+// bounded time and shedding the rest with a 503 [Err] and a Retry-After header. Protects
+// single-process apps from overload collapse, where everything slows down instead of some
+// requests failing fast.
+type ConcurrencyLimiter struct {
+	cfg      *ConcurrencyLimitConfig
+	mu       sync.Mutex
+	limit    float64 // current limit, fractional so additive increase can be gradual
+	inFlight int
+	queue    []*waiter
+	lastDrop time.Time
+	now      func() time.Time
+}
+
+type waiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+// NewConcurrencyLimiter creates a new ConcurrencyLimiter with the provided configuration.
+func NewConcurrencyLimiter(cfg *ConcurrencyLimitConfig) (*ConcurrencyLimiter, error) {
+	copy := *cfg
+
+	if copy.Limit <= 0 {
+		return nil, fmt.Errorf("concurrency limit must be greater than zero")
+	}
+	if copy.Adaptive && copy.TargetLatency <= 0 {
+		return nil, fmt.Errorf("target latency must be provided when adaptive is enabled")
+	}
+
+	// set defaults
+
+	if copy.QueueSize == 0 {
+		copy.QueueSize = copy.Limit
+	}
+	if copy.QueueTimeout <= 0 {
+		copy.QueueTimeout = DefaultConcurrencyQueueTimeout
+	}
+	if copy.RetryAfter <= 0 {
+		copy.RetryAfter = DefaultConcurrencyRetryAfter
+	}
+	if copy.MinLimit <= 0 {
+		copy.MinLimit = 1
+	}
+	if copy.MinLimit > copy.Limit {
+		copy.MinLimit = copy.Limit
+	}
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	return &ConcurrencyLimiter{
+		cfg:   &copy,
+		limit: float64(copy.Limit),
+		now:   time.Now,
+	}, nil
+}
+
+// Limit returns the current in-flight request limit. Only differs from the
+// configured Limit in adaptive mode.
+func (cl *ConcurrencyLimiter) Limit() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return int(cl.limit)
+}
+
+// InFlight returns the number of requests currently being handled.
+func (cl *ConcurrencyLimiter) InFlight() int {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.inFlight
+}
+
+// Middleware limits the number of concurrent requests handled by next.
+func (cl *ConcurrencyLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := cl.acquire(r.Context()); err != nil {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(cl.cfg.RetryAfter)))
+			cl.cfg.ErrorHandler(r.Context(), w, &Err{
+				Code: http.StatusServiceUnavailable,
+				Msg:  "Server is busy, please try again later",
+				Err:  err,
+			})
+			return
+		}
+		start := cl.now()
+		defer func() { cl.release(cl.now().Sub(start)) }()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes an in-flight slot, waiting in the queue if needed.
+func (cl *ConcurrencyLimiter) acquire(ctx context.Context) error {
+	cl.mu.Lock()
+	if cl.inFlight < int(cl.limit) {
+		cl.inFlight++
+		cl.mu.Unlock()
+		return nil
+	}
+	if len(cl.queue) >= cl.cfg.QueueSize {
+		cl.mu.Unlock()
+		return fmt.Errorf("request shed: %d in flight, queue full", cl.inFlight)
+	}
+	wt := &waiter{ready: make(chan struct{})}
+	cl.queue = append(cl.queue, wt)
+	cl.mu.Unlock()
+
+	timer := time.NewTimer(cl.cfg.QueueTimeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-wt.ready:
+		return nil
+	case <-timer.C:
+		err = fmt.Errorf("request shed: queued longer than %s", cl.cfg.QueueTimeout)
+	case <-ctx.Done():
+		err = fmt.Errorf("request shed: %w", ctx.Err())
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if wt.granted { // lost the race with release, the slot is ours
+		return nil
+	}
+	for i, q := range cl.queue {
+		if q == wt {
+			cl.queue = append(cl.queue[:i], cl.queue[i+1:]...)
+			break
+		}
+	}
+	return err
+}
+
+// release frees an in-flight slot, adjusts the adaptive limit, and hands free slots to the queue.
+func (cl *ConcurrencyLimiter) release(latency time.Duration) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.inFlight--
+	if cl.cfg.Adaptive {
+		cl.adapt(latency)
+	}
+	for len(cl.queue) > 0 && cl.inFlight < int(cl.limit) {
+		wt := cl.queue[0]
+		cl.queue = cl.queue[1:]
+		wt.granted = true
+		cl.inFlight++
+		close(wt.ready)
+	}
+}
+
+// adapt applies AIMD to the limit. Decreases happen at most once per TargetLatency so a
+// burst of slow responses from the same overload doesn't collapse the limit to MinLimit.
+// Assumes mutex is held by caller.
+func (cl *ConcurrencyLimiter) adapt(latency time.Duration) {
+	max, min := float64(cl.cfg.Limit), float64(cl.cfg.MinLimit)
+	if latency > cl.cfg.TargetLatency {
+		now := cl.now()
+		if now.Sub(cl.lastDrop) >= cl.cfg.TargetLatency {
+			cl.lastDrop = now
+			cl.limit = math.Max(min, math.Floor(cl.limit*0.9))
+		}
+		return
+	}
+	cl.limit = math.Min(max, cl.limit+1/cl.limit)
+}
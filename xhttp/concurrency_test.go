@@ -0,0 +1,136 @@
+package xhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewConcurrencyLimiterValidation(t *testing.T) {
+	if _, err := NewConcurrencyLimiter(&ConcurrencyLimitConfig{}); err == nil {
+		t.Fatal("expected error when Limit is zero")
+	}
+	if _, err := NewConcurrencyLimiter(&ConcurrencyLimitConfig{Limit: 1, Adaptive: true}); err == nil {
+		t.Fatal("expected error when Adaptive without TargetLatency")
+	}
+}
+
+// blockingHandler blocks until release is closed, signaling entered for each request.
+func blockingHandler(entered chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+}
+
+func TestConcurrencyLimiterShedsWhenQueueFull(t *testing.T) {
+	cl, _ := NewConcurrencyLimiter(&ConcurrencyLimitConfig{Limit: 1, QueueSize: -1, RetryAfter: 3 * time.Second})
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	h := cl.Middleware(blockingHandler(entered, release))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want 503, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Fatalf("want Retry-After 3, got %q", got)
+	}
+
+	close(release)
+	wg.Wait()
+	if got := cl.InFlight(); got != 0 {
+		t.Fatalf("want 0 in flight, got %d", got)
+	}
+}
+
+func TestConcurrencyLimiterQueues(t *testing.T) {
+	cl, _ := NewConcurrencyLimiter(&ConcurrencyLimitConfig{Limit: 1, QueueTimeout: 2 * time.Second})
+	entered, release := make(chan struct{}, 2), make(chan struct{})
+	h := cl.Middleware(blockingHandler(entered, release))
+
+	codes := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			codes <- rec.Code
+		}()
+	}
+	<-entered
+	select {
+	case <-entered:
+		t.Fatal("second request should be queued, not running")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Fatalf("want 200, got %d", code)
+		}
+	}
+}
+
+func TestConcurrencyLimiterQueueTimeout(t *testing.T) {
+	cl, _ := NewConcurrencyLimiter(&ConcurrencyLimitConfig{Limit: 1, QueueTimeout: 20 * time.Millisecond})
+	entered, release := make(chan struct{}, 1), make(chan struct{})
+	h := cl.Middleware(blockingHandler(entered, release))
+	defer close(release)
+
+	go h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-entered
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("want 503, got %d", rec.Code)
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if len(cl.queue) != 0 {
+		t.Fatalf("timed out waiter should be removed from queue")
+	}
+}
+
+func TestConcurrencyLimiterAdaptive(t *testing.T) {
+	cl, _ := NewConcurrencyLimiter(&ConcurrencyLimitConfig{Limit: 10, MinLimit: 2, Adaptive: true, TargetLatency: 100 * time.Millisecond})
+	now := time.Unix(0, 0)
+	cl.now = func() time.Time { return now }
+
+	// slow responses shrink the limit, at most once per TargetLatency
+	for i := 0; i < 5; i++ {
+		cl.acquire(t.Context())
+		cl.release(time.Second)
+	}
+	if got := cl.Limit(); got != 9 {
+		t.Fatalf("want limit 9 after one drop window, got %d", got)
+	}
+	for i := 0; i < 20; i++ {
+		now = now.Add(100 * time.Millisecond)
+		cl.acquire(t.Context())
+		cl.release(time.Second)
+	}
+	if got := cl.Limit(); got != 2 {
+		t.Fatalf("want limit clamped to MinLimit 2, got %d", got)
+	}
+
+	// fast responses grow it back, but never past Limit
+	for i := 0; i < 200; i++ {
+		cl.acquire(t.Context())
+		cl.release(time.Millisecond)
+	}
+	if got := cl.Limit(); got != 10 {
+		t.Fatalf("want limit back at 10, got %d", got)
+	}
+}
@@ -0,0 +1,287 @@
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultETagMaxBody is the default [ETagConfig.MaxBodySize].
+const DefaultETagMaxBody = 1 << 20 // 1 MiB
+
+// ETag returns a strong ETag for content, a quoted hash.
+func ETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+}
+
+// WeakETag returns a weak ETag for content, for responses that are equivalent but not
+// byte-for-byte identical across requests, e.g. when compressed afterwards.
+func WeakETag(content []byte) string {
+	return "W/" + ETag(content)
+}
+
+// CheckPreconditions evaluates the conditional request headers of r against the current etag
+// and modTime of the resource, in the order of RFC 9110 section 13.2.2. Either may be empty or
+// zero if unknown, but not both for an existing resource, as a resource with neither is taken
+// not to exist, so "If-Match: *" fails and "If-None-Match: *" passes. Set headers are sent as
+// ETag and Last-Modified.
+//
+// It returns nil if the request should proceed, or an [Err] to send with [Error]: 304 for
+// GET and HEAD requests the client has a fresh copy of, 412 for failed If-Match and
+// If-Unmodified-Since preconditions, typically lost updates, and for If-None-Match on other
+// methods. The Error functions send 304s without a body or log line, as they aren't failures.
+//
+// Usage:
+//
+//	func getItem(w http.ResponseWriter, r *http.Request) {
+//		item := load(r.PathValue("id"))
+//		if err := xhttp.CheckPreconditions(w, r, item.Version, item.Updated); err != nil {
+//			xhttp.Error(r.Context(), w, err)
+//			return
+//		}
+//		...
+//	}
+func CheckPreconditions(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time) error {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	exists := etag != "" || !modTime.IsZero()
+	modTime = modTime.Truncate(time.Second) // HTTP dates have second precision
+
+	if im := r.Header.Get("If-Match"); im != "" {
+		if !etagMatch(im, etag, exists, false) {
+			return &Err{Code: http.StatusPreconditionFailed, Msg: "Precondition failed", Err: fmt.Errorf("If-Match %s doesn't match %s", im, etag)}
+		}
+	} else if ius, err := http.ParseTime(r.Header.Get("If-Unmodified-Since")); err == nil && !modTime.IsZero() && modTime.After(ius) {
+		return &Err{Code: http.StatusPreconditionFailed, Msg: "Precondition failed", Err: fmt.Errorf("modified since %s", ius.Format(http.TimeFormat))}
+	}
+
+	getOrHead := r.Method == http.MethodGet || r.Method == http.MethodHead
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatch(inm, etag, exists, true) {
+			return nil
+		}
+		if getOrHead {
+			return &Err{Code: http.StatusNotModified, Msg: "Not modified", Err: errors.New("If-None-Match matched")}
+		}
+		return &Err{Code: http.StatusPreconditionFailed, Msg: "Precondition failed", Err: fmt.Errorf("If-None-Match %s matched", inm)}
+	}
+	if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && getOrHead && !modTime.IsZero() && !modTime.After(ims) {
+		return &Err{Code: http.StatusNotModified, Msg: "Not modified", Err: errors.New("not modified since If-Modified-Since")}
+	}
+	return nil
+}
+
+// etagMatch reports whether the If-Match or If-None-Match list matches etag, with the weak
+// comparison for If-None-Match and the strong one for If-Match. "*" matches any existing
+// resource, with or without an etag.
+func etagMatch(list, etag string, exists, weak bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return exists
+	}
+	if etag == "" {
+		return false
+	}
+	etagWeak, opaque := strings.HasPrefix(etag, "W/"), strings.TrimPrefix(etag, "W/")
+	for {
+		list = strings.TrimLeft(list, " \t,")
+		if list == "" {
+			return false
+		}
+		candWeak := strings.HasPrefix(list, "W/")
+		list = strings.TrimPrefix(list, "W/")
+		// entity tags are quoted and may contain commas, so scan to the closing quote
+		if len(list) < 2 || list[0] != '"' {
+			return false
+		}
+		end := strings.IndexByte(list[1:], '"')
+		if end < 0 {
+			return false
+		}
+		cand := list[:end+2]
+		list = list[end+2:]
+		if cand == opaque && (weak || (!candWeak && !etagWeak)) {
+			return true
+		}
+	}
+}
+
+// writeNotModified sends a 304 for a 304 [Err], which isn't a failure, so it's neither logged
+// nor given a body. It reports whether it did.
+func writeNotModified(w http.ResponseWriter, err error) bool {
+	var e *Err
+	if !errors.As(err, &e) || e.Code != http.StatusNotModified {
+		return false
+	}
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// ETagConfig holds configuration options for [ETagger].
+type ETagConfig struct {
+	// Weak sends weak ETags, needed when anything changing the bytes wraps the ETagger, as a
+	// strong ETag promises byte-identical responses. A [Compressor] weakens them itself.
+	Weak bool
+
+	// MaxBodySize is the largest response buffered for hashing. Larger ones, and those the
+	// handler flushes, are streamed without an ETag. Default is 1 MiB.
+	MaxBodySize int64
+
+	// ErrorHandler sends the 304 and 412 [Err]s. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// ETagger is a middleware adding ETags to GET and HEAD responses by buffering and hashing
+// them, and answering conditional requests with [CheckPreconditions]. Clients revalidating
+// an unchanged response get a 304 without the body, saving bandwidth, though the handler
+// still runs. Handlers can set their own ETag, e.g. a version, which is used as is.
+//
+// HEAD requests are served as GET requests with the body dropped, as handlers often write no
+// body for HEAD, which would give them a different ETag and Content-Length than GET.
+type ETagger struct {
+	cfg *ETagConfig
+}
+
+// NewETagger creates a new ETagger with the provided configuration.
+func NewETagger(cfg *ETagConfig) (*ETagger, error) {
+	copy := *cfg
+
+	if copy.MaxBodySize < 0 {
+		return nil, fmt.Errorf("ETag max body size must not be negative")
+	}
+
+	// set defaults
+
+	if copy.MaxBodySize == 0 {
+		copy.MaxBodySize = DefaultETagMaxBody
+	}
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	return &ETagger{cfg: &copy}, nil
+}
+
+// Middleware returns the ETag middleware wrapping next.
+func (et *ETagger) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		head := r.Method == http.MethodHead
+		req := r
+		if head {
+			req = r.Clone(r.Context())
+			req.Method = http.MethodGet
+		}
+		bw := &bufferedWriter{ResponseWriter: w, max: et.cfg.MaxBodySize}
+		next.ServeHTTP(bw, req)
+		if bw.streaming {
+			return
+		}
+		if bw.status == 0 {
+			bw.status = http.StatusOK
+		}
+
+		h := w.Header()
+		if bw.status == http.StatusOK {
+			etag := h.Get("ETag")
+			if etag == "" {
+				if et.cfg.Weak {
+					etag = WeakETag(bw.buf.Bytes())
+				} else {
+					etag = ETag(bw.buf.Bytes())
+				}
+			}
+			modTime, _ := http.ParseTime(h.Get("Last-Modified"))
+			if err := CheckPreconditions(w, r, etag, modTime); err != nil {
+				et.cfg.ErrorHandler(r.Context(), w, err)
+				return
+			}
+		}
+		if h.Get("Content-Length") == "" && h.Get("Content-Encoding") == "" {
+			h.Set("Content-Length", strconv.Itoa(bw.buf.Len()))
+		}
+		w.WriteHeader(bw.status)
+		if !head {
+			w.Write(bw.buf.Bytes())
+		}
+	})
+}
+
+// bufferedWriter buffers a response up to max bytes, switching to streaming it when it grows
+// larger or is flushed.
+type bufferedWriter struct {
+	http.ResponseWriter
+	status    int
+	buf       bytes.Buffer
+	max       int64
+	streaming bool
+}
+
+func (bw *bufferedWriter) WriteHeader(code int) {
+	if bw.streaming || code < 200 {
+		bw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if bw.status == 0 {
+		bw.status = code
+	}
+}
+
+func (bw *bufferedWriter) Write(p []byte) (int, error) {
+	if bw.streaming {
+		return bw.ResponseWriter.Write(p)
+	}
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	if int64(bw.buf.Len()+len(p)) > bw.max {
+		if err := bw.stream(); err != nil {
+			return 0, err
+		}
+		return bw.ResponseWriter.Write(p)
+	}
+	return bw.buf.Write(p)
+}
+
+// stream sends the buffered response and passes further writes through.
+func (bw *bufferedWriter) stream() error {
+	bw.streaming = true
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+	bw.ResponseWriter.WriteHeader(bw.status)
+	_, err := bw.ResponseWriter.Write(bw.buf.Bytes())
+	bw.buf = bytes.Buffer{}
+	return err
+}
+
+func (bw *bufferedWriter) Flush() {
+	if !bw.streaming {
+		bw.stream()
+	}
+	if f, ok := bw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap allows [http.ResponseController] to reach the underlying writer.
+func (bw *bufferedWriter) Unwrap() http.ResponseWriter {
+	return bw.ResponseWriter
+}
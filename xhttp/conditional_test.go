@@ -0,0 +1,201 @@
+package xhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckPreconditions(t *testing.T) {
+	etag := `"v2"`
+	mod := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	before, after := mod.Add(-time.Hour).Format(http.TimeFormat), mod.Add(time.Hour).Format(http.TimeFormat)
+
+	tests := []struct {
+		name, method, header, value string
+		code                        int
+	}{
+		{"no conditions", "GET", "", "", 0},
+		{"if-none-match hit", "GET", "If-None-Match", `"v1", "v2"`, 304},
+		{"if-none-match weak", "GET", "If-None-Match", `W/"v2"`, 304},
+		{"if-none-match star", "HEAD", "If-None-Match", `*`, 304},
+		{"if-none-match miss", "GET", "If-None-Match", `"v1"`, 0},
+		{"if-none-match on put", "PUT", "If-None-Match", `*`, 412},
+		{"if-none-match comma in tag", "GET", "If-None-Match", `"a,b", "v2"`, 304},
+		{"if-match hit", "PUT", "If-Match", `"v2"`, 0},
+		{"if-match miss", "PUT", "If-Match", `"v1"`, 412},
+		{"if-match weak", "PUT", "If-Match", `W/"v2"`, 412},
+		{"if-modified-since fresh", "GET", "If-Modified-Since", after, 304},
+		{"if-modified-since stale", "GET", "If-Modified-Since", before, 0},
+		{"if-modified-since on post", "POST", "If-Modified-Since", after, 0},
+		{"if-unmodified-since ok", "DELETE", "If-Unmodified-Since", after, 0},
+		{"if-unmodified-since failed", "DELETE", "If-Unmodified-Since", before, 412},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			rec := httptest.NewRecorder()
+			err := CheckPreconditions(rec, r, etag, mod.Add(300*time.Millisecond))
+			code := 0
+			if e, ok := err.(*Err); ok {
+				code = e.Code
+			}
+			if code != tt.code {
+				t.Fatalf("want %d, got %v", tt.code, err)
+			}
+			if rec.Header().Get("ETag") != etag || rec.Header().Get("Last-Modified") != mod.Format(http.TimeFormat) {
+				t.Fatalf("validators not set: %v", rec.Header())
+			}
+		})
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("If-None-Match", `"v1"`)
+	r.Header.Set("If-Modified-Since", after)
+	if err := CheckPreconditions(httptest.NewRecorder(), r, etag, mod); err != nil {
+		t.Fatalf("want If-Modified-Since ignored, got %v", err)
+	}
+}
+
+func TestCheckPreconditionsStar(t *testing.T) {
+	mod := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		name, method, header string
+		etag                 string
+		mod                  time.Time
+		code                 int
+	}{
+		{"if-match exists without etag", "PUT", "If-Match", "", mod, 0},
+		{"if-match missing", "PUT", "If-Match", "", time.Time{}, 412},
+		{"if-none-match exists without etag", "PUT", "If-None-Match", "", mod, 412},
+		{"if-none-match exists without etag get", "GET", "If-None-Match", "", mod, 304},
+		{"if-none-match missing", "PUT", "If-None-Match", "", time.Time{}, 0},
+		{"if-none-match exists with etag", "PUT", "If-None-Match", `"v1"`, time.Time{}, 412},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/", nil)
+			r.Header.Set(tt.header, "*")
+			err := CheckPreconditions(httptest.NewRecorder(), r, tt.etag, tt.mod)
+			code := 0
+			if e, ok := err.(*Err); ok {
+				code = e.Code
+			}
+			if code != tt.code {
+				t.Fatalf("want %d, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func TestErrorNotModified(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("If-None-Match", `"v1"`)
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Content-Type", "application/json")
+	Error(context.Background(), rec, CheckPreconditions(rec, r, `"v1"`, time.Time{}))
+	if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("Content-Type") != "" || rec.Header().Get("ETag") != `"v1"` {
+		t.Fatalf("want bare 304 with ETag, got %d %q %v", rec.Code, rec.Body, rec.Header())
+	}
+}
+
+func TestETagger(t *testing.T) {
+	body := `{"items":[1,2,3]}`
+	et, _ := NewETagger(&ETagConfig{MaxBodySize: 64})
+	h := et.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Write([]byte(strings.Repeat("x", 100)))
+		case "/versioned":
+			w.Header().Set("ETag", `"v7"`)
+			w.Write([]byte(body))
+		case "/missing":
+			http.Error(w, "not found", http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body))
+		}
+	}))
+	get := func(path, inm string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		if inm != "" {
+			r.Header.Set("If-None-Match", inm)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	first := get("/", "")
+	if first.Code != http.StatusOK || first.Body.String() != body || first.Header().Get("ETag") != ETag([]byte(body)) ||
+		first.Header().Get("Content-Length") != "17" {
+		t.Fatalf("unexpected response %d %q %v", first.Code, first.Body, first.Header())
+	}
+	if rec := get("/", first.Header().Get("ETag")); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Fatalf("want 304, got %d %q", rec.Code, rec.Body)
+	}
+	if rec := get("/versioned", `"v7"`); rec.Code != http.StatusNotModified {
+		t.Fatalf("want handler ETag used, got %d", rec.Code)
+	}
+	if rec := get("/large", ""); rec.Code != http.StatusOK || rec.Body.Len() != 100 || rec.Header().Get("ETag") != "" {
+		t.Fatalf("want large response streamed without ETag, got %d %d %v", rec.Code, rec.Body.Len(), rec.Header())
+	}
+	if rec := get("/missing", ""); rec.Code != http.StatusNotFound || rec.Header().Get("ETag") != "" {
+		t.Fatalf("want errors passed without ETag, got %d %v", rec.Code, rec.Header())
+	}
+
+	weak, _ := NewETagger(&ETagConfig{Weak: true})
+	rec := httptest.NewRecorder()
+	weak.Middleware(noopHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !strings.HasPrefix(rec.Header().Get("ETag"), `W/"`) {
+		t.Fatalf("want weak ETag, got %q", rec.Header().Get("ETag"))
+	}
+}
+
+func TestETaggerHead(t *testing.T) {
+	et, _ := NewETagger(&ETagConfig{})
+	h := et.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.json", time.Time{}, strings.NewReader(`{"items":[1,2,3]}`))
+	}))
+	serve := func(method, inm string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", nil)
+		if inm != "" {
+			r.Header.Set("If-None-Match", inm)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec
+	}
+
+	get, head := serve("GET", ""), serve("HEAD", "")
+	if etag := get.Header().Get("ETag"); etag == "" || head.Header().Get("ETag") != etag {
+		t.Fatalf("want the same ETag for HEAD and GET, got %q and %q", head.Header().Get("ETag"), etag)
+	}
+	if head.Header().Get("Content-Length") != "17" || head.Body.Len() != 0 {
+		t.Fatalf("want GET's length without a body, got %q %q", head.Header().Get("Content-Length"), head.Body)
+	}
+	if rec := serve("HEAD", get.Header().Get("ETag")); rec.Code != http.StatusNotModified {
+		t.Fatalf("want 304 for a revalidated HEAD, got %d", rec.Code)
+	}
+}
+
+func TestETaggerFlush(t *testing.T) {
+	et, _ := NewETagger(&ETagConfig{})
+	h := et.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("a"))
+		http.NewResponseController(w).Flush()
+		w.Write([]byte("b"))
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if !rec.Flushed || rec.Body.String() != "ab" || rec.Header().Get("ETag") != "" {
+		t.Fatalf("want flushed stream without ETag, got %q %v", rec.Body, rec.Header())
+	}
+}
@@ -0,0 +1,127 @@
+package xhttp
+
+import (
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ServerStats is a snapshot of a [Server]'s connections, see [Server.Stats].
+type ServerStats struct {
+	Open       int // Open connections, excluding hijacked ones. The sum of New, Active, and Idle.
+	New        int // Accepted connections that haven't sent a request yet, e.g. mid TLS handshake.
+	Active     int // Connections serving a request.
+	Idle       int // Keep-alive connections between requests.
+	WebSockets int // Open WebSockets.
+
+	Accepted    uint64 // Total connections accepted, including rejected ones.
+	Rejected    uint64 // Total connections closed for exceeding MaxConns or MaxConnsPerIP.
+	Hijacked    uint64 // Total connections taken over by handlers, e.g. WebSockets. They aren't tracked once hijacked.
+	ForceClosed uint64 // Total connections and WebSockets closed as graceful shutdown ran out of time.
+}
+
+// connTracker follows connections through [http.Server.ConnState], enforcing connection limits.
+type connTracker struct {
+	maxConns, maxPerIP int
+
+	mu     sync.Mutex
+	conns  map[net.Conn]*trackedConn
+	perIP  map[string]int
+	totals struct{ accepted, rejected, hijacked, forceClosed uint64 }
+}
+
+type trackedConn struct {
+	ip    string
+	state http.ConnState
+}
+
+func newConnTracker(maxConns, maxPerIP int) *connTracker {
+	return &connTracker{
+		maxConns: maxConns,
+		maxPerIP: maxPerIP,
+		conns:    make(map[net.Conn]*trackedConn),
+		perIP:    make(map[string]int),
+	}
+}
+
+// connState is the [http.Server.ConnState] hook. Connections over a limit are closed right
+// away, as there's no way to refuse them earlier without wrapping the listener.
+func (t *connTracker) connState(c net.Conn, cs http.ConnState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tc, tracked := t.conns[c]
+	switch cs {
+	case http.StateNew:
+		t.totals.accepted++
+		ip := connIP(c)
+		if (t.maxConns > 0 && len(t.conns) >= t.maxConns) || (t.maxPerIP > 0 && t.perIP[ip] >= t.maxPerIP) {
+			t.totals.rejected++
+			c.Close() // untracked, so its StateClosed is ignored
+			return
+		}
+		t.conns[c] = &trackedConn{ip: ip, state: cs}
+		t.perIP[ip]++
+	case http.StateActive, http.StateIdle:
+		if tracked {
+			tc.state = cs
+		}
+	case http.StateHijacked, http.StateClosed:
+		if !tracked {
+			return
+		}
+		if cs == http.StateHijacked {
+			t.totals.hijacked++
+		}
+		delete(t.conns, c)
+		if t.perIP[tc.ip]--; t.perIP[tc.ip] <= 0 {
+			delete(t.perIP, tc.ip)
+		}
+	}
+}
+
+// open returns the number of open connections.
+func (t *connTracker) open() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// stats fills the connection fields of s.
+func (t *connTracker) stats(s *ServerStats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, tc := range t.conns {
+		switch tc.state {
+		case http.StateNew:
+			s.New++
+		case http.StateActive:
+			s.Active++
+		case http.StateIdle:
+			s.Idle++
+		}
+	}
+	s.Open = len(t.conns)
+	s.Accepted = t.totals.accepted
+	s.Rejected = t.totals.rejected
+	s.Hijacked = t.totals.hijacked
+	s.ForceClosed = t.totals.forceClosed
+}
+
+func (t *connTracker) addForceClosed(n int) {
+	t.mu.Lock()
+	t.totals.forceClosed += uint64(n)
+	t.mu.Unlock()
+}
+
+// connIP returns the remote IP of c, or its whole remote address if it has no port, e.g. on
+// Unix sockets.
+func connIP(c net.Conn) string {
+	addr := c.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+		return host
+	}
+	return addr.String()
+}
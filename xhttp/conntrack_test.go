@@ -0,0 +1,142 @@
+package xhttp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+// waitStats polls the server's stats until ok returns true.
+func waitStats(t *testing.T, srv *Server, ok func(ServerStats) bool) ServerStats {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		st := srv.Stats()
+		if ok(st) {
+			return st
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected stats %+v", st)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServerConnLimits(t *testing.T) {
+	release := make(chan struct{})
+	srv, err := NewServer(&ServerConfig{
+		MaxConnsPerIP: 2,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/block" {
+				<-release
+			}
+		}),
+	})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.server
+	ts.Start()
+	defer ts.Close()
+	defer close(release)
+
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+
+	// one idle keep-alive connection, one serving a request
+	idle := dial()
+	io.WriteString(idle, "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	if resp, err := http.ReadResponse(bufio.NewReader(idle), nil); err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("want 200, got %v", err)
+	}
+	io.WriteString(dial(), "GET /block HTTP/1.1\r\nHost: x\r\n\r\n")
+	waitStats(t, srv, func(st ServerStats) bool { return st.Active == 1 && st.Idle == 1 })
+
+	// a third from the same IP is closed
+	rejected := dial()
+	rejected.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := rejected.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Fatalf("want connection closed, got %v", err)
+	}
+	st := waitStats(t, srv, func(st ServerStats) bool { return st.Rejected == 1 })
+	if st.Open != 2 || st.Accepted != 3 || st.New != 0 {
+		t.Fatalf("unexpected stats %+v", st)
+	}
+
+	// closing one frees a slot
+	idle.Close()
+	waitStats(t, srv, func(st ServerStats) bool { return st.Open == 1 })
+	io.WriteString(dial(), "GET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	waitStats(t, srv, func(st ServerStats) bool { return st.Open == 2 && st.Rejected == 1 })
+}
+
+func TestConnTrackerMaxConns(t *testing.T) {
+	tr := newConnTracker(1, 0)
+	a, b := &net.TCPConn{}, &net.TCPConn{}
+	tr.connState(a, http.StateNew)
+	tr.connState(b, http.StateNew) // closing the zero conn is a no-op
+	tr.connState(b, http.StateClosed)
+	tr.connState(a, http.StateHijacked)
+	var st ServerStats
+	tr.stats(&st)
+	if st.Open != 0 || st.Accepted != 2 || st.Rejected != 1 || st.Hijacked != 1 || len(tr.perIP) != 0 {
+		t.Fatalf("unexpected stats %+v, per IP %v", st, tr.perIP)
+	}
+}
+
+func TestServerShutdownForceClose(t *testing.T) {
+	dir := t.TempDir()
+	logger, _ := xlog.New(dir, "warn")
+	defer logger.Close()
+
+	started := make(chan struct{})
+	srv, err := NewServer(&ServerConfig{
+		Logger: logger,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-r.Context().Done() // stuck until the connection is closed
+		}),
+	})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.server
+	ts.Start()
+	defer ts.Close()
+
+	go http.Get(ts.URL)
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := srv.ShutdownWithContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want deadline exceeded, got %v", err)
+	}
+	st := waitStats(t, srv, func(st ServerStats) bool { return st.Open == 0 })
+	if st.ForceClosed != 1 {
+		t.Fatalf("want 1 force-closed connection, got %+v", st)
+	}
+
+	logger.Flush()
+	b, _ := os.ReadFile(filepath.Join(dir, "latest.log"))
+	if !strings.Contains(string(b), "shutdown deadline exceeded, force-closed 1 connections (1 active, 0 idle, 0 new) and 0 WebSockets") {
+		t.Fatalf("force close not logged: %q", b)
+	}
+}
@@ -0,0 +1,231 @@
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCORSMaxAge is the default duration browsers may cache preflight results.
+const DefaultCORSMaxAge = 10 * time.Minute
+
+// CORSConfig holds configuration options for [CORS].
+type CORSConfig struct {
+	// AllowedOrigins lists the origins allowed to make cross-origin requests. Entries may be exact
+	// ("https://app.example.com"), a wildcard subdomain ("https://*.example.com", which does not
+	// match the bare domain), or "*" for any origin. Matching is case-insensitive.
+	AllowedOrigins []string
+
+	// AllowOriginFunc, if non-nil, is called for origins not matched by AllowedOrigins.
+	AllowOriginFunc func(r *http.Request, origin string) bool
+
+	AllowedMethods   []string      // Methods allowed in preflights. Default is GET, HEAD, POST.
+	AllowedHeaders   []string      // Request headers allowed in preflights, "*" for any. CORS-safelisted headers are always allowed.
+	ExposedHeaders   []string      // Response headers exposed to the browser.
+	AllowCredentials bool          // Whether cookies and auth headers may be sent. Cannot be used with the "*" origin.
+	MaxAge           time.Duration // How long browsers may cache preflight results. Default is 10 minutes. Negative to disable.
+
+	// ErrorHandler sends the 403 [Err] for rejected origins. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// CORS is a middleware that handles cross-origin requests, answering preflights itself.
+//
+// Requests without an Origin header, or whose Origin matches the request host, are passed
+// through as same-origin. Cross-origin requests from origins that aren't allowed, and
+// preflights asking for a method or header that isn't allowed, are rejected with a 403 [Err].
+// Vary is always set correctly so caches never serve one origin's response to another.
+type CORS struct {
+	cfg        *CORSConfig
+	anyOrigin  bool
+	origins    map[string]bool
+	wildcards  []wildcardOrigin
+	anyHeader  bool
+	methods    map[string]bool
+	headers    map[string]bool
+	allowMeths string
+	allowHdrs  string
+	exposeHdrs string
+}
+
+type wildcardOrigin struct {
+	prefix string // scheme, e.g. "https://"
+	suffix string // e.g. ".example.com"
+}
+
+// NewCORS creates a new CORS with the provided configuration.
+func NewCORS(cfg *CORSConfig) (*CORS, error) {
+	copy := *cfg
+
+	if len(copy.AllowedOrigins) == 0 && copy.AllowOriginFunc == nil {
+		return nil, fmt.Errorf("allowed origins or an origin func must be provided")
+	}
+
+	c := &CORS{
+		origins: make(map[string]bool),
+		methods: make(map[string]bool),
+		headers: make(map[string]bool),
+	}
+	for _, o := range copy.AllowedOrigins {
+		o = strings.ToLower(strings.TrimSuffix(o, "/"))
+		switch {
+		case o == "*":
+			c.anyOrigin = true
+		case strings.Contains(o, "://*."):
+			scheme, host, _ := strings.Cut(o, "*")
+			c.wildcards = append(c.wildcards, wildcardOrigin{prefix: scheme, suffix: host})
+		case strings.Contains(o, "*"):
+			return nil, fmt.Errorf("invalid origin %q, wildcards are only supported as a leading subdomain", o)
+		default:
+			c.origins[o] = true
+		}
+	}
+	if c.anyOrigin && copy.AllowCredentials {
+		return nil, fmt.Errorf("the \"*\" origin cannot be used with credentials")
+	}
+
+	// set defaults
+
+	if len(copy.AllowedMethods) == 0 {
+		copy.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	if copy.MaxAge == 0 {
+		copy.MaxAge = DefaultCORSMaxAge
+	}
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	var methods, headers []string
+	for _, m := range copy.AllowedMethods {
+		m = strings.ToUpper(m)
+		methods = append(methods, m)
+		c.methods[m] = true
+	}
+	for _, h := range copy.AllowedHeaders {
+		if h == "*" {
+			c.anyHeader = true
+			continue
+		}
+		headers = append(headers, http.CanonicalHeaderKey(h))
+		c.headers[strings.ToLower(h)] = true
+	}
+	c.allowMeths = strings.Join(methods, ", ")
+	c.allowHdrs = strings.Join(headers, ", ")
+	c.exposeHdrs = strings.Join(copy.ExposedHeaders, ", ")
+	c.cfg = &copy
+	return c, nil
+}
+
+// Middleware applies the CORS policy to requests before they reach next.
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		h.Add("Vary", "Origin")
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || isSameOrigin(r, origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !c.allowedOrigin(r, origin) {
+			c.reject(w, r, fmt.Errorf("cors: origin %q not allowed", origin))
+			return
+		}
+
+		if c.anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if c.cfg.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if c.exposeHdrs != "" {
+				h.Set("Access-Control-Expose-Headers", c.exposeHdrs)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := r.Header.Get("Access-Control-Request-Method")
+		if !c.methods[method] && !isSafelistedMethod(method) {
+			c.reject(w, r, fmt.Errorf("cors: method %q not allowed for origin %q", method, origin))
+			return
+		}
+		reqHeaders := r.Header.Get("Access-Control-Request-Headers")
+		for _, name := range strings.Split(reqHeaders, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" && !c.anyHeader && !c.headers[name] && !isSafelistedHeader(name) {
+				c.reject(w, r, fmt.Errorf("cors: header %q not allowed for origin %q", name, origin))
+				return
+			}
+		}
+
+		h.Set("Access-Control-Allow-Methods", c.allowMeths)
+		if c.anyHeader && reqHeaders != "" {
+			h.Set("Access-Control-Allow-Headers", reqHeaders) // reflect, "*" is ignored with credentials
+		} else if c.allowHdrs != "" {
+			h.Set("Access-Control-Allow-Headers", c.allowHdrs)
+		}
+		if c.cfg.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.cfg.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (c *CORS) allowedOrigin(r *http.Request, origin string) bool {
+	if c.anyOrigin {
+		return true
+	}
+	o := strings.ToLower(origin)
+	if c.origins[o] {
+		return true
+	}
+	for _, wc := range c.wildcards {
+		if strings.HasPrefix(o, wc.prefix) && strings.HasSuffix(o, wc.suffix) &&
+			len(o) > len(wc.prefix)+len(wc.suffix) {
+			return true
+		}
+	}
+	return c.cfg.AllowOriginFunc != nil && c.cfg.AllowOriginFunc(r, origin)
+}
+
+func (c *CORS) reject(w http.ResponseWriter, r *http.Request, err error) {
+	h := w.Header()
+	h.Del("Access-Control-Allow-Origin")
+	h.Del("Access-Control-Allow-Credentials")
+	c.cfg.ErrorHandler(r.Context(), w, &Err{Code: http.StatusForbidden, Msg: "Cross-origin request not allowed", Err: err})
+}
+
+// isSameOrigin reports whether origin's host matches the host the request was sent to.
+// Browsers send Origin on same-origin POSTs too, those must not be treated as cross-origin.
+func isSameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+func isSafelistedMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodPost
+}
+
+func isSafelistedHeader(name string) bool {
+	switch name {
+	case "accept", "accept-language", "content-language", "content-type", "range":
+		return true
+	}
+	return false
+}
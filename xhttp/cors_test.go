@@ -0,0 +1,140 @@
+package xhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewCORSValidation(t *testing.T) {
+	if _, err := NewCORS(&CORSConfig{}); err == nil {
+		t.Fatal("expected error without origins")
+	}
+	if _, err := NewCORS(&CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Fatal("expected error for wildcard origin with credentials")
+	}
+	if _, err := NewCORS(&CORSConfig{AllowedOrigins: []string{"https://app.*.com"}}); err == nil {
+		t.Fatal("expected error for unsupported wildcard")
+	}
+}
+
+func corsRequest(method, origin string, header http.Header) *http.Request {
+	r := httptest.NewRequest(method, "http://api.example.com/", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	return r
+}
+
+func TestCORSOrigins(t *testing.T) {
+	c, err := NewCORS(&CORSConfig{
+		AllowedOrigins:  []string{"https://app.example.com", "https://*.example.org"},
+		AllowOriginFunc: func(_ *http.Request, origin string) bool { return origin == "http://localhost:3000" },
+	})
+	if err != nil {
+		t.Fatalf("new cors: %v", err)
+	}
+	h := c.Middleware(noopHandler())
+
+	tests := []struct {
+		origin string
+		code   int
+		allow  string
+	}{
+		{"", 200, ""},
+		{"http://api.example.com", 200, ""}, // same origin
+		{"https://app.example.com", 200, "https://app.example.com"},
+		{"https://APP.example.com", 200, "https://APP.example.com"},
+		{"https://a.b.example.org", 200, "https://a.b.example.org"},
+		{"https://example.org", 403, ""},
+		{"http://x.example.org", 403, ""},
+		{"http://localhost:3000", 200, "http://localhost:3000"},
+		{"https://evil.com", 403, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, corsRequest("GET", tt.origin, nil))
+		if rec.Code != tt.code {
+			t.Errorf("origin %q: want %d, got %d", tt.origin, tt.code, rec.Code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.allow {
+			t.Errorf("origin %q: want Allow-Origin %q, got %q", tt.origin, tt.allow, got)
+		}
+		if got := rec.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("origin %q: want Vary Origin, got %q", tt.origin, got)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	c, _ := NewCORS(&CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com"},
+		AllowedMethods:   []string{"get", "put"},
+		AllowedHeaders:   []string{"x-api-key"},
+		AllowCredentials: true,
+		MaxAge:           time.Hour,
+	})
+	called := false
+	h := c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, corsRequest("OPTIONS", "https://app.example.com", http.Header{
+		"Access-Control-Request-Method":  {"PUT"},
+		"Access-Control-Request-Headers": {"X-Api-Key, Content-Type"},
+	}))
+	if called {
+		t.Fatal("preflight should not reach the handler")
+	}
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("want 204, got %d", rec.Code)
+	}
+	want := map[string]string{
+		"Access-Control-Allow-Origin":      "https://app.example.com",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Allow-Methods":     "GET, PUT",
+		"Access-Control-Allow-Headers":     "X-Api-Key",
+		"Access-Control-Max-Age":           "3600",
+	}
+	for k, v := range want {
+		if got := rec.Header().Get(k); got != v {
+			t.Errorf("%s: want %q, got %q", k, v, got)
+		}
+	}
+	if got := rec.Header().Values("Vary"); len(got) != 3 {
+		t.Errorf("want 3 Vary values, got %v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, corsRequest("OPTIONS", "https://app.example.com", http.Header{"Access-Control-Request-Method": {"DELETE"}}))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("disallowed method: want 403, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, corsRequest("OPTIONS", "https://app.example.com", http.Header{
+		"Access-Control-Request-Method":  {"GET"},
+		"Access-Control-Request-Headers": {"X-Secret"},
+	}))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("disallowed header: want 403, got %d", rec.Code)
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatal("rejected preflight should not carry Allow-Origin")
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	c, _ := NewCORS(&CORSConfig{AllowedOrigins: []string{"*"}, ExposedHeaders: []string{"X-Request-Id"}})
+	rec := httptest.NewRecorder()
+	c.Middleware(noopHandler()).ServeHTTP(rec, corsRequest("GET", "https://anything.test", nil))
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Fatalf("want *, got %q", got)
+	}
+	if got := rec.Header().Get("Access-Control-Expose-Headers"); got != "X-Request-Id" {
+		t.Fatalf("want exposed header, got %q", got)
+	}
+}
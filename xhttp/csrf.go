@@ -0,0 +1,245 @@
+package xhttp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Default values for [CSRFConfig].
+const (
+	DefaultCSRFCookieName = "__Host-csrf"
+	DefaultCSRFHeaderName = "X-CSRF-Token"
+	DefaultCSRFFieldName  = "csrf_token"
+	DefaultCSRFMaxAge     = 12 * time.Hour
+)
+
+// CSRFConfig holds configuration options for [CSRF].
+type CSRFConfig struct {
+	Key []byte // Secret HMAC key used to sign tokens, at least 32 bytes. Required.
+
+	// CookieName is the name of the CSRF cookie. Default is "__Host-csrf", or "csrf" when
+	// InsecureCookie is set, since the __Host- prefix requires the Secure flag.
+	CookieName     string
+	InsecureCookie bool          // Omit the Secure cookie flag, for UIs served over plain HTTP on a non-localhost address.
+	MaxAge         time.Duration // Lifetime of the CSRF cookie. Default is 12 hours. Negative for a session cookie.
+
+	HeaderName string // Request header checked for the token. Default is "X-CSRF-Token".
+	FieldName  string // Form field checked for the token if the header is absent. Default is "csrf_token".
+
+	// TrustedOrigins lists additional origins (e.g. "https://admin.example.com") allowed to
+	// make unsafe requests. The request's own host is always trusted.
+	TrustedOrigins []string
+
+	// SessionID returns the identifier of the session or user tokens are bound to, so a token
+	// is only valid for the session it was issued in. Default is the [Principal] ID, if any,
+	// which requires [Auth] to run before the CSRF middleware. Requests it returns "" for get
+	// unbound tokens.
+	SessionID func(r *http.Request) string
+
+	// Exempt, if non-nil, skips protection for requests it returns true for, e.g. webhooks
+	// authenticated by other means.
+	Exempt func(r *http.Request) bool
+
+	// ErrorHandler sends the 403 [Err] for rejected requests. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// CSRF is a middleware protecting form-based UIs against cross-site request forgery using
+// signed double-submit cookies.
+//
+// A random value is stored in a cookie, and the token handed to templates via [CSRFToken]
+// is the HMAC under Key of that value and the SessionID, following the OWASP signed
+// double-submit pattern. Tokens for anonymous requests aren't bound to a session, so an
+// attacker able to set cookies for the domain, e.g. from a sibling subdomain or over plain
+// HTTP with InsecureCookie, can plant a cookie and token pair of their own for them. The
+// default __Host- cookie prefix prevents that in browsers supporting it. Unsafe requests
+// (anything but GET, HEAD, OPTIONS, and TRACE) must pass two checks:
+//   - Sec-Fetch-Site, if sent, is not "cross-site", and Origin, if sent, is the request host or trusted
+//   - the token is sent in the HeaderName header or FieldName form field and matches the cookie
+//
+// Failures are sent as a 403 [Err].
+//
+// In templates, pass [CSRFToken] to a hidden form field:
+//
+//	tmpl.Execute(w, map[string]any{"CSRFToken": xhttp.CSRFToken(r.Context())})
+//	// <input type="hidden" name="csrf_token" value="{{ .CSRFToken }}">
+type CSRF struct {
+	cfg     *CSRFConfig
+	trusted map[string]bool
+}
+
+type csrfTokenKey struct{}
+
+var errCSRF = errors.New("csrf")
+
+// NewCSRF creates a new CSRF with the provided configuration.
+func NewCSRF(cfg *CSRFConfig) (*CSRF, error) {
+	copy := *cfg
+
+	if len(copy.Key) < 32 {
+		return nil, fmt.Errorf("CSRF key must be at least 32 bytes")
+	}
+
+	// set defaults
+
+	if copy.CookieName == "" {
+		if copy.InsecureCookie {
+			copy.CookieName = "csrf"
+		} else {
+			copy.CookieName = DefaultCSRFCookieName
+		}
+	}
+	if copy.InsecureCookie && strings.HasPrefix(copy.CookieName, "__Host-") {
+		return nil, fmt.Errorf("cookie name %q requires a secure cookie", copy.CookieName)
+	}
+	if copy.MaxAge == 0 {
+		copy.MaxAge = DefaultCSRFMaxAge
+	}
+	if copy.HeaderName == "" {
+		copy.HeaderName = DefaultCSRFHeaderName
+	}
+	if copy.FieldName == "" {
+		copy.FieldName = DefaultCSRFFieldName
+	}
+	if copy.SessionID == nil {
+		copy.SessionID = func(r *http.Request) string {
+			if p := PrincipalFromContext(r.Context()); p != nil {
+				return p.ID
+			}
+			return ""
+		}
+	}
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	trusted := make(map[string]bool)
+	for _, o := range copy.TrustedOrigins {
+		trusted[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+	return &CSRF{cfg: &copy, trusted: trusted}, nil
+}
+
+// Middleware checks unsafe requests to next, and makes the token available through [CSRFToken].
+func (c *CSRF) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := ""
+		if cookie, err := r.Cookie(c.cfg.CookieName); err == nil && validCSRFSecret(cookie.Value) {
+			secret = cookie.Value
+		}
+
+		if !isSafeMethod(r.Method) && (c.cfg.Exempt == nil || !c.cfg.Exempt(r)) {
+			if err := c.check(r, secret); err != nil {
+				c.cfg.ErrorHandler(r.Context(), w, &Err{
+					Code: http.StatusForbidden,
+					Msg:  "Forbidden, invalid or missing CSRF token. Try reloading the page",
+					Err:  err,
+				})
+				return
+			}
+		}
+
+		if secret == "" {
+			var err error
+			if secret, err = newCSRFSecret(); err != nil {
+				Error(r.Context(), w, fmt.Errorf("failed to generate CSRF secret: %w", err))
+				return
+			}
+			c.setCookie(w, secret)
+		}
+		w.Header().Add("Vary", "Cookie")
+
+		ctx := context.WithValue(r.Context(), csrfTokenKey{}, c.sign(secret, c.cfg.SessionID(r)))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (c *CSRF) check(r *http.Request, secret string) error {
+	if site := r.Header.Get("Sec-Fetch-Site"); site == "cross-site" {
+		if origin := r.Header.Get("Origin"); origin == "" || !c.trusted[strings.ToLower(origin)] {
+			return fmt.Errorf("%w: cross-site request from %q", errCSRF, origin)
+		}
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && origin != "null" {
+		u, err := url.Parse(origin)
+		if err != nil || (!strings.EqualFold(u.Host, r.Host) && !c.trusted[strings.ToLower(origin)]) {
+			return fmt.Errorf("%w: untrusted origin %q", errCSRF, origin)
+		}
+	}
+
+	if secret == "" {
+		return fmt.Errorf("%w: missing cookie %q", errCSRF, c.cfg.CookieName)
+	}
+	token := r.Header.Get(c.cfg.HeaderName)
+	if token == "" {
+		token = r.PostFormValue(c.cfg.FieldName)
+	}
+	if token == "" {
+		return fmt.Errorf("%w: missing token", errCSRF)
+	}
+	if !hmac.Equal([]byte(token), []byte(c.sign(secret, c.cfg.SessionID(r)))) {
+		return fmt.Errorf("%w: token mismatch", errCSRF)
+	}
+	return nil
+}
+
+// sign returns the token for the cookie secret in the session, which may be empty.
+func (c *CSRF) sign(secret, session string) string {
+	mac := hmac.New(sha256.New, c.cfg.Key)
+	mac.Write([]byte(secret))
+	mac.Write([]byte{0})
+	mac.Write([]byte(session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *CSRF) setCookie(w http.ResponseWriter, secret string) {
+	cookie := &http.Cookie{
+		Name:     c.cfg.CookieName,
+		Value:    secret,
+		Path:     "/",
+		Secure:   !c.cfg.InsecureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if c.cfg.MaxAge > 0 {
+		cookie.MaxAge = int(c.cfg.MaxAge.Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+// CSRFToken returns the CSRF token to embed in forms or send in the token header, or "" if
+// [CSRF.Middleware] is not in use.
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfTokenKey{}).(string)
+	return token
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func newCSRFSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func validCSRFSecret(s string) bool {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	return err == nil && len(b) == 32
+}
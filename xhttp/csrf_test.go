@@ -0,0 +1,165 @@
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var testCSRFKey = bytes.Repeat([]byte("k"), 32)
+
+func TestNewCSRFValidation(t *testing.T) {
+	if _, err := NewCSRF(&CSRFConfig{Key: []byte("short")}); err == nil {
+		t.Fatal("expected error for short key")
+	}
+	if _, err := NewCSRF(&CSRFConfig{Key: testCSRFKey, InsecureCookie: true, CookieName: "__Host-x"}); err == nil {
+		t.Fatal("expected error for __Host- cookie without Secure")
+	}
+}
+
+// csrfSetup performs a GET to obtain the cookie and token.
+func csrfSetup(t *testing.T, c *CSRF) (*http.Cookie, string) {
+	t.Helper()
+	var token string
+	rec := httptest.NewRecorder()
+	c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r.Context())
+	})).ServeHTTP(rec, httptest.NewRequest("GET", "http://app.test/", nil))
+
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != DefaultCSRFCookieName || !cookies[0].Secure || !cookies[0].HttpOnly {
+		t.Fatalf("unexpected cookies: %v", cookies)
+	}
+	if token == "" {
+		t.Fatal("expected token in context")
+	}
+	return cookies[0], token
+}
+
+func TestCSRF(t *testing.T) {
+	c, err := NewCSRF(&CSRFConfig{Key: testCSRFKey, TrustedOrigins: []string{"https://admin.test"}})
+	if err != nil {
+		t.Fatalf("new csrf: %v", err)
+	}
+	cookie, token := csrfSetup(t, c)
+	h := c.Middleware(noopHandler())
+
+	tests := []struct {
+		name  string
+		build func(r *http.Request)
+		form  url.Values
+		code  int
+	}{
+		{"header token", func(r *http.Request) { r.AddCookie(cookie); r.Header.Set("X-CSRF-Token", token) }, nil, 200},
+		{"form token", func(r *http.Request) { r.AddCookie(cookie) }, url.Values{"csrf_token": {token}}, 200},
+		{"same origin", func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set("X-CSRF-Token", token)
+			r.Header.Set("Origin", "http://app.test")
+			r.Header.Set("Sec-Fetch-Site", "same-origin")
+		}, nil, 200},
+		{"trusted origin", func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set("X-CSRF-Token", token)
+			r.Header.Set("Origin", "https://admin.test")
+			r.Header.Set("Sec-Fetch-Site", "cross-site")
+		}, nil, 200},
+		{"missing token", func(r *http.Request) { r.AddCookie(cookie) }, nil, 403},
+		{"missing cookie", func(r *http.Request) { r.Header.Set("X-CSRF-Token", token) }, nil, 403},
+		{"wrong token", func(r *http.Request) { r.AddCookie(cookie); r.Header.Set("X-CSRF-Token", "nope") }, nil, 403},
+		{"cookie as token", func(r *http.Request) { r.AddCookie(cookie); r.Header.Set("X-CSRF-Token", cookie.Value) }, nil, 403},
+		{"cross site", func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set("X-CSRF-Token", token)
+			r.Header.Set("Sec-Fetch-Site", "cross-site")
+		}, nil, 403},
+		{"foreign origin", func(r *http.Request) {
+			r.AddCookie(cookie)
+			r.Header.Set("X-CSRF-Token", token)
+			r.Header.Set("Origin", "https://evil.test")
+		}, nil, 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var r *http.Request
+			if tt.form != nil {
+				r = httptest.NewRequest("POST", "http://app.test/", strings.NewReader(tt.form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				r = httptest.NewRequest("POST", "http://app.test/", nil)
+			}
+			tt.build(r)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tt.code {
+				t.Fatalf("want %d, got %d", tt.code, rec.Code)
+			}
+		})
+	}
+}
+
+func TestCSRFKeepsExistingCookie(t *testing.T) {
+	c, _ := NewCSRF(&CSRFConfig{Key: testCSRFKey})
+	cookie, token := csrfSetup(t, c)
+
+	var got string
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://app.test/", nil)
+	r.AddCookie(cookie)
+	c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = CSRFToken(r.Context())
+	})).ServeHTTP(rec, r)
+
+	if len(rec.Result().Cookies()) != 0 {
+		t.Fatal("existing cookie should not be replaced")
+	}
+	if got != token {
+		t.Fatal("token should be stable for the same cookie")
+	}
+}
+
+func TestCSRFExempt(t *testing.T) {
+	c, _ := NewCSRF(&CSRFConfig{Key: testCSRFKey, Exempt: func(r *http.Request) bool { return r.URL.Path == "/hook" }})
+	rec := httptest.NewRecorder()
+	c.Middleware(noopHandler()).ServeHTTP(rec, httptest.NewRequest("POST", "http://app.test/hook", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("exempt request: want 200, got %d", rec.Code)
+	}
+}
+
+func TestCSRFSessionBinding(t *testing.T) {
+	c, _ := NewCSRF(&CSRFConfig{Key: testCSRFKey})
+	cookie, _ := csrfSetup(t, c)
+	tokenFor := func(user string) string {
+		var token string
+		r := httptest.NewRequest("GET", "http://app.test/", nil)
+		r.AddCookie(cookie)
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, &Principal{ID: user}))
+		c.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token = CSRFToken(r.Context())
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		return token
+	}
+	post := func(user, token string) int {
+		r := httptest.NewRequest("POST", "http://app.test/", nil)
+		r.AddCookie(cookie)
+		r.Header.Set("X-CSRF-Token", token)
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, &Principal{ID: user}))
+		rec := httptest.NewRecorder()
+		c.Middleware(noopHandler()).ServeHTTP(rec, r)
+		return rec.Code
+	}
+
+	// an attacker's planted cookie and token pair is useless for the victim's session
+	attacker := tokenFor("mallory")
+	if code := post("alice", attacker); code != http.StatusForbidden {
+		t.Fatalf("want token bound to its session rejected, got %d", code)
+	}
+	if code := post("alice", tokenFor("alice")); code != http.StatusOK {
+		t.Fatalf("want token of the session accepted, got %d", code)
+	}
+}
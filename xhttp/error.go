@@ -2,6 +2,7 @@ package xhttp
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
@@ -28,13 +29,16 @@ type unwrapMany interface {
 }
 
 func logError(ctx context.Context, err error) {
-	// get logger from context
-	logger := xlog.FromContext(ctx)
-	if logger == nil { // fallback to console
-		fmt.Println(err.Error())
+	msg := err.Error()
+	if ip := clientIPFromContext(ctx); ip != "" {
+		msg += " client: " + ip
+	}
+	// use logger from context, which also appends trace IDs if present
+	if xlog.FromContext(ctx) == nil { // fallback to console
+		fmt.Println(msg)
 		return
 	}
-	logger.Error(err.Error())
+	xlog.Error(ctx, msg)
 }
 
 func walkErrs(err error, visit func(*Err) bool) bool {
@@ -64,7 +68,11 @@ func walkErrs(err error, visit func(*Err) bool) bool {
 
 // Error logs the error and sends an http response. If the error is an [Err], it sends the given
 // message and status code. Otherwise, it sends a generic "Internal server error" and 500 status code.
+// A 304 [Err], e.g. from [CheckPreconditions], is sent without a body and not logged.
 func Error(ctx context.Context, w http.ResponseWriter, err error) {
+	if writeNotModified(w, err) {
+		return
+	}
 	logError(ctx, err)
 	var e *Err
 	if errors.As(err, &e) {
@@ -75,9 +83,12 @@ func Error(ctx context.Context, w http.ResponseWriter, err error) {
 }
 
 // ErrorJoined logs the error and sends an http response using the first matching [Err] status code
-// and a "; "-joined list of all matching [Err] messages found in the error tree. If there is no
-// [Err], it sends a generic "Internal server error" and 500 status code.
+// and a "; "-joined list of all matching [Err] messages found in the error tree, followed by any
+// [FieldErrors] entries. If there is no [Err], it sends a generic "Internal server error" and 500 status code.
 func ErrorJoined(ctx context.Context, w http.ResponseWriter, err error) {
+	if writeNotModified(w, err) {
+		return
+	}
 	logError(ctx, err)
 
 	var first *Err
@@ -91,6 +102,12 @@ func ErrorJoined(ctx context.Context, w http.ResponseWriter, err error) {
 		}
 		return true
 	})
+	var fields FieldErrors
+	if errors.As(err, &fields) {
+		for _, f := range fields {
+			msgs = append(msgs, f.Error())
+		}
+	}
 
 	if first == nil || len(msgs) == 0 {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -99,3 +116,35 @@ func ErrorJoined(ctx context.Context, w http.ResponseWriter, err error) {
 
 	http.Error(w, strings.Join(msgs, "; "), first.Code)
 }
+
+// ErrorBody is the JSON response body sent by [ErrorJSON].
+type ErrorBody struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Fields  FieldErrors `json:"fields,omitempty"`
+}
+
+// ErrorJSON is like [Error] but sends an [ErrorBody] as application/json. Fields is
+// populated from the first [FieldErrors] in the error tree, e.g. one returned by [Validate].
+func ErrorJSON(ctx context.Context, w http.ResponseWriter, err error) {
+	if writeNotModified(w, err) {
+		return
+	}
+	logError(ctx, err)
+	body := ErrorBody{Code: http.StatusInternalServerError, Message: "Internal server error"}
+	var e *Err
+	if errors.As(err, &e) {
+		body.Code, body.Message = e.Code, e.Msg
+		var fields FieldErrors
+		if errors.As(err, &fields) {
+			body.Fields = fields
+		}
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(body.Code)
+	_ = json.NewEncoder(w).Encode(body)
+}
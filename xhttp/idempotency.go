@@ -0,0 +1,545 @@
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default values for [IdempotencyConfig].
+const (
+	DefaultIdempotencyHeader      = "Idempotency-Key"
+	DefaultIdempotencyTTL         = 24 * time.Hour
+	DefaultIdempotencyLockTimeout = time.Minute
+	DefaultIdempotencyMaxBody     = 1 << 20 // 1 MiB
+)
+
+// maxIdempotencyKey is the max length of an idempotency key.
+const maxIdempotencyKey = 255
+
+// ErrIdempotencyInFlight is returned by [IdempotencyStore.Begin] for a key reserved by another request.
+var ErrIdempotencyInFlight = errors.New("idempotency key in flight")
+
+// IdempotencyConfig holds configuration options for [Idempotency].
+type IdempotencyConfig struct {
+	Store IdempotencyStore // Where responses are kept. Default is a new [MemoryIdempotencyStore].
+
+	TTL    time.Duration // How long responses are replayed for. Default is 24 hours.
+	Header string        // Request header carrying the key. Default is "Idempotency-Key".
+
+	// LockTimeout is how long a key stays reserved for a request that never completes, e.g. as
+	// the process crashed with a shared store. Default is 1 minute.
+	LockTimeout time.Duration
+
+	// MaxBodySize caps request bodies of keyed requests, which are read to fingerprint them,
+	// and the responses stored. Larger requests get a 413 [Err], larger responses are sent but
+	// not stored. Default is 1 MiB.
+	MaxBodySize int64
+
+	// Scope, if non-nil, returns the scope of keys for a request, e.g. the authenticated user
+	// ID, so clients can't replay each other's responses by reusing a key. Default is none,
+	// keys are global.
+	Scope func(r *http.Request) string
+
+	Required bool // Reject unsafe requests without a key with a 400 [Err].
+
+	// ErrorHandler sends the 400, 409, 413, and 422 [Err]s. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// IdempotencyStore keeps responses for [Idempotency]. Implementations must be safe for
+// concurrent use, and Begin must be atomic when the store is shared by several processes.
+//
+// Reservations are owned by the random token passed to Begin, so a request outliving its
+// reservation can't release the one of a newer request that took the key over.
+type IdempotencyStore interface {
+	// Begin reserves key for the request owning token until lockExpiry. It returns nil and no
+	// error if the key was reserved by this call, the stored response if the key completed, or
+	// [ErrIdempotencyInFlight] if another request holds an unexpired reservation.
+	Begin(ctx context.Context, key, token string, lockExpiry time.Time) (*IdempotentResponse, error)
+	// Complete stores the response for key until expiry, releasing the reservation if token
+	// still owns it.
+	Complete(ctx context.Context, key, token string, resp *IdempotentResponse, expiry time.Time) error
+	// Release drops the reservation of key if token still owns it, without storing a response,
+	// so the request can be retried.
+	Release(ctx context.Context, key, token string) error
+}
+
+// IdempotentResponse is a response stored by [Idempotency].
+type IdempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"` // Hash of the request's method, URI, and body.
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// Idempotency is a middleware making retries of unsafe requests (POST, PUT, PATCH, DELETE)
+// safe, following the IETF Idempotency-Key header draft.
+//
+// The first request with a key is served and its response stored. Duplicates get the stored
+// response replayed with an "Idempotent-Replayed: true" header instead of running the handler
+// again. Duplicates arriving while the first is in flight get a 409 [Err], and reuse of a key
+// for a different request a 422 [Err]. Server errors (5xx) and 429s aren't stored, as the
+// request may be retried once the problem clears.
+type Idempotency struct {
+	cfg *IdempotencyConfig
+}
+
+// NewIdempotency creates a new Idempotency middleware with the provided configuration.
+func NewIdempotency(cfg *IdempotencyConfig) (*Idempotency, error) {
+	copy := *cfg
+
+	if copy.TTL < 0 || copy.LockTimeout < 0 || copy.MaxBodySize < 0 {
+		return nil, fmt.Errorf("idempotency TTL, lock timeout, and max body size must not be negative")
+	}
+
+	// set defaults
+
+	if copy.Store == nil {
+		copy.Store = NewMemoryIdempotencyStore()
+	}
+	if copy.TTL == 0 {
+		copy.TTL = DefaultIdempotencyTTL
+	}
+	if copy.Header == "" {
+		copy.Header = DefaultIdempotencyHeader
+	}
+	if copy.LockTimeout == 0 {
+		copy.LockTimeout = DefaultIdempotencyLockTimeout
+	}
+	if copy.MaxBodySize == 0 {
+		copy.MaxBodySize = DefaultIdempotencyMaxBody
+	}
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	return &Idempotency{cfg: &copy}, nil
+}
+
+// Middleware returns the idempotency middleware wrapping next.
+func (id *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSafeMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		key := r.Header.Get(id.cfg.Header)
+		if key == "" {
+			if id.cfg.Required {
+				id.cfg.ErrorHandler(ctx, w, &Err{Code: http.StatusBadRequest, Msg: id.cfg.Header + " header required",
+					Err: fmt.Errorf("missing %s header", id.cfg.Header)})
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			id.cfg.ErrorHandler(ctx, w, &Err{Code: http.StatusBadRequest, Msg: "Invalid " + id.cfg.Header + " header",
+				Err: fmt.Errorf("idempotency key longer than %d bytes", maxIdempotencyKey)})
+			return
+		}
+		if id.cfg.Scope != nil {
+			key = id.cfg.Scope(r) + "\x00" + key
+		}
+
+		// fingerprint the request, so a reused key for a different request is caught
+		body, err := io.ReadAll(io.LimitReader(r.Body, id.cfg.MaxBodySize+1))
+		if err != nil {
+			id.cfg.ErrorHandler(ctx, w, &Err{Code: http.StatusBadRequest, Msg: "Failed to read request body", Err: err})
+			return
+		}
+		if int64(len(body)) > id.cfg.MaxBodySize {
+			id.cfg.ErrorHandler(ctx, w, &Err{Code: http.StatusRequestEntityTooLarge, Msg: "Request body too large",
+				Err: fmt.Errorf("idempotent request body over %d bytes", id.cfg.MaxBodySize)})
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h := sha256.New()
+		fmt.Fprintf(h, "%s %s\n", r.Method, r.URL.RequestURI())
+		h.Write(body)
+		fingerprint := hex.EncodeToString(h.Sum(nil))
+
+		token := rand.Text()
+		stored, err := id.cfg.Store.Begin(ctx, key, token, time.Now().Add(id.cfg.LockTimeout))
+		switch {
+		case errors.Is(err, ErrIdempotencyInFlight):
+			w.Header().Set("Retry-After", "1")
+			id.cfg.ErrorHandler(ctx, w, &Err{Code: http.StatusConflict, Msg: "A request with this " + id.cfg.Header + " is in progress", Err: err})
+			return
+		case err != nil:
+			id.cfg.ErrorHandler(ctx, w, fmt.Errorf("idempotency store: %w", err))
+			return
+		case stored != nil:
+			if stored.Fingerprint != fingerprint {
+				id.cfg.ErrorHandler(ctx, w, &Err{Code: http.StatusUnprocessableEntity, Msg: id.cfg.Header + " was used for a different request",
+					Err: errors.New("idempotency key reused with a different request")})
+				return
+			}
+			for k, v := range stored.Header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			w.Write(stored.Body)
+			return
+		}
+
+		// reserved, serve and store the response. The reservation is released if the handler
+		// panics or the response isn't stored, so the request can be retried.
+		iw := &captureWriter{ResponseWriter: w, max: id.cfg.MaxBodySize}
+		completed := false
+		defer func() {
+			if !completed {
+				if err := id.cfg.Store.Release(context.WithoutCancel(ctx), key, token); err != nil {
+					logError(ctx, fmt.Errorf("idempotency store: %w", err))
+				}
+			}
+		}()
+		next.ServeHTTP(iw, r)
+		if iw.status == 0 && !iw.hijacked {
+			iw.WriteHeader(http.StatusOK) // nothing written, as net/http would send
+		}
+		if iw.status == 0 || iw.overflow || iw.status >= 500 || iw.status == http.StatusTooManyRequests {
+			return
+		}
+		resp := &IdempotentResponse{Fingerprint: fingerprint, Status: iw.status, Header: iw.header, Body: iw.body.Bytes()}
+		if err := id.cfg.Store.Complete(context.WithoutCancel(ctx), key, token, resp, time.Now().Add(id.cfg.TTL)); err != nil {
+			logError(ctx, fmt.Errorf("idempotency store: %w", err))
+			return
+		}
+		completed = true
+	})
+}
+
+// captureWriter records the response while sending it, up to max body bytes.
+type captureWriter struct {
+	http.ResponseWriter
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	max      int64
+	overflow bool
+	hijacked bool
+}
+
+func (iw *captureWriter) WriteHeader(code int) {
+	if iw.status == 0 && code >= 200 {
+		iw.status = code
+		iw.header = iw.Header().Clone()
+	}
+	iw.ResponseWriter.WriteHeader(code)
+}
+
+func (iw *captureWriter) Write(p []byte) (int, error) {
+	if iw.status == 0 {
+		iw.WriteHeader(http.StatusOK)
+	}
+	if !iw.overflow {
+		if int64(iw.body.Len()+len(p)) > iw.max {
+			iw.overflow = true
+			iw.body = bytes.Buffer{}
+		} else {
+			iw.body.Write(p)
+		}
+	}
+	return iw.ResponseWriter.Write(p)
+}
+
+// Unwrap allows [http.ResponseController] to reach the underlying writer. Hijacking through it
+// leaves the status unset, so the response isn't stored.
+func (iw *captureWriter) Unwrap() http.ResponseWriter {
+	iw.hijacked = iw.status == 0
+	return iw.ResponseWriter
+}
+
+func (iw *captureWriter) Flush() {
+	if iw.status == 0 {
+		iw.WriteHeader(http.StatusOK)
+	}
+	if f, ok := iw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// MemoryIdempotencyStore is an in-memory [IdempotencyStore], for single-process apps and tests.
+// Responses are lost on restart.
+type MemoryIdempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryIdempotencyEntry
+	lastSweep time.Time
+}
+
+type memoryIdempotencyEntry struct {
+	resp   *IdempotentResponse // nil while in flight
+	token  string              // owner of the reservation
+	expiry time.Time
+}
+
+// NewMemoryIdempotencyStore creates a new, empty MemoryIdempotencyStore.
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{entries: make(map[string]memoryIdempotencyEntry)}
+}
+
+// Begin implements [IdempotencyStore]. Expired entries are swept lazily, at most once a minute.
+func (ms *MemoryIdempotencyStore) Begin(_ context.Context, key, token string, lockExpiry time.Time) (*IdempotentResponse, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	now := time.Now()
+	if now.Sub(ms.lastSweep) > time.Minute {
+		ms.lastSweep = now
+		for k, e := range ms.entries {
+			if now.After(e.expiry) {
+				delete(ms.entries, k)
+			}
+		}
+	}
+	if e, ok := ms.entries[key]; ok && !now.After(e.expiry) {
+		if e.resp == nil {
+			return nil, ErrIdempotencyInFlight
+		}
+		return e.resp, nil
+	}
+	ms.entries[key] = memoryIdempotencyEntry{token: token, expiry: lockExpiry}
+	return nil, nil
+}
+
+// Complete implements [IdempotencyStore]. A request whose reservation was taken over by a
+// newer one leaves it alone, storing its response only once no live reservation remains.
+func (ms *MemoryIdempotencyStore) Complete(_ context.Context, key, token string, resp *IdempotentResponse, expiry time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if e, ok := ms.entries[key]; ok && e.resp == nil && e.token != token && time.Now().Before(e.expiry) {
+		return nil
+	}
+	ms.entries[key] = memoryIdempotencyEntry{resp: resp, expiry: expiry}
+	return nil
+}
+
+// Release implements [IdempotencyStore].
+func (ms *MemoryIdempotencyStore) Release(_ context.Context, key, token string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if e, ok := ms.entries[key]; ok && e.resp == nil && e.token == token {
+		delete(ms.entries, key)
+	}
+	return nil
+}
+
+// FileIdempotencyStore is an [IdempotencyStore] keeping responses as files in a directory, so
+// they survive restarts and can be shared by processes on the same host. Reservations are
+// lock files created exclusively, making Begin atomic across processes. Taking over expired
+// lock files and releasing them is serialized by an OS file lock on the directory.
+type FileIdempotencyStore struct {
+	dir       string
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewFileIdempotencyStore creates a FileIdempotencyStore in dir, creating it if needed.
+func NewFileIdempotencyStore(dir string) (*FileIdempotencyStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create idempotency store directory: %w", err)
+	}
+	return &FileIdempotencyStore{dir: dir}, nil
+}
+
+// fileIdempotencyEntry is the file format of a stored response.
+type fileIdempotencyEntry struct {
+	Expiry   time.Time           `json:"expiry"`
+	Response *IdempotentResponse `json:"response"`
+}
+
+// path returns the file path for key with ext, hashing the key as it may hold any characters.
+func (fs *FileIdempotencyStore) path(key, ext string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(fs.dir, hex.EncodeToString(sum[:])+ext)
+}
+
+// Begin implements [IdempotencyStore].
+func (fs *FileIdempotencyStore) Begin(_ context.Context, key, token string, lockExpiry time.Time) (*IdempotentResponse, error) {
+	lock := fs.path(key, ".lock")
+	for range 2 {
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err == nil {
+			_, err = f.WriteString(lockExpiry.UTC().Format(time.RFC3339Nano) + "\n" + token)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(lock)
+				return nil, err
+			}
+			// completed between a previous check and taking the lock
+			resp, err := fs.load(key)
+			if resp != nil || err != nil {
+				fs.Release(context.Background(), key, token)
+			}
+			return resp, err
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if resp, err := fs.load(key); resp != nil || err != nil {
+			return resp, err
+		}
+		// take over a lock left expired, e.g. by a crashed process
+		if err := fs.removeLock(lock, func(expiry time.Time, _ string) bool { return !time.Now().Before(expiry) }); err != nil {
+			return nil, err
+		}
+	}
+	return nil, ErrIdempotencyInFlight
+}
+
+// removeLock removes the lock file if it exists and remove reports true for its contents. It
+// holds the directory lock, so a lock file can't be replaced between reading and removing it.
+// Unreadable lock files count as expired.
+func (fs *FileIdempotencyStore) removeLock(lock string, remove func(expiry time.Time, token string) bool) error {
+	unlock, err := lockDir(fs.dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	expiry, token, exists, err := readLock(lock)
+	if err != nil || !exists || !remove(expiry, token) {
+		return err
+	}
+	if err := os.Remove(lock); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// readLock returns the expiry and owner token of a lock file, and whether it exists.
+func readLock(lock string) (expiry time.Time, token string, exists bool, err error) {
+	b, err := os.ReadFile(lock)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, "", false, nil
+	}
+	if err != nil {
+		return time.Time{}, "", false, err
+	}
+	rawExpiry, token, _ := strings.Cut(string(b), "\n")
+	expiry, _ = time.Parse(time.RFC3339Nano, rawExpiry)
+	return expiry, token, true, nil
+}
+
+// load returns the stored response for key, or nil if there is none or it expired.
+func (fs *FileIdempotencyStore) load(key string) (*IdempotentResponse, error) {
+	b, err := os.ReadFile(fs.path(key, ".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e fileIdempotencyEntry
+	if err := json.Unmarshal(b, &e); err != nil {
+		return nil, fmt.Errorf("corrupt idempotency entry: %w", err)
+	}
+	if time.Now().After(e.Expiry) || e.Response == nil {
+		return nil, nil
+	}
+	return e.Response, nil
+}
+
+// Complete implements [IdempotencyStore]. The response is written to a temporary file and
+// renamed into place, so readers never see a partial one. A request whose reservation was
+// taken over by a newer one leaves it alone, storing its response only once no live
+// reservation remains. Expired entries are swept lazily, at most once a minute.
+func (fs *FileIdempotencyStore) Complete(_ context.Context, key, token string, resp *IdempotentResponse, expiry time.Time) error {
+	fs.sweep()
+	b, err := json.Marshal(fileIdempotencyEntry{Expiry: expiry, Response: resp})
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(fs.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = fs.commit(key, token, tmp.Name())
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// commit renames the response file tmp into place and removes the lock, if token owns it or
+// no other live reservation exists.
+func (fs *FileIdempotencyStore) commit(key, token, tmp string) error {
+	unlock, err := lockDir(fs.dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	lock := fs.path(key, ".lock")
+	expiry, owner, exists, err := readLock(lock)
+	if err != nil {
+		return err
+	}
+	if exists && owner != token && time.Now().Before(expiry) {
+		return os.Remove(tmp) // taken over by a newer request
+	}
+	if err := os.Rename(tmp, fs.path(key, ".json")); err != nil {
+		return err
+	}
+	if exists && owner == token {
+		if err := os.Remove(lock); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release implements [IdempotencyStore].
+func (fs *FileIdempotencyStore) Release(_ context.Context, key, token string) error {
+	return fs.removeLock(fs.path(key, ".lock"), func(_ time.Time, owner string) bool { return owner == token })
+}
+
+// sweep removes expired responses.
+func (fs *FileIdempotencyStore) sweep() {
+	fs.mu.Lock()
+	now := time.Now()
+	if now.Sub(fs.lastSweep) <= time.Minute {
+		fs.mu.Unlock()
+		return
+	}
+	fs.lastSweep = now
+	fs.mu.Unlock()
+
+	entries, _ := os.ReadDir(fs.dir)
+	for _, de := range entries {
+		name := de.Name()
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(fs.dir, name))
+		if err != nil {
+			continue
+		}
+		var e fileIdempotencyEntry
+		if json.Unmarshal(b, &e) != nil || now.After(e.Expiry) {
+			os.Remove(filepath.Join(fs.dir, name))
+		}
+	}
+}
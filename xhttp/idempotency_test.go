@@ -0,0 +1,283 @@
+package xhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func idempotencyRequest(method, key, body string) *http.Request {
+	r := httptest.NewRequest(method, "/orders", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("Idempotency-Key", key)
+	}
+	return r
+}
+
+func TestIdempotencyReplay(t *testing.T) {
+	fileStore, err := NewFileIdempotencyStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("file store: %v", err)
+	}
+	stores := map[string]IdempotencyStore{"memory": NewMemoryIdempotencyStore(), "file": fileStore}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			var calls atomic.Int32
+			idem, _ := NewIdempotency(&IdempotencyConfig{Store: store})
+			h := idem.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+				w.Header().Set("Location", fmt.Sprintf("/orders/%d", n))
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, "order %d", n)
+			}))
+			serve := func(r *http.Request) *httptest.ResponseRecorder {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, r)
+				return rec
+			}
+
+			first := serve(idempotencyRequest("POST", "k1", `{"amount":10}`))
+			second := serve(idempotencyRequest("POST", "k1", `{"amount":10}`))
+			if calls.Load() != 1 || second.Code != http.StatusCreated || second.Body.String() != "order 1" ||
+				second.Header().Get("Location") != "/orders/1" || second.Header().Get("Idempotent-Replayed") != "true" {
+				t.Fatalf("want replay of first response, got %d %q %v after %d calls", second.Code, second.Body, second.Header(), calls.Load())
+			}
+			if first.Header().Get("Idempotent-Replayed") != "" {
+				t.Fatal("first response marked as replayed")
+			}
+
+			// a different request with the same key
+			if rec := serve(idempotencyRequest("POST", "k1", `{"amount":99}`)); rec.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("want 422 for reused key, got %d", rec.Code)
+			}
+
+			// no key or a safe method pass through
+			serve(idempotencyRequest("POST", "", `{"amount":10}`))
+			serve(idempotencyRequest("GET", "k1", ""))
+			if calls.Load() != 3 {
+				t.Fatalf("want unkeyed and safe requests served, got %d calls", calls.Load())
+			}
+		})
+	}
+}
+
+func TestIdempotencyInFlight(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	idem, _ := NewIdempotency(&IdempotencyConfig{})
+	h := idem.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), idempotencyRequest("POST", "k", ""))
+		close(done)
+	}()
+	<-started
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, idempotencyRequest("POST", "k", ""))
+	if rec.Code != http.StatusConflict || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("want 409 with Retry-After while in flight, got %d %v", rec.Code, rec.Header())
+	}
+	close(release)
+	<-done
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, idempotencyRequest("POST", "k", ""))
+	if rec.Code != http.StatusOK || rec.Body.String() != "done" {
+		t.Fatalf("want replay after completion, got %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestIdempotencyNotStored(t *testing.T) {
+	var calls atomic.Int32
+	var mode atomic.Value
+	idem, _ := NewIdempotency(&IdempotencyConfig{MaxBodySize: 8})
+	h := idem.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch mode.Load() {
+		case "error":
+			http.Error(w, "oops", http.StatusServiceUnavailable)
+		case "panic":
+			panic(http.ErrAbortHandler)
+		case "large":
+			w.Write([]byte("more than eight bytes"))
+		}
+	}))
+
+	for _, m := range []string{"error", "panic", "large"} {
+		mode.Store(m)
+		calls.Store(0)
+		for range 2 {
+			func() {
+				defer func() { recover() }()
+				h.ServeHTTP(httptest.NewRecorder(), idempotencyRequest("POST", m, ""))
+			}()
+		}
+		if calls.Load() != 2 {
+			t.Fatalf("%s: want response not stored, got %d calls", m, calls.Load())
+		}
+	}
+
+	// nothing written is stored as an empty 200
+	mode.Store("")
+	calls.Store(0)
+	h.ServeHTTP(httptest.NewRecorder(), idempotencyRequest("DELETE", "empty", ""))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, idempotencyRequest("DELETE", "empty", ""))
+	if calls.Load() != 1 || rec.Code != http.StatusOK {
+		t.Fatalf("want empty response replayed, got %d after %d calls", rec.Code, calls.Load())
+	}
+}
+
+func TestIdempotencyErrors(t *testing.T) {
+	idem, _ := NewIdempotency(&IdempotencyConfig{Required: true, MaxBodySize: 4})
+	h := idem.Middleware(noopHandler())
+	tests := []struct {
+		name string
+		r    *http.Request
+		code int
+	}{
+		{"missing key", idempotencyRequest("POST", "", ""), http.StatusBadRequest},
+		{"long key", idempotencyRequest("POST", strings.Repeat("k", 256), ""), http.StatusBadRequest},
+		{"large body", idempotencyRequest("POST", "k", "12345"), http.StatusRequestEntityTooLarge},
+		{"safe method", idempotencyRequest("GET", "", ""), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, tt.r)
+			if rec.Code != tt.code {
+				t.Fatalf("want %d, got %d", tt.code, rec.Code)
+			}
+		})
+	}
+
+	if _, err := NewIdempotency(&IdempotencyConfig{TTL: -1}); err == nil {
+		t.Fatal("expected error for negative TTL")
+	}
+}
+
+func TestIdempotencyScope(t *testing.T) {
+	var calls atomic.Int32
+	idem, _ := NewIdempotency(&IdempotencyConfig{Scope: func(r *http.Request) string { return r.Header.Get("X-User") }})
+	h := idem.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls.Add(1) }))
+	for _, user := range []string{"ada", "bob", "ada"} {
+		r := idempotencyRequest("POST", "k", "")
+		r.Header.Set("X-User", user)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("want keys scoped per user, got %d calls", calls.Load())
+	}
+}
+
+func TestFileIdempotencyStore(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "idempotency")
+	ctx := context.Background()
+	a, _ := NewFileIdempotencyStore(dir)
+	b, _ := NewFileIdempotencyStore(dir) // another process
+
+	if resp, err := a.Begin(ctx, "k", "a", time.Now().Add(time.Minute)); resp != nil || err != nil {
+		t.Fatalf("want reservation, got %v %v", resp, err)
+	}
+	if _, err := b.Begin(ctx, "k", "b", time.Now().Add(time.Minute)); !errors.Is(err, ErrIdempotencyInFlight) {
+		t.Fatalf("want in flight for other process, got %v", err)
+	}
+	want := &IdempotentResponse{Fingerprint: "f", Status: 201, Header: http.Header{"X": {"y"}}, Body: []byte("body")}
+	if err := a.Complete(ctx, "k", "a", want, time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if resp, err := b.Begin(ctx, "k", "b", time.Now().Add(time.Minute)); err != nil || resp == nil || string(resp.Body) != "body" || resp.Header.Get("X") != "y" {
+		t.Fatalf("want stored response, got %+v %v", resp, err)
+	}
+
+	// an expired lock, e.g. from a crashed process, is taken over
+	a.Begin(ctx, "crashed", "a", time.Now().Add(-time.Second))
+	if resp, err := b.Begin(ctx, "crashed", "b", time.Now().Add(time.Minute)); resp != nil || err != nil {
+		t.Fatalf("want expired lock taken over, got %v %v", resp, err)
+	}
+	// and a late release or completion by the previous owner leaves the new reservation alone
+	a.Release(ctx, "crashed", "a")
+	a.Complete(ctx, "crashed", "a", want, time.Now().Add(time.Minute))
+	if resp, err := a.Begin(ctx, "crashed", "c", time.Now().Add(time.Minute)); !errors.Is(err, ErrIdempotencyInFlight) {
+		t.Fatalf("want reservation kept after a stale release and completion, got %v %v", resp, err)
+	}
+
+	// expired responses are neither returned nor kept
+	a.Complete(ctx, "old", "a", want, time.Now().Add(-time.Second))
+	if resp, err := a.Begin(ctx, "old", "a", time.Now().Add(time.Minute)); resp != nil || err != nil {
+		t.Fatalf("want expired response ignored, got %v %v", resp, err)
+	}
+	a.Release(ctx, "old", "a")
+	a.lastSweep = time.Time{}
+	a.sweep()
+	if matches, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(matches) != 1 {
+		t.Fatalf("want only the live response kept, got %v", matches)
+	}
+	if fi, _ := os.Stat(dir); fi.Mode().Perm() != 0o700 {
+		t.Fatalf("want private directory, got %v", fi.Mode())
+	}
+}
+
+func TestFileIdempotencyStoreTakeover(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	for i := range 20 {
+		key := strconv.Itoa(i)
+		first, _ := NewFileIdempotencyStore(dir)
+		first.Begin(ctx, key, "crashed", time.Now().Add(-time.Second))
+
+		// processes racing to take the expired lock over
+		var won atomic.Int32
+		var wg sync.WaitGroup
+		for j := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				fs, _ := NewFileIdempotencyStore(dir)
+				if _, err := fs.Begin(ctx, key, strconv.Itoa(j), time.Now().Add(time.Minute)); err == nil {
+					won.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if won.Load() != 1 {
+			t.Fatalf("want exactly one takeover, got %d", won.Load())
+		}
+	}
+}
+
+func TestMemoryIdempotencyStoreRelease(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryIdempotencyStore()
+	ms.Begin(ctx, "k", "old", time.Now().Add(-time.Second))
+	if _, err := ms.Begin(ctx, "k", "new", time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("want expired reservation taken over, got %v", err)
+	}
+	ms.Release(ctx, "k", "old")
+	if _, err := ms.Begin(ctx, "k", "other", time.Now().Add(time.Minute)); !errors.Is(err, ErrIdempotencyInFlight) {
+		t.Fatalf("want reservation kept after a stale release, got %v", err)
+	}
+	// nor does a late completion, which would replay its response to the new request's duplicates
+	ms.Complete(ctx, "k", "old", &IdempotentResponse{Status: 201}, time.Now().Add(time.Minute))
+	if _, err := ms.Begin(ctx, "k", "other", time.Now().Add(time.Minute)); !errors.Is(err, ErrIdempotencyInFlight) {
+		t.Fatalf("want reservation kept after a stale completion, got %v", err)
+	}
+	ms.Release(ctx, "k", "new")
+	if _, err := ms.Begin(ctx, "k", "other", time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("want reservation released by its owner, got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package xhttp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultBearerLeeway is the default clock skew allowed when checking token times.
+const DefaultBearerLeeway = 30 * time.Second
+
+// BearerAuthConfig holds configuration options for [BearerAuth]. At least one of HMACKey or
+// ECDSAKey is required, and only the matching algorithms are accepted.
+type BearerAuthConfig struct {
+	HMACKey  []byte           // Key for HS256 tokens, at least 32 bytes.
+	ECDSAKey *ecdsa.PublicKey // P-256 public key for ES256 tokens.
+
+	Issuer   string // If set, the "iss" claim must match.
+	Audience string // If set, the "aud" claim must contain it.
+
+	Leeway time.Duration // Clock skew allowed for "exp", "nbf", and "iat". Default is 30 seconds. Negative for none.
+	Realm  string        // Realm sent in the challenge. Default is "api".
+}
+
+// BearerAuth authenticates requests with signed JWT bearer tokens (RFC 7519) in the
+// Authorization header, verifying HS256 or ES256 signatures without dependencies.
+//
+// Tokens must have an "exp" claim. The "sub" claim becomes [Principal.ID], and all claims are
+// available in [Principal.Claims]. The "alg" header is checked against the configured keys,
+// so "none" and algorithm confusion attacks are rejected.
+type BearerAuth struct {
+	cfg *BearerAuthConfig
+	now func() time.Time
+}
+
+// NewBearerAuth creates a new BearerAuth with the provided configuration.
+func NewBearerAuth(cfg *BearerAuthConfig) (*BearerAuth, error) {
+	copy := *cfg
+
+	if copy.HMACKey == nil && copy.ECDSAKey == nil {
+		return nil, fmt.Errorf("an HMAC or ECDSA key must be provided")
+	}
+	if copy.HMACKey != nil && len(copy.HMACKey) < 32 {
+		return nil, fmt.Errorf("HMAC key must be at least 32 bytes")
+	}
+	if copy.ECDSAKey != nil && copy.ECDSAKey.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("ECDSA key must use the P-256 curve")
+	}
+
+	// set defaults
+
+	if copy.Leeway == 0 {
+		copy.Leeway = DefaultBearerLeeway
+	}
+	if copy.Leeway < 0 {
+		copy.Leeway = 0
+	}
+	if copy.Realm == "" {
+		copy.Realm = "api"
+	}
+
+	return &BearerAuth{cfg: &copy, now: time.Now}, nil
+}
+
+// Authenticate implements [Authenticator].
+func (ba *BearerAuth) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, ErrNoCredentials
+	}
+	claims, err := ba.Verify(strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+	sub, _ := claims["sub"].(string)
+	return &Principal{ID: sub, Method: "bearer", Claims: claims}, nil
+}
+
+// Challenge implements [Authenticator].
+func (ba *BearerAuth) Challenge() string {
+	return `Bearer realm="` + ba.cfg.Realm + `"`
+}
+
+// Verify checks a compact JWT's signature and claims, returning the claims.
+func (ba *BearerAuth) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	enc := base64.RawURLEncoding
+
+	var header struct {
+		Alg string `json:"alg"`
+		Typ string `json:"typ"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == "HS256" && ba.cfg.HMACKey != nil:
+		mac := hmac.New(sha256.New, ba.cfg.HMACKey)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, errors.New("invalid token signature")
+		}
+	case header.Alg == "ES256" && ba.cfg.ECDSAKey != nil:
+		if len(sig) != 64 {
+			return nil, errors.New("invalid token signature")
+		}
+		digest := sha256.Sum256(signed)
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(ba.cfg.ECDSAKey, digest[:], r, s) {
+			return nil, errors.New("invalid token signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if err := ba.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (ba *BearerAuth) checkClaims(claims map[string]any) error {
+	now := ba.now()
+	leeway := ba.cfg.Leeway
+
+	exp, ok := numericDate(claims["exp"])
+	if !ok {
+		return errors.New("token has no expiry")
+	}
+	if now.After(exp.Add(leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := numericDate(claims["nbf"]); ok && now.Add(leeway).Before(nbf) {
+		return errors.New("token not valid yet")
+	}
+	if iat, ok := numericDate(claims["iat"]); ok && now.Add(leeway).Before(iat) {
+		return errors.New("token issued in the future")
+	}
+
+	if ba.cfg.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != ba.cfg.Issuer {
+			return fmt.Errorf("unexpected token issuer %q", iss)
+		}
+	}
+	if ba.cfg.Audience != "" && !hasAudience(claims["aud"], ba.cfg.Audience) {
+		return errors.New("token audience mismatch")
+	}
+	return nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// numericDate converts a JSON number claim to a time.
+func numericDate(v any) (time.Time, bool) {
+	f, ok := v.(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	sec := int64(f)
+	return time.Unix(sec, int64((f-float64(sec))*1e9)), true
+}
+
+// hasAudience reports whether an "aud" claim, a string or array of strings, contains want.
+func hasAudience(aud any, want string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == want
+	case []any:
+		for _, a := range v {
+			if s, _ := a.(string); s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
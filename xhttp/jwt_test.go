@@ -0,0 +1,153 @@
+package xhttp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testJWTKey = bytes.Repeat([]byte("j"), 32)
+
+func signHS256(t *testing.T, key []byte, header, claims map[string]any) string {
+	t.Helper()
+	signing := jwtSigningInput(t, header, claims)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signing))
+	return signing + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+	signing := jwtSigningInput(t, map[string]any{"alg": "ES256", "typ": "JWT"}, claims)
+	digest := sha256.Sum256([]byte(signing))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func jwtSigningInput(t *testing.T, header, claims map[string]any) string {
+	t.Helper()
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+}
+
+func TestNewBearerAuthValidation(t *testing.T) {
+	if _, err := NewBearerAuth(&BearerAuthConfig{}); err == nil {
+		t.Fatal("expected error without keys")
+	}
+	if _, err := NewBearerAuth(&BearerAuthConfig{HMACKey: []byte("short")}); err == nil {
+		t.Fatal("expected error for short HMAC key")
+	}
+}
+
+func TestBearerAuthHS256(t *testing.T) {
+	ba, _ := NewBearerAuth(&BearerAuthConfig{HMACKey: testJWTKey, Issuer: "app", Audience: "api"})
+	now := time.Unix(1_700_000_000, 0)
+	ba.now = func() time.Time { return now }
+	hs := map[string]any{"alg": "HS256", "typ": "JWT"}
+	valid := func() map[string]any {
+		return map[string]any{"sub": "alice", "iss": "app", "aud": []string{"web", "api"}, "exp": now.Add(time.Minute).Unix()}
+	}
+
+	p := authBearer(t, ba, signHS256(t, testJWTKey, hs, valid()))
+	if p == nil || p.ID != "alice" || p.Method != "bearer" || p.Claims["iss"] != "app" {
+		t.Fatalf("unexpected principal: %+v", p)
+	}
+
+	tests := map[string]string{}
+	c := valid()
+	c["exp"] = now.Add(-time.Minute).Unix()
+	tests["expired"] = signHS256(t, testJWTKey, hs, c)
+	c = valid()
+	delete(c, "exp")
+	tests["no exp"] = signHS256(t, testJWTKey, hs, c)
+	c = valid()
+	c["nbf"] = now.Add(time.Minute).Unix()
+	tests["not yet valid"] = signHS256(t, testJWTKey, hs, c)
+	c = valid()
+	c["iss"] = "other"
+	tests["wrong issuer"] = signHS256(t, testJWTKey, hs, c)
+	c = valid()
+	c["aud"] = "web"
+	tests["wrong audience"] = signHS256(t, testJWTKey, hs, c)
+	tests["wrong key"] = signHS256(t, bytes.Repeat([]byte("x"), 32), hs, valid())
+	tests["alg none"] = jwtSigningInput(t, map[string]any{"alg": "none"}, valid()) + "."
+	tests["alg confusion"] = signHS256(t, testJWTKey, map[string]any{"alg": "ES256"}, valid())
+	tests["malformed"] = "not.a.jwt.at.all"
+
+	for name, token := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Authorization", "Bearer "+token)
+			if _, err := ba.Authenticate(r); err == nil || errors.Is(err, ErrNoCredentials) {
+				t.Fatalf("want invalid token error, got %v", err)
+			}
+		})
+	}
+
+	// within leeway
+	c = valid()
+	c["exp"] = now.Add(-10 * time.Second).Unix()
+	if authBearer(t, ba, signHS256(t, testJWTKey, hs, c)) == nil {
+		t.Fatal("token expired within leeway should be accepted")
+	}
+}
+
+func TestBearerAuthES256(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ba, err := NewBearerAuth(&BearerAuthConfig{ECDSAKey: &key.PublicKey})
+	if err != nil {
+		t.Fatalf("new bearer auth: %v", err)
+	}
+	claims := map[string]any{"sub": "svc", "exp": time.Now().Add(time.Hour).Unix()}
+
+	if p := authBearer(t, ba, signES256(t, key, claims)); p == nil || p.ID != "svc" {
+		t.Fatalf("unexpected principal: %+v", p)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+signES256(t, other, claims))
+	if _, err := ba.Authenticate(r); err == nil {
+		t.Fatal("token signed by another key should be rejected")
+	}
+	r.Header.Set("Authorization", "Bearer "+signHS256(t, testJWTKey, map[string]any{"alg": "HS256"}, claims))
+	if _, err := ba.Authenticate(r); err == nil {
+		t.Fatal("HS256 token should be rejected without an HMAC key")
+	}
+}
+
+func TestBearerAuthNoCredentials(t *testing.T) {
+	ba, _ := NewBearerAuth(&BearerAuthConfig{HMACKey: testJWTKey})
+	r := httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("a", "b")
+	if _, err := ba.Authenticate(r); !errors.Is(err, ErrNoCredentials) {
+		t.Fatalf("want ErrNoCredentials, got %v", err)
+	}
+}
+
+func authBearer(t *testing.T, ba *BearerAuth, token string) *Principal {
+	t.Helper()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	p, err := ba.Authenticate(r)
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	return p
+}
@@ -0,0 +1,546 @@
+package xhttp
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+// DefaultMetricsBuckets are the default request duration histogram buckets, in seconds.
+var DefaultMetricsBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	metricNamePattern = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNamePattern  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// MetricsConfig holds configuration options for [Metrics].
+type MetricsConfig struct {
+	// Namespace prefixes the names of built-in metrics, e.g. "myapp" for "myapp_http_requests_total".
+	Namespace string
+
+	Buckets []float64 // Request duration histogram buckets, in seconds. Default is [DefaultMetricsBuckets].
+
+	// RouteFunc returns the route label of a request, after it has been handled. Never use the raw
+	// path, as its cardinality is unbounded. Default is [http.Request.Pattern], which is set by
+	// [http.ServeMux] when the middleware wraps the mux directly or individual routes, or "unmatched".
+	RouteFunc func(r *http.Request) string
+}
+
+// Metrics is a dependency-free registry of counters, gauges, and histograms, served in the
+// Prometheus text format by its ServeHTTP method.
+//
+// Metrics are registered once, typically at startup. Registering a name again with the same
+// type and labels returns the existing metric. Anything else, like invalid names, is a bug in
+// the calling code and panics, while configuration problems are returned by [NewMetrics] and
+// [NewServer].
+//
+// Usage:
+//
+//	metrics, _ := xhttp.NewMetrics(&xhttp.MetricsConfig{Namespace: "myapp"})
+//	jobs := metrics.Counter("myapp_jobs_total", "Jobs processed.", "queue")
+//	jobs.With("emails").Inc()
+//
+//	metrics.RegisterLogger(logger) // xlog line counters
+//	mux.Handle("GET /metrics", metrics)
+//	handler = metrics.Middleware(mux)
+//	srv, _ := xhttp.NewServer(&xhttp.ServerConfig{Handler: handler, Metrics: metrics})
+type Metrics struct {
+	cfg *MetricsConfig
+
+	mu       sync.Mutex
+	families map[string]*metricFamily
+
+	requests *Counter
+	duration *Histogram
+	inFlight *Gauge
+}
+
+// NewMetrics creates a new Metrics with the provided configuration, registering the request
+// metrics recorded by [Metrics.Middleware].
+func NewMetrics(cfg *MetricsConfig) (*Metrics, error) {
+	copy := *cfg
+
+	if copy.Namespace != "" && !metricNamePattern.MatchString(copy.Namespace) {
+		return nil, fmt.Errorf("invalid metrics namespace %q", copy.Namespace)
+	}
+	if err := validBuckets(copy.Buckets); err != nil {
+		return nil, err
+	}
+
+	// set defaults
+
+	if len(copy.Buckets) == 0 {
+		copy.Buckets = DefaultMetricsBuckets
+	}
+	if copy.RouteFunc == nil {
+		copy.RouteFunc = func(r *http.Request) string {
+			if r.Pattern == "" {
+				return "unmatched"
+			}
+			return r.Pattern
+		}
+	}
+
+	m := &Metrics{cfg: &copy, families: make(map[string]*metricFamily)}
+	labels := []string{"method", "route", "code"}
+	requests, err := m.register(m.name("http_requests_total"), "Total HTTP requests handled.", "counter", labels, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	duration, err := m.register(m.name("http_request_duration_seconds"), "HTTP request latency in seconds.", "histogram", labels, copy.Buckets, nil)
+	if err != nil {
+		return nil, err
+	}
+	inFlight, err := m.register(m.name("http_requests_in_flight"), "HTTP requests currently being handled.", "gauge", nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	m.requests = &Counter{f: requests}
+	m.duration = &Histogram{f: duration}
+	m.inFlight = &Gauge{f: inFlight, s: inFlight.base()}
+	return m, nil
+}
+
+func (m *Metrics) name(name string) string {
+	if m.cfg.Namespace == "" {
+		return name
+	}
+	return m.cfg.Namespace + "_" + name
+}
+
+// Counter registers a counter, a value that only goes up. With labels, use [Counter.With] to
+// pick a series.
+func (m *Metrics) Counter(name, help string, labels ...string) *Counter {
+	f := m.mustRegister(name, help, "counter", labels, nil, nil)
+	return &Counter{f: f, s: f.base()}
+}
+
+// Gauge registers a gauge, a value that can go up and down. With labels, use [Gauge.With] to
+// pick a series.
+func (m *Metrics) Gauge(name, help string, labels ...string) *Gauge {
+	f := m.mustRegister(name, help, "gauge", labels, nil, nil)
+	return &Gauge{f: f, s: f.base()}
+}
+
+// Histogram registers a histogram with the given upper bucket bounds, which must be sorted.
+// A +Inf bucket is implicit. With labels, use [Histogram.With] to pick a series.
+func (m *Metrics) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	f := m.mustRegister(name, help, "histogram", labels, buckets, nil)
+	return &Histogram{f: f, s: f.base()}
+}
+
+// CounterFunc registers a counter whose value is read from fn on each scrape.
+func (m *Metrics) CounterFunc(name, help string, fn func() float64) {
+	m.mustRegister(name, help, "counter", nil, nil, func(emit func(float64, ...string)) { emit(fn()) })
+}
+
+// GaugeFunc registers a gauge whose value is read from fn on each scrape.
+func (m *Metrics) GaugeFunc(name, help string, fn func() float64) {
+	m.mustRegister(name, help, "gauge", nil, nil, func(emit func(float64, ...string)) { emit(fn()) })
+}
+
+// RegisterLogger exports the per-level line counts of an [xlog.Logger] as the counter
+// "xlog_lines_total" with a "level" label, prefixed by the namespace.
+func (m *Metrics) RegisterLogger(l *xlog.Logger) {
+	m.mustRegister(m.name("xlog_lines_total"), "Log lines written, by level.", "counter", []string{"level"}, nil,
+		func(emit func(float64, ...string)) {
+			for level, n := range l.LineCounts() {
+				emit(float64(n), level)
+			}
+		})
+}
+
+// mustRegister is register for metrics registered by the calling code, panicking on errors.
+func (m *Metrics) mustRegister(name, help, typ string, labels []string, buckets []float64, collect func(func(float64, ...string))) *metricFamily {
+	f, err := m.register(name, help, typ, labels, buckets, collect)
+	if err != nil {
+		panic("xhttp: " + err.Error())
+	}
+	return f
+}
+
+func (m *Metrics) register(name, help, typ string, labels []string, buckets []float64, collect func(func(float64, ...string))) (*metricFamily, error) {
+	if !metricNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid metric name %q", name)
+	}
+	for _, l := range labels {
+		if !labelNamePattern.MatchString(l) || strings.HasPrefix(l, "__") || (typ == "histogram" && l == "le") {
+			return nil, fmt.Errorf("metric %s: invalid label name %q", name, l)
+		}
+	}
+	if typ == "histogram" {
+		if err := validBuckets(buckets); err != nil || len(buckets) == 0 {
+			return nil, fmt.Errorf("metric %s: invalid buckets %v", name, buckets)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f, ok := m.families[name]; ok {
+		if f.typ != typ || !slices.Equal(f.labels, labels) || f.collect != nil || collect != nil {
+			return nil, fmt.Errorf("metric %s already registered", name)
+		}
+		return f, nil
+	}
+	f := &metricFamily{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  slices.Clone(labels),
+		buckets: slices.Clone(buckets),
+		collect: collect,
+		series:  make(map[string]*metricSeries),
+	}
+	m.families[name] = f
+	return f, nil
+}
+
+func validBuckets(buckets []float64) error {
+	for i, b := range buckets {
+		if math.IsNaN(b) || math.IsInf(b, 0) || (i > 0 && b <= buckets[i-1]) {
+			return fmt.Errorf("histogram buckets must be finite and strictly increasing")
+		}
+	}
+	return nil
+}
+
+// Middleware records the count, latency, and in-flight number of requests to next, by method,
+// route, and status code.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.inFlight.Inc()
+		defer m.inFlight.Dec()
+		start := time.Now()
+
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK // what net/http sends when the handler writes nothing
+		}
+		labels := []string{metricMethod(r.Method), m.cfg.RouteFunc(r), strconv.Itoa(status)}
+		m.requests.With(labels...).Inc()
+		m.duration.With(labels...).Observe(time.Since(start).Seconds())
+	})
+}
+
+// metricMethod bounds the method label's cardinality, as clients can send any method.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// registerServer exports lifecycle gauges for s. It fails if the names are taken, e.g. when
+// the Metrics is already used by another Server.
+func (m *Metrics) registerServer(s *Server) error {
+	collect := func(fn func() float64) func(func(float64, ...string)) {
+		return func(emit func(float64, ...string)) { emit(fn()) }
+	}
+	for _, g := range []struct {
+		name, help, typ string
+		fn              func() float64
+	}{
+		{"http_server_start_time_seconds", "Unix time the server started listening.", "gauge", func() float64 {
+			return float64(s.started.Load())
+		}},
+		{"http_server_shutting_down", "Whether the server is shutting down.", "gauge", func() float64 {
+			select {
+			case <-s.state.shutdown:
+				return 1
+			default:
+				return 0
+			}
+		}},
+		{"http_server_open_connections", "Open HTTP connections, excluding hijacked ones.", "gauge", func() float64 {
+			return float64(s.state.conns.open())
+		}},
+		{"http_server_rejected_connections_total", "Connections closed for exceeding MaxConns or MaxConnsPerIP.", "counter", func() float64 {
+			return float64(s.Stats().Rejected)
+		}},
+		{"http_server_open_websockets", "Open WebSocket connections.", "gauge", func() float64 {
+			s.state.mu.Lock()
+			defer s.state.mu.Unlock()
+			return float64(len(s.state.sockets))
+		}},
+	} {
+		if _, err := m.register(m.name(g.name), g.help, g.typ, nil, nil, collect(g.fn)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	var b strings.Builder
+	m.writeText(&b)
+	io.WriteString(w, b.String())
+}
+
+func (m *Metrics) writeText(b *strings.Builder) {
+	m.mu.Lock()
+	families := make([]*metricFamily, 0, len(m.families))
+	for _, f := range m.families {
+		families = append(families, f)
+	}
+	m.mu.Unlock()
+	slices.SortFunc(families, func(a, b *metricFamily) int { return strings.Compare(a.name, b.name) })
+
+	for _, f := range families {
+		series := f.snapshot()
+		if len(series) == 0 {
+			continue
+		}
+		fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+		for _, s := range series {
+			if f.typ != "histogram" {
+				b.WriteString(f.name + formatLabels(f.labels, s.labelValues, "") + " " + formatFloat(s.value.Load()) + "\n")
+				continue
+			}
+			var cumulative uint64
+			for i, upper := range f.buckets {
+				cumulative += s.counts[i].Load()
+				b.WriteString(f.name + "_bucket" + formatLabels(f.labels, s.labelValues, formatFloat(upper)) + " " + strconv.FormatUint(cumulative, 10) + "\n")
+			}
+			count := s.count.Load()
+			b.WriteString(f.name + "_bucket" + formatLabels(f.labels, s.labelValues, "+Inf") + " " + strconv.FormatUint(count, 10) + "\n")
+			b.WriteString(f.name + "_sum" + formatLabels(f.labels, s.labelValues, "") + " " + formatFloat(s.sum.Load()) + "\n")
+			b.WriteString(f.name + "_count" + formatLabels(f.labels, s.labelValues, "") + " " + strconv.FormatUint(count, 10) + "\n")
+		}
+	}
+}
+
+func formatLabels(names, values []string, le string) string {
+	if len(names) == 0 && le == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + `="` + escapeLabel(values[i]) + `"`)
+	}
+	if le != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`le="` + le + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricFamily is a named metric and its series, one per combination of label values.
+type metricFamily struct {
+	name, help, typ string
+	labels          []string
+	buckets         []float64                           // histograms only
+	collect         func(emit func(float64, ...string)) // func-backed families, read on scrape
+
+	mu     sync.RWMutex
+	series map[string]*metricSeries // by joined label values
+}
+
+type metricSeries struct {
+	labelValues []string
+	value       atomicFloat     // counters and gauges
+	counts      []atomic.Uint64 // histograms, per bucket
+	count       atomic.Uint64
+	sum         atomicFloat
+}
+
+// base returns the series of a family without labels, or nil.
+func (f *metricFamily) base() *metricSeries {
+	if len(f.labels) > 0 {
+		return nil
+	}
+	return f.get(nil)
+}
+
+func (f *metricFamily) get(values []string) *metricSeries {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("xhttp: metric %s: want %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.RLock()
+	s := f.series[key]
+	f.mu.RUnlock()
+	if s != nil {
+		return s
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s = f.series[key]; s == nil {
+		s = &metricSeries{labelValues: slices.Clone(values)}
+		if f.typ == "histogram" {
+			s.counts = make([]atomic.Uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// snapshot returns the family's series sorted by label values, collecting func-backed ones.
+func (f *metricFamily) snapshot() []*metricSeries {
+	var series []*metricSeries
+	if f.collect != nil {
+		f.collect(func(v float64, values ...string) {
+			s := &metricSeries{labelValues: values}
+			s.value.Store(v)
+			series = append(series, s)
+		})
+	} else {
+		f.mu.RLock()
+		for _, s := range f.series {
+			series = append(series, s)
+		}
+		f.mu.RUnlock()
+	}
+	slices.SortFunc(series, func(a, b *metricSeries) int { return slices.Compare(a.labelValues, b.labelValues) })
+	return series
+}
+
+// Counter is a metric that only goes up, see [Metrics.Counter].
+type Counter struct {
+	f *metricFamily
+	s *metricSeries
+}
+
+// With returns the series for the given label values, in the order the labels were registered.
+func (c *Counter) With(labelValues ...string) *Counter {
+	return &Counter{f: c.f, s: c.f.get(labelValues)}
+}
+
+// Inc adds 1.
+func (c *Counter) Inc() { c.s.value.Add(1) }
+
+// Add adds v, which must not be negative. Negative values are ignored.
+func (c *Counter) Add(v float64) {
+	if v > 0 {
+		c.s.value.Add(v)
+	}
+}
+
+// Gauge is a metric that can go up and down, see [Metrics.Gauge].
+type Gauge struct {
+	f *metricFamily
+	s *metricSeries
+}
+
+// With returns the series for the given label values, in the order the labels were registered.
+func (g *Gauge) With(labelValues ...string) *Gauge {
+	return &Gauge{f: g.f, s: g.f.get(labelValues)}
+}
+
+func (g *Gauge) Set(v float64) { g.s.value.Store(v) }
+func (g *Gauge) Add(v float64) { g.s.value.Add(v) }
+func (g *Gauge) Inc()          { g.s.value.Add(1) }
+func (g *Gauge) Dec()          { g.s.value.Add(-1) }
+
+// Histogram is a metric counting observations into buckets, see [Metrics.Histogram].
+type Histogram struct {
+	f *metricFamily
+	s *metricSeries
+}
+
+// With returns the series for the given label values, in the order the labels were registered.
+func (h *Histogram) With(labelValues ...string) *Histogram {
+	return &Histogram{f: h.f, s: h.f.get(labelValues)}
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v float64) {
+	if i, _ := slices.BinarySearch(h.f.buckets, v); i < len(h.f.buckets) {
+		h.s.counts[i].Add(1)
+	}
+	h.s.sum.Add(v)
+	h.s.count.Add(1)
+}
+
+// atomicFloat is a float64 updated atomically through its bits.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (a *atomicFloat) Load() float64   { return math.Float64frombits(a.bits.Load()) }
+func (a *atomicFloat) Store(v float64) { a.bits.Store(math.Float64bits(v)) }
+
+func (a *atomicFloat) Add(v float64) {
+	for {
+		old := a.bits.Load()
+		if a.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// statusWriter records the response status code.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 && (code < 100 || code >= 200) {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *statusWriter) Flush() {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	_ = http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
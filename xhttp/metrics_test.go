@@ -0,0 +1,175 @@
+package xhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	return rec.Body.String()
+}
+
+func wantLines(t *testing.T, body string, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			t.Fatalf("missing %q in:\n%s", line, body)
+		}
+	}
+}
+
+func TestNewMetricsValidation(t *testing.T) {
+	if _, err := NewMetrics(&MetricsConfig{Namespace: "my-app"}); err == nil {
+		t.Fatal("expected error for invalid namespace")
+	}
+	if _, err := NewMetrics(&MetricsConfig{Buckets: []float64{1, 0.5}}); err == nil {
+		t.Fatal("expected error for unsorted buckets")
+	}
+	if _, err := NewMetrics(&MetricsConfig{Buckets: []float64{}}); err != nil {
+		t.Fatalf("want default buckets for an empty slice, got %v", err)
+	}
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	m, _ := NewMetrics(&MetricsConfig{})
+
+	jobs := m.Counter("jobs_total", "Jobs processed.", "queue")
+	jobs.With("emails").Inc()
+	jobs.With("emails").Add(2)
+	jobs.With("emails").Add(-5) // ignored
+	jobs.With(`a"b\c`).Inc()
+	if again := m.Counter("jobs_total", "Jobs processed.", "queue"); again.f != jobs.f {
+		t.Fatal("registering again should return the existing metric")
+	}
+
+	temp := m.Gauge("temperature", "Line one.\nLine two.")
+	temp.Set(21.5)
+	temp.Dec()
+
+	h := m.Histogram("size_bytes", "Sizes.", []float64{10, 100})
+	for _, v := range []float64{5, 10, 50, 500} {
+		h.Observe(v)
+	}
+	m.GaugeFunc("answer", "The answer.", func() float64 { return 42 })
+
+	wantLines(t, scrape(t, m),
+		"# HELP jobs_total Jobs processed.",
+		"# TYPE jobs_total counter",
+		`jobs_total{queue="a\"b\\c"} 1`,
+		`jobs_total{queue="emails"} 3`,
+		`# HELP temperature Line one.\nLine two.`,
+		"temperature 20.5",
+		"# TYPE size_bytes histogram",
+		`size_bytes_bucket{le="10"} 2`,
+		`size_bytes_bucket{le="100"} 3`,
+		`size_bytes_bucket{le="+Inf"} 4`,
+		"size_bytes_sum 565",
+		"size_bytes_count 4",
+		"answer 42",
+	)
+
+	for name, fn := range map[string]func(){
+		"invalid name":    func() { m.Counter("bad-name", "") },
+		"invalid label":   func() { m.Counter("ok", "", "__reserved") },
+		"type conflict":   func() { m.Gauge("jobs_total", "", "queue") },
+		"label conflict":  func() { m.Counter("jobs_total", "") },
+		"label count":     func() { jobs.With("a", "b") },
+		"invalid buckets": func() { m.Histogram("h", "", nil) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("expected panic")
+				}
+			}()
+			fn()
+		})
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	m, _ := NewMetrics(&MetricsConfig{Namespace: "app", Buckets: []float64{60}})
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user"))
+	})
+	mux.HandleFunc("POST /users", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	h := m.Middleware(mux)
+
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/users/1"}, {"GET", "/users/2"}, {"POST", "/users"}, {"GET", "/nope"}, {"BREW", "/users"},
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	wantLines(t, scrape(t, m),
+		`app_http_requests_total{method="GET",route="GET /users/{id}",code="200"} 2`,
+		`app_http_requests_total{method="POST",route="POST /users",code="201"} 1`,
+		`app_http_requests_total{method="GET",route="unmatched",code="404"} 1`,
+		`app_http_requests_total{method="OTHER",route="unmatched",code="405"} 1`,
+		`app_http_request_duration_seconds_bucket{method="GET",route="GET /users/{id}",code="200",le="60"} 2`,
+		`app_http_request_duration_seconds_count{method="GET",route="GET /users/{id}",code="200"} 2`,
+		"app_http_requests_in_flight 0",
+	)
+}
+
+func TestMetricsLogger(t *testing.T) {
+	l, _ := xlog.New(t.TempDir(), "info")
+	defer l.Close()
+	m, _ := NewMetrics(&MetricsConfig{})
+	m.RegisterLogger(l)
+	l.Info("one")
+	l.Warn("two")
+
+	wantLines(t, scrape(t, m),
+		"# TYPE xlog_lines_total counter",
+		`xlog_lines_total{level="debug"} 0`,
+		`xlog_lines_total{level="info"} 1`,
+		`xlog_lines_total{level="warn"} 1`,
+	)
+}
+
+func TestServerMetrics(t *testing.T) {
+	m, _ := NewMetrics(&MetricsConfig{})
+	srv, err := NewServer(&ServerConfig{Handler: m, Metrics: m})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.server
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("scrape: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	wantLines(t, string(body),
+		"http_server_open_connections 1",
+		"http_server_open_websockets 0",
+		"http_server_shutting_down 0",
+		"http_server_start_time_seconds 0", // not started with Listen
+	)
+
+	srv.state.beginShutdown()
+	wantLines(t, scrape(t, m), "http_server_shutting_down 1")
+
+	if _, err := NewServer(&ServerConfig{Handler: m, Metrics: m}); err == nil {
+		t.Fatal("expected error for a Metrics used by two servers")
+	}
+}
@@ -0,0 +1,192 @@
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default values for [OTLPExporterConfig].
+const (
+	DefaultOTLPEndpoint    = "http://localhost:4318/v1/traces"
+	DefaultOTLPServiceName = "unknown_service"
+	DefaultOTLPTimeout     = 10 * time.Second
+)
+
+// OTLPExporterConfig holds configuration options for [OTLPExporter].
+type OTLPExporterConfig struct {
+	Endpoint    string            // OTLP/HTTP traces URL. Default is "http://localhost:4318/v1/traces".
+	ServiceName string            // Reported as the service.name resource attribute. Default is "unknown_service".
+	Headers     map[string]string // Sent with each export, e.g. for authentication.
+	Timeout     time.Duration     // Max duration of each export. Default is 10 seconds.
+	Client      *http.Client      // Default is a new client.
+}
+
+// OTLPExporter is a [SpanExporter] sending spans to an OpenTelemetry collector or backend
+// with OTLP/HTTP, JSON encoded.
+type OTLPExporter struct {
+	cfg *OTLPExporterConfig
+}
+
+// NewOTLPExporter creates a new OTLPExporter with the provided configuration.
+func NewOTLPExporter(cfg *OTLPExporterConfig) (*OTLPExporter, error) {
+	copy := *cfg
+
+	if copy.Endpoint != "" {
+		u, err := url.Parse(copy.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid OTLP endpoint %q", copy.Endpoint)
+		}
+	}
+	if copy.Timeout < 0 {
+		return nil, fmt.Errorf("timeout must not be negative")
+	}
+
+	// set defaults
+
+	if copy.Endpoint == "" {
+		copy.Endpoint = DefaultOTLPEndpoint
+	}
+	if copy.ServiceName == "" {
+		copy.ServiceName = DefaultOTLPServiceName
+	}
+	if copy.Timeout == 0 {
+		copy.Timeout = DefaultOTLPTimeout
+	}
+	if copy.Client == nil {
+		copy.Client = &http.Client{}
+	}
+
+	return &OTLPExporter{cfg: &copy}, nil
+}
+
+// OTLP/JSON uses hex IDs, strings for 64-bit integers, and lowerCamelCase field names.
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` // 2 is error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func otlpAttributes(attrs map[string]any) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		var value map[string]any
+		switch v := v.(type) {
+		case string:
+			value = map[string]any{"stringValue": v}
+		case bool:
+			value = map[string]any{"boolValue": v}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": v}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprint(v)}
+		}
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: value})
+	}
+	slices.SortFunc(kvs, func(a, b otlpKeyValue) int { return strings.Compare(a.Key, b.Key) })
+	return kvs
+}
+
+// ExportSpans implements [SpanExporter], posting the spans in a single request.
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "github.com/Data-Corruption/stdx/xhttp"}}
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.SpanContext.TraceID.String(),
+			SpanID:            s.SpanContext.SpanID.String(),
+			TraceState:        s.SpanContext.TraceState,
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+		}
+		if s.Parent.IsValid() {
+			span.ParentSpanID = s.Parent.String()
+		}
+		if s.Err != "" {
+			span.Status = &otlpStatus{Code: 2, Message: s.Err}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	body, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes(map[string]any{"service.name": e.cfg.ServiceName})},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, e.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.cfg.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create export request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export %d spans: %w", len(spans), err)
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to export %d spans: %s: %s", len(spans), resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
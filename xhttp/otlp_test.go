@@ -0,0 +1,100 @@
+package xhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewOTLPExporterValidation(t *testing.T) {
+	if _, err := NewOTLPExporter(&OTLPExporterConfig{Endpoint: "localhost:4318"}); err == nil {
+		t.Fatal("expected error for endpoint without scheme")
+	}
+	e, err := NewOTLPExporter(&OTLPExporterConfig{})
+	if err != nil || e.cfg.Endpoint != DefaultOTLPEndpoint || e.cfg.ServiceName != DefaultOTLPServiceName {
+		t.Fatalf("unexpected defaults %+v, %v", e.cfg, err)
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	// a local collector stand-in
+	var got map[string]any
+	var auth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		auth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer collector.Close()
+
+	exp, _ := NewOTLPExporter(&OTLPExporterConfig{
+		Endpoint:    collector.URL + "/v1/traces",
+		ServiceName: "checkout",
+		Headers:     map[string]string{"Authorization": "Bearer token"},
+	})
+	tracer, _ := NewTracer(&TracerConfig{Exporter: exp})
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	_, child := tracer.Start(ctx, "child")
+	child.SetAttribute("db.rows", 3)
+	child.SetError(errors.New("timeout"))
+	child.End()
+	parent.End()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	if auth != "Bearer token" {
+		t.Fatalf("missing auth header, got %q", auth)
+	}
+	rs := got["resourceSpans"].([]any)[0].(map[string]any)
+	service := rs["resource"].(map[string]any)["attributes"].([]any)[0].(map[string]any)
+	if service["key"] != "service.name" || service["value"].(map[string]any)["stringValue"] != "checkout" {
+		t.Fatalf("unexpected resource %v", rs["resource"])
+	}
+	spans := rs["scopeSpans"].([]any)[0].(map[string]any)["spans"].([]any)
+	if len(spans) != 2 {
+		t.Fatalf("want 2 spans, got %d", len(spans))
+	}
+	c := spans[0].(map[string]any)
+	if c["name"] != "child" || c["traceId"] != parent.SpanContext().TraceID.String() ||
+		c["parentSpanId"] != parent.SpanContext().SpanID.String() || c["kind"] != float64(SpanKindInternal) {
+		t.Fatalf("unexpected child span %v", c)
+	}
+	attr := c["attributes"].([]any)[0].(map[string]any)
+	if attr["key"] != "db.rows" || attr["value"].(map[string]any)["intValue"] != "3" {
+		t.Fatalf("unexpected attributes %v", c["attributes"])
+	}
+	if status := c["status"].(map[string]any); status["code"] != float64(2) || status["message"] != "timeout" {
+		t.Fatalf("unexpected status %v", status)
+	}
+	if _, ok := c["startTimeUnixNano"].(string); !ok {
+		t.Fatalf("timestamps must be strings, got %T", c["startTimeUnixNano"])
+	}
+}
+
+func TestOTLPExporterError(t *testing.T) {
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer collector.Close()
+
+	exp, _ := NewOTLPExporter(&OTLPExporterConfig{Endpoint: collector.URL, Timeout: time.Second})
+	err := exp.ExportSpans(context.Background(), []SpanData{{Name: "op"}})
+	if err == nil || !strings.Contains(err.Error(), "overloaded") {
+		t.Fatalf("want error with collector message, got %v", err)
+	}
+}
@@ -0,0 +1,380 @@
+package xhttp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"unicode/utf16"
+)
+
+// PKCS#12 (RFC 7292) decoding, limited to what's needed for a server certificate: PBES2 with
+// PBKDF2 and AES, as exported by OpenSSL 3 and current OS key stores, and 3DES. Legacy RC2
+// encryption isn't supported, as it's not in the standard library.
+
+var (
+	oidData                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+	oidKeyBag              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 1}
+	oidShroudedKeyBag      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidPBEWithSHA3DES      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHARC2       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+	oidPBES2               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1        = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC           = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidSHA1                = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	errPKCS12RC2           = errors.New("pkcs12: RC2 encryption is not supported, re-export with AES, e.g. openssl pkcs12 -export without -legacy")
+	errPKCS12WrongPassword = errors.New("pkcs12: wrong password or corrupt data")
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData `asn1:"optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int `asn1:"optional,default:1"`
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// LoadPKCS12 decodes a PKCS#12 (.p12 or .pfx) bundle into a certificate for [tls.Config],
+// with the certificate matching the private key first, followed by the rest of the chain.
+func LoadPKCS12(data []byte, password string) (tls.Certificate, error) {
+	var pfx pfxPDU
+	rest, err := asn1.Unmarshal(data, &pfx)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("pkcs12: malformed bundle: %v", err)
+	}
+	if len(rest) > 0 {
+		return tls.Certificate{}, errors.New("pkcs12: trailing data after PFX")
+	}
+	if pfx.Version != 3 || !pfx.AuthSafe.ContentType.Equal(oidData) {
+		return tls.Certificate{}, errors.New("pkcs12: unsupported bundle version or content type")
+	}
+	authSafe, err := unmarshalOctets(pfx.AuthSafe.Content.Bytes)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	bmpPassword := pkcs12Password(password)
+	if len(pfx.MacData.Mac.Digest) > 0 {
+		if err := verifyPKCS12MAC(&pfx.MacData, authSafe, bmpPassword); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	var contents []contentInfo
+	if _, err := asn1.Unmarshal(authSafe, &contents); err != nil {
+		return tls.Certificate{}, fmt.Errorf("pkcs12: malformed authenticated safe: %w", err)
+	}
+	var certs []*x509.Certificate
+	var key crypto.PrivateKey
+	for _, ci := range contents {
+		var bagsDER []byte
+		switch {
+		case ci.ContentType.Equal(oidData):
+			if bagsDER, err = unmarshalOctets(ci.Content.Bytes); err != nil {
+				return tls.Certificate{}, err
+			}
+		case ci.ContentType.Equal(oidEncryptedData):
+			var ed encryptedData
+			if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+				return tls.Certificate{}, fmt.Errorf("pkcs12: malformed encrypted data: %w", err)
+			}
+			eci := ed.EncryptedContentInfo
+			if bagsDER, err = pkcs12Decrypt(eci.ContentEncryptionAlgorithm, eci.EncryptedContent, password, bmpPassword); err != nil {
+				return tls.Certificate{}, err
+			}
+		default:
+			return tls.Certificate{}, fmt.Errorf("pkcs12: unsupported content type %v", ci.ContentType)
+		}
+
+		var bags []safeBag
+		if _, err := asn1.Unmarshal(bagsDER, &bags); err != nil {
+			return tls.Certificate{}, fmt.Errorf("pkcs12: malformed safe contents: %w", err)
+		}
+		for _, bag := range bags {
+			switch {
+			case bag.ID.Equal(oidCertBag):
+				var cb certBag
+				if _, err := asn1.Unmarshal(bag.Value.Bytes, &cb); err != nil || !cb.ID.Equal(oidX509Certificate) {
+					return tls.Certificate{}, errors.New("pkcs12: malformed or non-X.509 certificate bag")
+				}
+				cert, err := x509.ParseCertificate(cb.Data)
+				if err != nil {
+					return tls.Certificate{}, fmt.Errorf("pkcs12: %w", err)
+				}
+				certs = append(certs, cert)
+			case bag.ID.Equal(oidKeyBag), bag.ID.Equal(oidShroudedKeyBag):
+				if key != nil {
+					return tls.Certificate{}, errors.New("pkcs12: bundle contains more than one private key")
+				}
+				der := bag.Value.Bytes
+				if bag.ID.Equal(oidShroudedKeyBag) {
+					var epki encryptedPrivateKeyInfo
+					if _, err := asn1.Unmarshal(der, &epki); err != nil {
+						return tls.Certificate{}, fmt.Errorf("pkcs12: malformed encrypted key: %w", err)
+					}
+					if der, err = pkcs12Decrypt(epki.Algorithm, epki.Data, password, bmpPassword); err != nil {
+						return tls.Certificate{}, err
+					}
+				}
+				if key, err = x509.ParsePKCS8PrivateKey(der); err != nil {
+					return tls.Certificate{}, fmt.Errorf("pkcs12: %w", err)
+				}
+			}
+		}
+	}
+	if key == nil || len(certs) == 0 {
+		return tls.Certificate{}, errors.New("pkcs12: bundle must contain a private key and its certificate")
+	}
+
+	// put the certificate matching the key first
+	pub, ok := key.(interface{ Public() crypto.PublicKey })
+	if !ok {
+		return tls.Certificate{}, errors.New("pkcs12: unsupported private key type")
+	}
+	leaf := -1
+	for i, c := range certs {
+		if k, ok := c.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); ok && k.Equal(pub.Public()) {
+			leaf = i
+			break
+		}
+	}
+	if leaf < 0 {
+		return tls.Certificate{}, errors.New("pkcs12: no certificate matches the private key")
+	}
+	out := tls.Certificate{PrivateKey: key, Leaf: certs[leaf], Certificate: [][]byte{certs[leaf].Raw}}
+	for i, c := range certs {
+		if i != leaf {
+			out.Certificate = append(out.Certificate, c.Raw)
+		}
+	}
+	return out, nil
+}
+
+func unmarshalOctets(der []byte) ([]byte, error) {
+	var b []byte
+	if _, err := asn1.Unmarshal(der, &b); err != nil {
+		return nil, fmt.Errorf("pkcs12: malformed data: %w", err)
+	}
+	return b, nil
+}
+
+// pkcs12Password encodes a password as a NUL-terminated BMPString (UTF-16BE), per RFC 7292 B.1.
+func pkcs12Password(password string) []byte {
+	units := utf16.Encode([]rune(password))
+	b := make([]byte, 0, 2*len(units)+2)
+	for _, u := range units {
+		b = append(b, byte(u>>8), byte(u))
+	}
+	return append(b, 0, 0)
+}
+
+// pkcs12KDF derives n bytes of key material, per RFC 7292 B.2. id is 1 for keys, 2 for IVs,
+// and 3 for MAC keys.
+func pkcs12KDF(h func() hash.Hash, password, salt []byte, iter, id, n int) []byte {
+	v := h().BlockSize()
+	fill := func(src []byte) []byte {
+		if len(src) == 0 {
+			return nil
+		}
+		out := make([]byte, v*((len(src)+v-1)/v))
+		for i := range out {
+			out[i] = src[i%len(src)]
+		}
+		return out
+	}
+	D := bytes.Repeat([]byte{byte(id)}, v)
+	I := append(fill(salt), fill(password)...)
+
+	var out []byte
+	for len(out) < n {
+		hh := h()
+		hh.Write(D)
+		hh.Write(I)
+		A := hh.Sum(nil)
+		for range iter - 1 {
+			hh.Reset()
+			hh.Write(A)
+			A = hh.Sum(A[:0])
+		}
+		out = append(out, A...)
+
+		// I_j = (I_j + B + 1) mod 2^(v*8) for each v-byte block, B being A repeated
+		B := fill(A)[:v]
+		for j := 0; j < len(I); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(I[j+k]) + int(B[k]) + carry
+				I[j+k], carry = byte(sum), sum>>8
+			}
+		}
+	}
+	return out[:n]
+}
+
+func verifyPKCS12MAC(md *macData, content, bmpPassword []byte) error {
+	var h func() hash.Hash
+	switch alg := md.Mac.Algorithm.Algorithm; {
+	case alg.Equal(oidSHA1):
+		h = sha1.New
+	case alg.Equal(oidSHA256):
+		h = sha256.New
+	default:
+		return fmt.Errorf("pkcs12: unsupported MAC algorithm %v", alg)
+	}
+	key := pkcs12KDF(h, bmpPassword, md.MacSalt, md.Iterations, 3, h().Size())
+	mac := hmac.New(h, key)
+	mac.Write(content)
+	if !hmac.Equal(mac.Sum(nil), md.Mac.Digest) {
+		return errPKCS12WrongPassword
+	}
+	return nil
+}
+
+func pkcs12Decrypt(alg pkix.AlgorithmIdentifier, data []byte, password string, bmpPassword []byte) ([]byte, error) {
+	var block cipher.Block
+	var iv []byte
+	switch {
+	case alg.Algorithm.Equal(oidPBEWithSHA3DES):
+		var p pbeParams
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &p); err != nil {
+			return nil, fmt.Errorf("pkcs12: malformed PBE parameters: %w", err)
+		}
+		key := pkcs12KDF(sha1.New, bmpPassword, p.Salt, p.Iterations, 1, 24)
+		iv = pkcs12KDF(sha1.New, bmpPassword, p.Salt, p.Iterations, 2, 8)
+		block, _ = des.NewTripleDESCipher(key)
+	case alg.Algorithm.Equal(oidPBES2):
+		var p pbes2Params
+		var kdf pbkdf2Params
+		if _, err := asn1.Unmarshal(alg.Parameters.FullBytes, &p); err != nil || !p.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+			return nil, errors.New("pkcs12: unsupported PBES2 key derivation")
+		}
+		if _, err := asn1.Unmarshal(p.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+			return nil, fmt.Errorf("pkcs12: malformed PBKDF2 parameters: %w", err)
+		}
+		prf := sha1.New
+		switch {
+		case kdf.PRF.Algorithm == nil, kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+			prf = sha256.New
+		default:
+			return nil, fmt.Errorf("pkcs12: unsupported PBKDF2 PRF %v", kdf.PRF.Algorithm)
+		}
+		var keyLen int
+		switch enc := p.EncryptionScheme.Algorithm; {
+		case enc.Equal(oidAES128CBC):
+			keyLen = 16
+		case enc.Equal(oidAES192CBC):
+			keyLen = 24
+		case enc.Equal(oidAES256CBC):
+			keyLen = 32
+		default:
+			return nil, fmt.Errorf("pkcs12: unsupported PBES2 encryption %v", enc)
+		}
+		if _, err := asn1.Unmarshal(p.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+			return nil, errors.New("pkcs12: malformed AES IV")
+		}
+		key, err := pbkdf2.Key(prf, password, kdf.Salt, kdf.Iterations, keyLen)
+		if err != nil {
+			return nil, fmt.Errorf("pkcs12: %w", err)
+		}
+		block, _ = aes.NewCipher(key)
+	case alg.Algorithm.Equal(oidPBEWithSHARC2):
+		return nil, errPKCS12RC2
+	default:
+		return nil, fmt.Errorf("pkcs12: unsupported encryption %v", alg.Algorithm)
+	}
+
+	bs := block.BlockSize()
+	if len(data) == 0 || len(data)%bs != 0 {
+		return nil, errPKCS12WrongPassword
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > bs || !bytes.Equal(out[len(out)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errPKCS12WrongPassword
+	}
+	return out[:len(out)-pad], nil
+}
@@ -0,0 +1,49 @@
+package xhttp
+
+import (
+	"crypto/x509"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadPKCS12(t *testing.T) {
+	ca, _ := os.ReadFile("testdata/ca.pem")
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca)
+
+	for _, name := range []string{"aes.p12", "3des.p12"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile("testdata/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cert, err := LoadPKCS12(data, "secret")
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if len(cert.Certificate) != 2 || cert.Leaf == nil || cert.Leaf.Subject.CommonName != "localhost" {
+				t.Fatalf("want leaf then CA, got %d certs, leaf %v", len(cert.Certificate), cert.Leaf)
+			}
+			if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots}); err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if _, err := LoadPKCS12(data, "wrong"); !errors.Is(err, errPKCS12WrongPassword) {
+				t.Fatalf("want wrong password error, got %v", err)
+			}
+		})
+	}
+
+	data, _ := os.ReadFile("testdata/rc2.p12")
+	if _, err := LoadPKCS12(data, "secret"); !errors.Is(err, errPKCS12RC2) {
+		t.Fatalf("want RC2 error, got %v", err)
+	}
+	if _, err := LoadPKCS12([]byte("not a bundle"), ""); err == nil {
+		t.Fatal("expected error for malformed data")
+	}
+	data, _ = os.ReadFile("testdata/aes.p12")
+	if _, err := LoadPKCS12(append(data, 0), "secret"); err == nil || !strings.Contains(err.Error(), "trailing data") {
+		t.Fatalf("want trailing data error, got %v", err)
+	}
+}
@@ -0,0 +1,392 @@
+package xhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default values for [ProxyConfig].
+const (
+	DefaultProxyHealthPath     = "/healthz"
+	DefaultProxyHealthInterval = 10 * time.Second
+	DefaultProxyHealthTimeout  = 2 * time.Second
+	DefaultProxyMaxFails       = 3
+	DefaultProxyEjectTimeout   = 30 * time.Second
+	DefaultProxyTimeout        = 30 * time.Second
+)
+
+// ProxyBalance is a load balancing strategy for [Proxy].
+type ProxyBalance string
+
+const (
+	ProxyRoundRobin ProxyBalance = "round-robin"       // Take turns. The default.
+	ProxyLeastConns ProxyBalance = "least-connections" // Pick the upstream with the fewest requests in flight, for uneven request costs.
+)
+
+// ProxyConfig holds configuration options for [Proxy].
+type ProxyConfig struct {
+	// Upstreams are the base URLs requests are proxied to, e.g. "http://10.0.0.2:8080", or
+	// "unix:///run/legacy.sock" for a Unix socket. Required.
+	Upstreams []string
+
+	Balance ProxyBalance // Load balancing strategy. Default is ProxyRoundRobin.
+
+	// Active health checks GET HealthPath on every upstream each HealthInterval, a 2xx or 3xx
+	// response being healthy. Default is "/healthz" every 10 seconds. Negative to disable.
+	HealthPath     string
+	HealthInterval time.Duration
+	HealthTimeout  time.Duration // Max duration of a health check. Default is 2 seconds.
+
+	// MaxFails is the number of consecutive failed requests or health checks ejecting an
+	// upstream from the pool. Default is 3.
+	MaxFails int
+
+	// EjectTimeout is how long an ejected upstream is left out, unless a health check passes
+	// first. Default is 30 seconds.
+	EjectTimeout time.Duration
+
+	// Timeout is the max duration to wait for upstream response headers, after which the client
+	// gets a 504. Default is 30 seconds. Negative to disable.
+	Timeout time.Duration
+
+	// Transport is cloned for each upstream. Default is [http.DefaultTransport].
+	Transport *http.Transport
+
+	// Rewrite, if non-nil, modifies outgoing requests after the URL and X-Forwarded headers are
+	// set, e.g. to keep the inbound Host with pr.Out.Host = pr.In.Host.
+	Rewrite func(pr *httputil.ProxyRequest)
+
+	// ModifyResponse, if non-nil, modifies upstream responses, see [httputil.ReverseProxy].
+	// Errors it returns are sent as a 502 [Err], without counting as upstream failures.
+	ModifyResponse func(*http.Response) error
+
+	// ErrorHandler sends the 502 and 504 [Err]s. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// Proxy is a reverse proxy [http.Handler] built on [httputil.ReverseProxy], balancing requests
+// across a pool of upstreams.
+//
+// Upstreams failing MaxFails requests (connection errors and timeouts) or health checks in a
+// row are ejected for EjectTimeout, or until a health check passes. When all are ejected, all
+// are tried, as some chance of success beats none. Requests aren't retried on another upstream,
+// as their bodies are streamed. Failures are sent as a 502 [Err], or a 504 on timeouts.
+// Outgoing requests carry X-Forwarded-* headers and the trace context of the request span.
+//
+// Usage:
+//
+//	proxy, _ := xhttp.NewProxy(&xhttp.ProxyConfig{
+//		Upstreams: []string{"http://10.0.0.2:8080", "http://10.0.0.3:8080"},
+//		Balance:   xhttp.ProxyLeastConns,
+//	})
+//	defer proxy.Close()
+//	mux.Handle("/legacy/", http.StripPrefix("/legacy", proxy))
+type Proxy struct {
+	cfg       *ProxyConfig
+	upstreams []*upstream
+	rp        *httputil.ReverseProxy
+	next      atomic.Uint64
+	stop      chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+	now       func() time.Time
+}
+
+type upstream struct {
+	raw       string
+	target    *url.URL
+	transport *http.Transport
+	active    atomic.Int64
+	fails     atomic.Int64
+	ejected   atomic.Int64 // unix nanos until which it's ejected, zero if not
+}
+
+// ProxyUpstream is the state of a [Proxy] upstream, see [Proxy.Upstreams].
+type ProxyUpstream struct {
+	URL     string
+	Healthy bool // Not ejected.
+	Active  int  // Requests in flight.
+	Fails   int  // Consecutive failures.
+}
+
+type proxyUpstreamKey struct{}
+
+// NewProxy creates a new Proxy with the provided configuration, starting health checks.
+func NewProxy(cfg *ProxyConfig) (*Proxy, error) {
+	copy := *cfg
+
+	if len(copy.Upstreams) == 0 {
+		return nil, fmt.Errorf("at least one upstream must be provided")
+	}
+	if copy.MaxFails < 0 || copy.EjectTimeout < 0 || copy.HealthTimeout < 0 {
+		return nil, fmt.Errorf("max fails, eject timeout, and health timeout must not be negative")
+	}
+
+	// set defaults
+
+	switch copy.Balance {
+	case "":
+		copy.Balance = ProxyRoundRobin
+	case ProxyRoundRobin, ProxyLeastConns:
+	default:
+		return nil, fmt.Errorf("unknown proxy balance %q", copy.Balance)
+	}
+	if copy.HealthPath == "" {
+		copy.HealthPath = DefaultProxyHealthPath
+	}
+	if copy.HealthInterval == 0 {
+		copy.HealthInterval = DefaultProxyHealthInterval
+	}
+	if copy.HealthTimeout == 0 {
+		copy.HealthTimeout = DefaultProxyHealthTimeout
+	}
+	if copy.MaxFails == 0 {
+		copy.MaxFails = DefaultProxyMaxFails
+	}
+	if copy.EjectTimeout == 0 {
+		copy.EjectTimeout = DefaultProxyEjectTimeout
+	}
+	if copy.Timeout == 0 {
+		copy.Timeout = DefaultProxyTimeout
+	}
+	if copy.Transport == nil {
+		t, ok := http.DefaultTransport.(*http.Transport)
+		if !ok {
+			return nil, fmt.Errorf("http.DefaultTransport is a %T, set a transport", http.DefaultTransport)
+		}
+		copy.Transport = t
+	}
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	p := &Proxy{cfg: &copy, stop: make(chan struct{}), now: time.Now}
+	for _, raw := range copy.Upstreams {
+		u, err := newUpstream(raw, copy.Transport)
+		if err != nil {
+			return nil, err
+		}
+		if copy.Timeout > 0 {
+			u.transport.ResponseHeaderTimeout = copy.Timeout
+		}
+		p.upstreams = append(p.upstreams, u)
+	}
+	p.rp = &httputil.ReverseProxy{
+		Rewrite:        p.rewrite,
+		Transport:      proxyTransport{},
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler:   p.errorHandler,
+	}
+
+	if copy.HealthInterval > 0 {
+		p.wg.Add(1)
+		go p.healthLoop()
+	}
+	return p, nil
+}
+
+func newUpstream(raw string, base *http.Transport) (*upstream, error) {
+	target, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", raw, err)
+	}
+	u := &upstream{raw: raw, transport: base.Clone()}
+	switch target.Scheme {
+	case "http", "https":
+		if target.Host == "" {
+			return nil, fmt.Errorf("invalid upstream %q: missing host", raw)
+		}
+		u.target = target
+	case "unix":
+		if target.Path == "" {
+			return nil, fmt.Errorf("invalid upstream %q: missing socket path", raw)
+		}
+		sock := target.Path
+		u.transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		}
+		u.target = &url.URL{Scheme: "http", Host: "localhost"}
+	default:
+		return nil, fmt.Errorf("invalid upstream %q: scheme must be http, https, or unix", raw)
+	}
+	return u, nil
+}
+
+// ServeHTTP implements [http.Handler].
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := p.pick()
+	u.active.Add(1)
+	defer u.active.Add(-1)
+	p.rp.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), proxyUpstreamKey{}, u)))
+}
+
+// pick returns the upstream for the next request.
+func (p *Proxy) pick() *upstream {
+	now := p.now().UnixNano()
+	pool := make([]*upstream, 0, len(p.upstreams))
+	for _, u := range p.upstreams {
+		if u.ejected.Load() <= now {
+			pool = append(pool, u)
+		}
+	}
+	if len(pool) == 0 {
+		pool = p.upstreams // all ejected, try them all
+	}
+
+	start := int(p.next.Add(1) % uint64(len(pool)))
+	if p.cfg.Balance == ProxyRoundRobin {
+		return pool[start]
+	}
+	// least connections, starting at the round-robin position to spread ties
+	best := pool[start]
+	for i := 1; i < len(pool); i++ {
+		if u := pool[(start+i)%len(pool)]; u.active.Load() < best.active.Load() {
+			best = u
+		}
+	}
+	return best
+}
+
+func (p *Proxy) rewrite(pr *httputil.ProxyRequest) {
+	u := pr.In.Context().Value(proxyUpstreamKey{}).(*upstream)
+	pr.SetURL(u.target)
+	pr.SetXForwarded()
+	InjectTraceContext(pr.In.Context(), pr.Out.Header)
+	if p.cfg.Rewrite != nil {
+		p.cfg.Rewrite(pr)
+	}
+}
+
+// proxyTransport sends requests with the transport of the upstream picked for them.
+type proxyTransport struct{}
+
+func (proxyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return r.Context().Value(proxyUpstreamKey{}).(*upstream).transport.RoundTrip(r)
+}
+
+func (p *Proxy) modifyResponse(resp *http.Response) error {
+	resp.Request.Context().Value(proxyUpstreamKey{}).(*upstream).fails.Store(0)
+	if p.cfg.ModifyResponse != nil {
+		if err := p.cfg.ModifyResponse(resp); err != nil {
+			return &modifyResponseError{err}
+		}
+	}
+	return nil
+}
+
+// modifyResponseError marks errors of [ProxyConfig.ModifyResponse], which aren't upstream
+// failures.
+type modifyResponseError struct{ err error }
+
+func (e *modifyResponseError) Error() string { return e.err.Error() }
+func (e *modifyResponseError) Unwrap() error { return e.err }
+
+func (p *Proxy) errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		return // the client went away, there's no one to respond to
+	}
+	u := r.Context().Value(proxyUpstreamKey{}).(*upstream)
+	e := &Err{Code: http.StatusBadGateway, Msg: "Bad gateway", Err: fmt.Errorf("proxy %s: %w", u.raw, err)}
+	var ne net.Error
+	var me *modifyResponseError
+	switch {
+	case errors.As(err, &me):
+		// the upstream responded, the response was rejected
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &ne) && ne.Timeout()):
+		e.Code, e.Msg = http.StatusGatewayTimeout, "Gateway timeout"
+		p.fail(u)
+	default:
+		p.fail(u)
+	}
+	p.cfg.ErrorHandler(r.Context(), w, e)
+}
+
+// fail records a failure of u, ejecting it after MaxFails in a row.
+func (p *Proxy) fail(u *upstream) {
+	if u.fails.Add(1) >= int64(p.cfg.MaxFails) {
+		u.ejected.Store(p.now().Add(p.cfg.EjectTimeout).UnixNano())
+	}
+}
+
+func (p *Proxy) healthLoop() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.cfg.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.checkHealth()
+		}
+	}
+}
+
+// checkHealth checks all upstreams concurrently, waiting for the results.
+func (p *Proxy) checkHealth() {
+	var wg sync.WaitGroup
+	for _, u := range p.upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if p.healthy(u) {
+				u.fails.Store(0)
+				u.ejected.Store(0)
+			} else {
+				p.fail(u)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (p *Proxy) healthy(u *upstream) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.HealthTimeout)
+	defer cancel()
+	target := u.target.JoinPath(p.cfg.HealthPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := u.transport.RoundTrip(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < 400
+}
+
+// Upstreams returns the state of each upstream, in configuration order.
+func (p *Proxy) Upstreams() []ProxyUpstream {
+	now := p.now().UnixNano()
+	out := make([]ProxyUpstream, len(p.upstreams))
+	for i, u := range p.upstreams {
+		out[i] = ProxyUpstream{
+			URL:     u.raw,
+			Healthy: u.ejected.Load() <= now,
+			Active:  int(u.active.Load()),
+			Fails:   int(u.fails.Load()),
+		}
+	}
+	return out
+}
+
+// Close stops health checks and closes idle upstream connections. In-flight requests are
+// left to finish.
+func (p *Proxy) Close() error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	p.wg.Wait()
+	for _, u := range p.upstreams {
+		u.transport.CloseIdleConnections()
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package xhttp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// nameServer returns an upstream answering with its name, blocking on /slow until release is closed.
+func nameServer(t *testing.T, name string, release chan struct{}) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		io.WriteString(w, name+" "+r.Header.Get("X-Forwarded-For"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func proxyGet(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+	return rec
+}
+
+func TestProxyRoundRobin(t *testing.T) {
+	a, b := nameServer(t, "a", nil), nameServer(t, "b", nil)
+	proxy, err := NewProxy(&ProxyConfig{Upstreams: []string{a.URL, b.URL}, HealthInterval: -1})
+	if err != nil {
+		t.Fatalf("new proxy: %v", err)
+	}
+	defer proxy.Close()
+
+	var got []string
+	for range 4 {
+		rec := proxyGet(t, proxy, "/")
+		got = append(got, strings.Fields(rec.Body.String())[0])
+		if !strings.HasSuffix(rec.Body.String(), "192.0.2.1") {
+			t.Fatalf("want X-Forwarded-For set, got %q", rec.Body)
+		}
+	}
+	if strings.Join(got, "") != "baba" && strings.Join(got, "") != "abab" {
+		t.Fatalf("want alternating upstreams, got %v", got)
+	}
+}
+
+func TestProxyLeastConns(t *testing.T) {
+	release := make(chan struct{})
+	a, b := nameServer(t, "a", release), nameServer(t, "b", release)
+	proxy, _ := NewProxy(&ProxyConfig{Upstreams: []string{a.URL, b.URL}, Balance: ProxyLeastConns, HealthInterval: -1})
+	defer proxy.Close()
+
+	slow := make(chan string)
+	go func() { slow <- strings.Fields(proxyGet(t, proxy, "/slow").Body.String())[0] }()
+	for deadline := time.Now().Add(2 * time.Second); proxy.Upstreams()[0].Active+proxy.Upstreams()[1].Active == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("slow request not in flight")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	var fast []string
+	for range 3 {
+		fast = append(fast, strings.Fields(proxyGet(t, proxy, "/").Body.String())[0])
+	}
+	close(release)
+	busy := <-slow
+	for _, f := range fast {
+		if f == busy {
+			t.Fatalf("want requests sent to the idle upstream, got %v with %s busy", fast, busy)
+		}
+	}
+}
+
+func TestProxyErrors(t *testing.T) {
+	good := nameServer(t, "good", nil)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close() // connections refused
+
+	proxy, _ := NewProxy(&ProxyConfig{Upstreams: []string{down.URL, good.URL}, MaxFails: 1, HealthInterval: -1})
+	defer proxy.Close()
+	codes := map[int]int{}
+	for range 4 {
+		codes[proxyGet(t, proxy, "/").Code]++
+	}
+	if codes[http.StatusBadGateway] != 1 || codes[http.StatusOK] != 3 {
+		t.Fatalf("want one 502 then the upstream ejected, got %v", codes)
+	}
+	if st := proxy.Upstreams(); st[0].Healthy || !st[1].Healthy || st[0].Fails != 1 {
+		t.Fatalf("unexpected upstream state %+v", st)
+	}
+
+	// all ejected, all tried
+	proxy.upstreams[1].ejected.Store(time.Now().Add(time.Minute).UnixNano())
+	if rec := proxyGet(t, proxy, "/"); rec.Code != http.StatusOK && rec.Code != http.StatusBadGateway {
+		t.Fatalf("want an attempt with all upstreams ejected, got %d", rec.Code)
+	}
+
+	// timeouts
+	release := make(chan struct{})
+	defer close(release)
+	slow := nameServer(t, "slow", release)
+	timeout, _ := NewProxy(&ProxyConfig{Upstreams: []string{slow.URL}, Timeout: 50 * time.Millisecond, HealthInterval: -1})
+	defer timeout.Close()
+	if rec := proxyGet(t, timeout, "/slow"); rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("want 504, got %d", rec.Code)
+	}
+}
+
+func TestProxyErrorsNotCounted(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	up := nameServer(t, "up", release)
+	proxy, _ := NewProxy(&ProxyConfig{
+		Upstreams:      []string{up.URL},
+		MaxFails:       1,
+		HealthInterval: -1,
+		ModifyResponse: func(*http.Response) error { return errors.New("rejected") },
+	})
+	defer proxy.Close()
+
+	// rejected by ModifyResponse
+	if rec := proxyGet(t, proxy, "/"); rec.Code != http.StatusBadGateway {
+		t.Fatalf("want 502, got %d", rec.Code)
+	}
+	// canceled by the client
+	ctx, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/slow", nil).WithContext(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	rec := httptest.NewRecorder()
+	proxy.ServeHTTP(rec, r)
+	if rec.Body.Len() != 0 {
+		t.Fatalf("want nothing sent to a gone client, got %q", rec.Body)
+	}
+	if st := proxy.Upstreams()[0]; !st.Healthy || st.Fails != 0 {
+		t.Fatalf("want upstream not blamed, got %+v", st)
+	}
+}
+
+func TestNewProxyCustomDefaultTransport(t *testing.T) {
+	orig := http.DefaultTransport
+	defer func() { http.DefaultTransport = orig }()
+	http.DefaultTransport = roundTripperFunc(func(r *http.Request) (*http.Response, error) { return nil, errors.New("unused") })
+	if _, err := NewProxy(&ProxyConfig{Upstreams: []string{"http://host"}}); err == nil {
+		t.Fatal("expected error for a non *http.Transport DefaultTransport")
+	}
+	p, err := NewProxy(&ProxyConfig{Upstreams: []string{"http://host"}, Transport: &http.Transport{}, HealthInterval: -1})
+	if err != nil {
+		t.Fatalf("want explicit transport used, got %v", err)
+	}
+	p.Close()
+}
+
+func TestProxyHealthChecks(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" && !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer up.Close()
+	other := nameServer(t, "other", nil)
+
+	proxy, _ := NewProxy(&ProxyConfig{Upstreams: []string{up.URL, other.URL}, HealthInterval: 10 * time.Millisecond, MaxFails: 2})
+	defer proxy.Close()
+	waitHealthy := func(want bool) {
+		t.Helper()
+		for deadline := time.Now().Add(2 * time.Second); proxy.Upstreams()[0].Healthy != want; {
+			if time.Now().After(deadline) {
+				t.Fatalf("want healthy %v, got %+v", want, proxy.Upstreams())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	healthy.Store(false)
+	waitHealthy(false)
+	for range 3 {
+		if body := proxyGet(t, proxy, "/").Body.String(); !strings.HasPrefix(body, "other") {
+			t.Fatalf("want ejected upstream skipped, got %q", body)
+		}
+	}
+	healthy.Store(true)
+	waitHealthy(true)
+}
+
+func TestProxyUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "legacy.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "unix "+r.URL.Path)
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	proxy, err := NewProxy(&ProxyConfig{Upstreams: []string{"unix://" + sock}, HealthInterval: -1})
+	if err != nil {
+		t.Fatalf("new proxy: %v", err)
+	}
+	defer proxy.Close()
+	if rec := proxyGet(t, proxy, "/a/b"); rec.Code != http.StatusOK || rec.Body.String() != "unix /a/b" {
+		t.Fatalf("unexpected response %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestNewProxyValidation(t *testing.T) {
+	for _, cfg := range []ProxyConfig{
+		{},
+		{Upstreams: []string{"ftp://host"}},
+		{Upstreams: []string{"http://"}},
+		{Upstreams: []string{"unix://"}},
+		{Upstreams: []string{"http://host"}, Balance: "random"},
+		{Upstreams: []string{"http://host"}, MaxFails: -1},
+	} {
+		if _, err := NewProxy(&cfg); err == nil {
+			t.Fatalf("expected error for %+v", cfg)
+		}
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
@@ -0,0 +1,202 @@
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default values for [RateLimitConfig].
+const (
+	DefaultRateLimitPeriod = time.Second
+	DefaultRateLimitSweep  = time.Minute
+)
+
+// RateLimitConfig holds configuration options for [RateLimiter].
+type RateLimitConfig struct {
+	// Limit is the number of requests allowed per Period, per key. Required.
+	Limit  int
+	Period time.Duration // Period over which Limit requests are allowed. Default is 1 second.
+	Burst  int           // Max requests allowed at once, the token bucket size. Default is Limit.
+
+	// KeyFunc returns the key requests are limited by. Default is [KeyByIP].
+	// Requests for which it returns an empty string are not limited.
+	KeyFunc func(r *http.Request) string
+
+	// SweepInterval is how often idle keys are removed from memory. Sweeps happen lazily during
+	// requests, so no background goroutine is needed. Default is 1 minute.
+	SweepInterval time.Duration
+
+	// ErrorHandler sends the 429 [Err] for limited requests. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// RateLimiter is an in-memory, per-key token bucket rate limiter.
+//
+// Each key gets a bucket of Burst tokens that refills at Limit per Period. A request
+// takes one token, or is rejected with a 429 [Err] and a Retry-After header when the
+// bucket is empty. RateLimit-Limit, RateLimit-Remaining, and RateLimit-Reset headers
+// are sent on every limited response.
+type RateLimiter struct {
+	cfg       *RateLimitConfig
+	rate      float64 // tokens per second
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitResult is the outcome of taking a token from a bucket.
+type rateLimitResult struct {
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration // time until a token is available, zero if allowed
+	reset      time.Duration // time until the bucket is full again
+}
+
+// NewRateLimiter creates a new RateLimiter with the provided configuration.
+func NewRateLimiter(cfg *RateLimitConfig) (*RateLimiter, error) {
+	copy := *cfg
+
+	if copy.Limit <= 0 {
+		return nil, fmt.Errorf("rate limit must be greater than zero")
+	}
+
+	// set defaults
+
+	if copy.Period <= 0 {
+		copy.Period = DefaultRateLimitPeriod
+	}
+	if copy.Burst <= 0 {
+		copy.Burst = copy.Limit
+	}
+	if copy.KeyFunc == nil {
+		copy.KeyFunc = KeyByIP
+	}
+	if copy.SweepInterval <= 0 {
+		copy.SweepInterval = DefaultRateLimitSweep
+	}
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	return &RateLimiter{
+		cfg:     &copy,
+		rate:    float64(copy.Limit) / copy.Period.Seconds(),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}, nil
+}
+
+// Allow takes a token for key, reporting whether the request is allowed.
+func (rl *RateLimiter) Allow(key string) bool {
+	return rl.take(key).allowed
+}
+
+// Middleware limits requests to next by the configured key.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := rl.cfg.KeyFunc(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		res := rl.take(key)
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(rl.cfg.Burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.reset)))
+
+		if !res.allowed {
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.retryAfter)))
+			rl.cfg.ErrorHandler(r.Context(), w, &Err{
+				Code: http.StatusTooManyRequests,
+				Msg:  "Too many requests",
+				Err:  fmt.Errorf("rate limit exceeded for %q", key),
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (rl *RateLimiter) take(key string) rateLimitResult {
+	now := rl.now()
+	burst := float64(rl.cfg.Burst)
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.sweep(now)
+
+	b, ok := rl.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rl.rate)
+	b.last = now
+
+	res := rateLimitResult{}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.allowed = true
+	} else {
+		res.retryAfter = secondsDuration((1 - b.tokens) / rl.rate)
+	}
+	res.remaining = int(b.tokens)
+	res.reset = secondsDuration((burst - b.tokens) / rl.rate)
+	return res
+}
+
+// sweep removes buckets that have been idle long enough to be full again,
+// which are equivalent to a fresh bucket. Assumes mutex is held by caller.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < rl.cfg.SweepInterval {
+		return
+	}
+	rl.lastSweep = now
+	full := secondsDuration(float64(rl.cfg.Burst) / rl.rate)
+	for k, b := range rl.buckets {
+		if now.Sub(b.last) >= full {
+			delete(rl.buckets, k)
+		}
+	}
+}
+
+func secondsDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// ceilSeconds rounds d up to whole seconds, as used by Retry-After style headers.
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// KeyByIP returns [ClientIP] of r, for use as a [RateLimitConfig.KeyFunc]. Behind a reverse
+// proxy, add [RealIP.Middleware] before the limiter so clients aren't all keyed as the proxy.
+func KeyByIP(r *http.Request) string {
+	return ClientIP(r)
+}
+
+// KeyByHeader returns a [RateLimitConfig.KeyFunc] that limits by the value of the named
+// header, e.g. an API key. Requests without the header are not limited, use a custom
+// KeyFunc falling back to [KeyByIP] if that matters.
+func KeyByHeader(name string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
@@ -0,0 +1,101 @@
+package xhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiterValidation(t *testing.T) {
+	if _, err := NewRateLimiter(&RateLimitConfig{}); err == nil {
+		t.Fatal("expected error when Limit is zero")
+	}
+}
+
+func TestRateLimiterAllowAndRefill(t *testing.T) {
+	rl, err := NewRateLimiter(&RateLimitConfig{Limit: 2, Period: time.Second})
+	if err != nil {
+		t.Fatalf("new rate limiter: %v", err)
+	}
+	now := time.Unix(0, 0)
+	rl.now = func() time.Time { return now }
+
+	if !rl.Allow("a") || !rl.Allow("a") {
+		t.Fatal("first two requests should be allowed")
+	}
+	if rl.Allow("a") {
+		t.Fatal("third request should be limited")
+	}
+	if !rl.Allow("b") {
+		t.Fatal("other keys should have their own bucket")
+	}
+
+	now = now.Add(500 * time.Millisecond) // refills one token
+	if !rl.Allow("a") {
+		t.Fatal("request after refill should be allowed")
+	}
+	if rl.Allow("a") {
+		t.Fatal("bucket should be empty again")
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	rl, _ := NewRateLimiter(&RateLimitConfig{Limit: 1, SweepInterval: time.Second})
+	now := time.Unix(100, 0)
+	rl.now = func() time.Time { return now }
+
+	rl.Allow("a")
+	now = now.Add(2 * time.Second)
+	rl.Allow("b")
+	if _, ok := rl.buckets["a"]; ok {
+		t.Fatal("idle bucket should have been swept")
+	}
+	if len(rl.buckets) != 1 {
+		t.Fatalf("want 1 bucket, got %d", len(rl.buckets))
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	rl, _ := NewRateLimiter(&RateLimitConfig{Limit: 1, Period: 10 * time.Second})
+	now := time.Unix(0, 0)
+	rl.now = func() time.Time { return now }
+	h := rl.Middleware(noopHandler())
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "0" {
+		t.Fatalf("want RateLimit-Remaining 0, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("want 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "10" {
+		t.Fatalf("want Retry-After 10, got %q", got)
+	}
+	if got := rec.Header().Get("RateLimit-Limit"); got != "1" {
+		t.Fatalf("want RateLimit-Limit 1, got %q", got)
+	}
+}
+
+func TestRateLimiterEmptyKeyNotLimited(t *testing.T) {
+	rl, _ := NewRateLimiter(&RateLimitConfig{Limit: 1, KeyFunc: KeyByHeader("X-API-Key")})
+	h := rl.Middleware(noopHandler())
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: want 200, got %d", i, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Headers consulted by [RealIP], in the default order.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-IP"
+)
+
+// RealIPConfig holds configuration options for [RealIP].
+type RealIPConfig struct {
+	// TrustedProxies lists the CIDRs (e.g. "10.0.0.0/8") or single IPs of proxies whose
+	// forwarding headers are believed. Requests from any other address resolve to that
+	// address, so spoofed headers from clients are ignored. Required.
+	TrustedProxies []string
+
+	// Headers to read the forwarded chain from, the first one present wins.
+	// Default is Forwarded, X-Forwarded-For, X-Real-IP.
+	Headers []string
+}
+
+// RealIP resolves the real client address of requests arriving through trusted reverse
+// proxies such as Caddy or nginx, using the RFC 7239 Forwarded, X-Forwarded-For, or
+// X-Real-IP headers.
+//
+// The forwarded chain is walked from the nearest hop outward, skipping trusted proxies,
+// and the first untrusted address is the client. Values that fail to parse stop the walk,
+// resolving to the last trusted hop rather than trusting garbage.
+type RealIP struct {
+	cfg     *RealIPConfig
+	trusted []netip.Prefix
+}
+
+type clientIPKey struct{}
+
+// NewRealIP creates a new RealIP with the provided configuration.
+func NewRealIP(cfg *RealIPConfig) (*RealIP, error) {
+	copy := *cfg
+
+	if len(copy.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("at least one trusted proxy must be provided")
+	}
+
+	var trusted []netip.Prefix
+	for _, s := range copy.TrustedProxies {
+		if strings.Contains(s, "/") {
+			p, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+			}
+			trusted = append(trusted, p.Masked())
+			continue
+		}
+		a, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", s, err)
+		}
+		a = a.Unmap()
+		trusted = append(trusted, netip.PrefixFrom(a, a.BitLen()))
+	}
+
+	// set defaults
+
+	if len(copy.Headers) == 0 {
+		copy.Headers = []string{HeaderForwarded, HeaderXForwardedFor, HeaderXRealIP}
+	}
+
+	return &RealIP{cfg: &copy, trusted: trusted}, nil
+}
+
+// Middleware resolves the client address and stores it in the request context for [ClientIP].
+// r.RemoteAddr is left untouched.
+func (ri *RealIP) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, ri.Resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Resolve returns the real client address of r.
+func (ri *RealIP) Resolve(r *http.Request) string {
+	remote := remoteHost(r)
+	addr, err := netip.ParseAddr(remote)
+	if err != nil || !ri.isTrusted(addr.Unmap()) {
+		return remote
+	}
+
+	for _, name := range ri.cfg.Headers {
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		var chain []string
+		switch http.CanonicalHeaderKey(name) {
+		case HeaderForwarded:
+			chain = parseForwarded(values)
+		case http.CanonicalHeaderKey(HeaderXRealIP): // "X-Real-Ip"
+			chain = []string{strings.TrimSpace(values[len(values)-1])}
+		default:
+			for _, v := range values {
+				for _, part := range strings.Split(v, ",") {
+					chain = append(chain, strings.TrimSpace(part))
+				}
+			}
+		}
+		return ri.walk(chain, remote)
+	}
+	return remote
+}
+
+// walk returns the first untrusted address in chain, starting at the nearest hop.
+// last is the address of the hop that sent the chain.
+func (ri *RealIP) walk(chain []string, last string) string {
+	for i := len(chain) - 1; i >= 0; i-- {
+		addr, err := parseHostAddr(chain[i])
+		if err != nil {
+			return last
+		}
+		last = addr.String()
+		if !ri.isTrusted(addr) {
+			return last
+		}
+	}
+	return last // every hop was trusted, the furthest one is the best we have
+}
+
+func (ri *RealIP) isTrusted(addr netip.Addr) bool {
+	for _, p := range ri.trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client address stored by [RealIP.Middleware], or the host part of
+// r.RemoteAddr if the middleware is not in use.
+func ClientIP(r *http.Request) string {
+	if ip := clientIPFromContext(r.Context()); ip != "" {
+		return ip
+	}
+	return remoteHost(r)
+}
+
+func clientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// parseHostAddr parses an address that may have a port or IPv6 brackets,
+// e.g. "192.0.2.1", "192.0.2.1:80", "2001:db8::1", or "[2001:db8::1]:80".
+func parseHostAddr(s string) (netip.Addr, error) {
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), nil
+	}
+	a, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(s, "["), "]"))
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return a.Unmap(), nil
+}
+
+// parseForwarded returns the for= values of RFC 7239 Forwarded header values, in order.
+// Elements without a for= parameter are returned as empty strings so they break the chain.
+func parseForwarded(values []string) []string {
+	var chain []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			var forVal string
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					forVal = strings.Trim(val, `"`)
+				}
+			}
+			chain = append(chain, forVal)
+		}
+	}
+	return chain
+}
@@ -0,0 +1,87 @@
+package xhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewRealIPValidation(t *testing.T) {
+	if _, err := NewRealIP(&RealIPConfig{}); err == nil {
+		t.Fatal("expected error without trusted proxies")
+	}
+	if _, err := NewRealIP(&RealIPConfig{TrustedProxies: []string{"not-an-ip"}}); err == nil {
+		t.Fatal("expected error for invalid trusted proxy")
+	}
+}
+
+func TestRealIPResolve(t *testing.T) {
+	ri, err := NewRealIP(&RealIPConfig{TrustedProxies: []string{"10.0.0.0/8", "::1"}})
+	if err != nil {
+		t.Fatalf("new real ip: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		header http.Header
+		want   string
+	}{
+		{"untrusted remote ignores headers", "203.0.113.9:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "203.0.113.9"},
+		{"no headers", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"xff single", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.1"},
+		{"xff skips trusted hops", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1, 10.0.0.7"}}, "198.51.100.1"},
+		{"xff spoofed prefix ignored", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.2.3.4, 198.51.100.1"}}, "198.51.100.1"},
+		{"xff multiple headers", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"1.2.3.4", "198.51.100.1, 10.1.1.1"}}, "198.51.100.1"},
+		{"xff garbage stops walk", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"198.51.100.1, bogus, 10.0.0.7"}}, "10.0.0.7"},
+		{"xff all trusted", "10.0.0.1:1234", http.Header{"X-Forwarded-For": {"10.0.0.5, 10.0.0.7"}}, "10.0.0.5"},
+		{"x-real-ip", "[::1]:1234", http.Header{"X-Real-Ip": {"198.51.100.2"}}, "198.51.100.2"},
+		{"x-real-ip last value wins", "[::1]:1234", http.Header{"X-Real-Ip": {"198.51.100.2", "10.0.0.7"}}, "10.0.0.7"},
+		{"forwarded", "10.0.0.1:1234", http.Header{"Forwarded": {`for=198.51.100.3;proto=https, for="10.0.0.7:80"`}}, "198.51.100.3"},
+		{"forwarded ipv6", "10.0.0.1:1234", http.Header{"Forwarded": {`For="[2001:db8::1]:4711"`}}, "2001:db8::1"},
+		{"forwarded unknown", "10.0.0.1:1234", http.Header{"Forwarded": {`for=unknown`}}, "10.0.0.1"},
+		{"forwarded preferred", "10.0.0.1:1234", http.Header{"Forwarded": {"for=198.51.100.3"}, "X-Forwarded-For": {"198.51.100.1"}}, "198.51.100.3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			r.Header = tt.header
+			if r.Header == nil {
+				r.Header = http.Header{}
+			}
+			if got := ri.Resolve(r); got != tt.want {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRealIPMiddlewareAndClientIP(t *testing.T) {
+	ri, _ := NewRealIP(&RealIPConfig{TrustedProxies: []string{"127.0.0.1"}})
+
+	var got string
+	h := ri.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = ClientIP(r)
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "127.0.0.1:5555"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got != "198.51.100.1" {
+		t.Fatalf("want 198.51.100.1, got %q", got)
+	}
+	if r.RemoteAddr != "127.0.0.1:5555" {
+		t.Fatalf("RemoteAddr should be untouched, got %q", r.RemoteAddr)
+	}
+}
+
+func TestClientIPWithoutMiddleware(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := ClientIP(r); got != "192.0.2.1" {
+		t.Fatalf("want 192.0.2.1, got %q", got)
+	}
+}
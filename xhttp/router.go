@@ -0,0 +1,337 @@
+package xhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// RouterConfig holds configuration options for [Router].
+type RouterConfig struct {
+	// ErrorHandler sends the 404 and 405 [Err]s, e.g. as JSON with [ErrorJSON]. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// Router is an [http.Handler] on top of [http.ServeMux], adding middleware groups, mounted
+// sub-handlers, named routes for URL generation, and 404 and 405 responses as [Err]s, with
+// the Allow header set for 405s. Patterns are [http.ServeMux] patterns, "[METHOD ][HOST]/PATH",
+// so wildcards are read with [http.Request.PathValue], and [http.Request.Pattern] holds the
+// full pattern for [Metrics] and [Tracer].
+//
+// Middleware added with Use on the root Router wraps every request, including 404s and 405s.
+// Groups share the root's ServeMux with a path prefix and, optionally, a host, and their
+// middleware only wraps routes registered on them, or their subgroups, after Use is called.
+//
+// Usage:
+//
+//	router, _ := xhttp.NewRouter(&xhttp.RouterConfig{ErrorHandler: xhttp.ErrorJSON})
+//	router.Use(realIP.Middleware, metrics.Middleware)
+//	router.HandleFunc("GET /{$}", home).Name("home")
+//
+//	api := router.Group("/api")
+//	api.Use(auth.Middleware)
+//	api.HandleFunc("GET /users/{id}", getUser).Name("user")
+//	api.Mount("/files", static)
+//
+//	u, _ := router.URL("user", "id", "42") // "/api/users/42"
+//	srv, _ := xhttp.NewServer(&xhttp.ServerConfig{Handler: router})
+type Router struct {
+	shared *routerShared
+	group  bool
+	prefix string // path prefix, without a trailing slash
+	host   string
+	mw     []func(http.Handler) http.Handler
+}
+
+// routerShared is the state shared by a Router and its groups.
+type routerShared struct {
+	cfg     *RouterConfig
+	mux     *http.ServeMux
+	mu      sync.Mutex
+	names   map[string]string // route name to path pattern
+	rootMW  []func(http.Handler) http.Handler
+	once    sync.Once
+	handler http.Handler // rootMW around dispatch, built on the first request
+}
+
+// Route is a route registered on a [Router].
+type Route struct {
+	shared *routerShared
+	path   string
+}
+
+// NewRouter creates a new Router with the provided configuration.
+func NewRouter(cfg *RouterConfig) (*Router, error) {
+	copy := *cfg
+
+	// set defaults
+
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	return &Router{shared: &routerShared{
+		cfg:   &copy,
+		mux:   http.NewServeMux(),
+		names: make(map[string]string),
+	}}, nil
+}
+
+// Use adds middleware, applied in the order added, so the first is outermost. On the root
+// Router it wraps every request and must be called before serving. On a group it wraps the
+// routes registered on it afterwards.
+func (rt *Router) Use(mw ...func(http.Handler) http.Handler) {
+	if !rt.group {
+		rt.shared.mu.Lock()
+		defer rt.shared.mu.Unlock()
+		if rt.shared.handler != nil {
+			panic("xhttp: Router.Use called after serving")
+		}
+		rt.shared.rootMW = append(rt.shared.rootMW, mw...)
+		return
+	}
+	rt.mw = append(rt.mw, mw...)
+}
+
+// Group returns a Router registering routes under prefix, e.g. "/api", with the middleware
+// of rt and its own.
+func (rt *Router) Group(prefix string) *Router {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		panic(fmt.Sprintf("xhttp: group prefix %q must start with a slash", prefix))
+	}
+	return &Router{
+		shared: rt.shared,
+		group:  true,
+		prefix: rt.prefix + prefix,
+		host:   rt.host,
+		mw:     append([]func(http.Handler) http.Handler(nil), rt.mw...),
+	}
+}
+
+// Host returns a Router registering routes for host only, e.g. "api.example.com", with the
+// prefix and middleware of rt.
+func (rt *Router) Host(host string) *Router {
+	g := rt.Group("")
+	g.host = host
+	return g
+}
+
+// Handle registers h for pattern, prefixed with the group's path and host.
+func (rt *Router) Handle(pattern string, h http.Handler) *Route {
+	method, host, path := splitPattern(pattern)
+	if host == "" {
+		host = rt.host
+	} else if rt.host != "" && host != rt.host {
+		panic(fmt.Sprintf("xhttp: pattern %q conflicts with group host %q", pattern, rt.host))
+	}
+	path = rt.prefix + path
+	full := host + path
+	if method != "" {
+		full = method + " " + full
+	}
+	for i := len(rt.mw) - 1; i >= 0; i-- {
+		h = rt.mw[i](h)
+	}
+	rt.shared.mux.Handle(full, h)
+	return &Route{shared: rt.shared, path: path}
+}
+
+// HandleFunc registers f for pattern, prefixed with the group's path and host.
+func (rt *Router) HandleFunc(pattern string, f func(http.ResponseWriter, *http.Request)) *Route {
+	return rt.Handle(pattern, http.HandlerFunc(f))
+}
+
+// Mount serves h for all methods under prefix, e.g. "/files" serves "/files/" and everything
+// below it, with the prefix stripped from the request path, so h can be a self-contained
+// handler like [Static] or another Router. Mounting "/" makes h the fallback for requests no
+// other route of the group matches, replacing its 404 and 405 responses.
+func (rt *Router) Mount(prefix string, h http.Handler) *Route {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		panic(fmt.Sprintf("xhttp: mount prefix %q must start with a slash", prefix))
+	}
+	return rt.Handle(prefix+"/", http.StripPrefix(rt.prefix+prefix, h))
+}
+
+// splitPattern splits a ServeMux pattern into its method, host, and path.
+func splitPattern(pattern string) (method, host, path string) {
+	rest := strings.TrimLeft(pattern, " \t")
+	if i := strings.IndexAny(rest, " \t"); i >= 0 {
+		method, rest = rest[:i], strings.TrimLeft(rest[i:], " \t")
+	}
+	i := strings.IndexByte(rest, '/')
+	if i < 0 {
+		panic(fmt.Sprintf("xhttp: invalid pattern %q, the path must start with a slash", pattern))
+	}
+	return method, rest[:i], rest[i:]
+}
+
+// Name names the route for [Router.URL]. Names must be unique.
+func (rt *Route) Name(name string) *Route {
+	rt.shared.mu.Lock()
+	defer rt.shared.mu.Unlock()
+	if _, ok := rt.shared.names[name]; ok {
+		panic(fmt.Sprintf("xhttp: duplicate route name %q", name))
+	}
+	rt.shared.names[name] = rt.path
+	return rt
+}
+
+// URL returns the path of the named route, with its wildcards replaced by params, given as
+// name and value pairs. Values are escaped, except for slashes in remaining wildcards like
+// "{path...}".
+func (rt *Router) URL(name string, params ...string) (string, error) {
+	rt.shared.mu.Lock()
+	path, ok := rt.shared.names[name]
+	rt.shared.mu.Unlock()
+	if !ok {
+		return "", fmt.Errorf("unknown route %q", name)
+	}
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("route %q: params must be name and value pairs", name)
+	}
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		values[params[i]] = params[i+1]
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(path, '{')
+		if start < 0 {
+			b.WriteString(path)
+			break
+		}
+		end := strings.IndexByte(path[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("route %q: malformed pattern", name)
+		}
+		b.WriteString(path[:start])
+		wildcard := path[start+1 : start+end]
+		path = path[start+end+1:]
+		if wildcard == "$" {
+			continue
+		}
+		key, rest := strings.CutSuffix(wildcard, "...")
+		v, ok := values[key]
+		if !ok {
+			return "", fmt.Errorf("route %q: missing param %q", name, key)
+		}
+		delete(values, key)
+		if rest {
+			segments := strings.Split(v, "/")
+			for i, s := range segments {
+				segments[i] = url.PathEscape(s)
+			}
+			b.WriteString(strings.Join(segments, "/"))
+		} else {
+			b.WriteString(url.PathEscape(v))
+		}
+	}
+	for key := range values {
+		return "", fmt.Errorf("route %q: unknown param %q", name, key)
+	}
+	return b.String(), nil
+}
+
+// ServeHTTP implements [http.Handler].
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := rt.shared
+	s.once.Do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var h http.Handler = http.HandlerFunc(s.dispatch)
+		for i := len(s.rootMW) - 1; i >= 0; i-- {
+			h = s.rootMW[i](h)
+		}
+		s.handler = h
+	})
+	s.handler.ServeHTTP(w, r)
+}
+
+// dispatch serves matched requests with the ServeMux, rendering its 404s and 405s as [Err]s.
+func (s *routerShared) dispatch(w http.ResponseWriter, r *http.Request) {
+	h, pattern := s.mux.Handler(r)
+	if pattern != "" {
+		// serve the match directly rather than matching again in ServeMux.ServeHTTP, setting
+		// what it would
+		r.Pattern = pattern
+		setPathValues(r, pattern)
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	// unmatched, see whether the ServeMux would send a 405
+	rec := &headerRecorder{header: make(http.Header)}
+	h.ServeHTTP(rec, r)
+	if rec.code == http.StatusMethodNotAllowed {
+		w.Header().Set("Allow", rec.header.Get("Allow"))
+		s.cfg.ErrorHandler(r.Context(), w, &Err{
+			Code: http.StatusMethodNotAllowed,
+			Msg:  "Method not allowed",
+			Err:  fmt.Errorf("router: method %s not allowed for %s", r.Method, r.URL.Path),
+		})
+		return
+	}
+	s.cfg.ErrorHandler(r.Context(), w, &Err{
+		Code: http.StatusNotFound,
+		Msg:  "Not found",
+		Err:  fmt.Errorf("router: %s not found", r.URL.Path),
+	})
+}
+
+// setPathValues sets the wildcard values of pattern for r, which it matches, as ServeMux does
+// for [http.Request.PathValue].
+func setPathValues(r *http.Request, pattern string) {
+	_, _, path := splitPattern(pattern)
+	if !strings.Contains(path, "{") {
+		return
+	}
+	segs := strings.Split(path, "/")
+	reqSegs := strings.Split(r.URL.EscapedPath(), "/")
+	for i, seg := range segs {
+		if !strings.HasPrefix(seg, "{") || i >= len(reqSegs) {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(seg, "{"), "}")
+		if name == "$" {
+			return
+		}
+		if name, ok := strings.CutSuffix(name, "..."); ok {
+			r.SetPathValue(name, pathUnescape(strings.Join(reqSegs[i:], "/")))
+			return
+		}
+		r.SetPathValue(name, pathUnescape(reqSegs[i]))
+	}
+}
+
+// pathUnescape unescapes a path value, keeping it as is if malformed, as ServeMux does.
+func pathUnescape(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// headerRecorder records the status and headers of a response, discarding the body.
+type headerRecorder struct {
+	header http.Header
+	code   int
+}
+
+func (hr *headerRecorder) Header() http.Header { return hr.header }
+
+func (hr *headerRecorder) WriteHeader(code int) {
+	if hr.code == 0 {
+		hr.code = code
+	}
+}
+
+func (hr *headerRecorder) Write(p []byte) (int, error) {
+	hr.WriteHeader(http.StatusOK)
+	return len(p), nil
+}
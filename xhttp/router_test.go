@@ -0,0 +1,161 @@
+package xhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// tagMiddleware appends tag to the X-Chain response header, recording the middleware order.
+func tagMiddleware(tag string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Chain", tag)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRouter(t *testing.T) {
+	router, _ := NewRouter(&RouterConfig{ErrorHandler: ErrorJSON})
+	router.Use(tagMiddleware("root"))
+	echo := func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Pattern+" "+r.PathValue("id")+r.URL.Path)
+	}
+	router.HandleFunc("GET /{$}", echo).Name("home")
+
+	api := router.Group("/api")
+	api.Use(tagMiddleware("api"))
+	api.HandleFunc("GET /users/{id}", echo).Name("user")
+	api.HandleFunc("DELETE /users/{id}", echo)
+	admin := api.Group("/admin/")
+	admin.Use(tagMiddleware("admin"))
+	admin.HandleFunc("POST /reindex", echo)
+	api.Mount("/files", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "mounted "+r.URL.Path)
+	}))
+	router.Host("docs.example.com").HandleFunc("GET /", echo)
+
+	tests := []struct {
+		method, target string
+		code           int
+		body, chain    string
+	}{
+		{"GET", "/", 200, "GET /{$} /", "root"},
+		{"GET", "/api/users/7", 200, "GET /api/users/{id} 7/api/users/7", "root,api"},
+		{"POST", "/api/admin/reindex", 200, "POST /api/admin/reindex /api/admin/reindex", "root,api,admin"},
+		{"PUT", "/api/files/a/b.txt", 200, "mounted /a/b.txt", "root,api"},
+		{"GET", "http://docs.example.com/guide", 200, "GET docs.example.com/ /guide", "root"},
+		{"GET", "/guide", 404, `"message":"Not found"`, "root"},
+		{"PUT", "/api/users/7", 405, `"message":"Method not allowed"`, "root"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+			if rec.Code != tt.code || !strings.Contains(rec.Body.String(), tt.body) {
+				t.Fatalf("want %d %q, got %d %q", tt.code, tt.body, rec.Code, rec.Body)
+			}
+			if chain := strings.Join(rec.Header().Values("X-Chain"), ","); chain != tt.chain {
+				t.Fatalf("want middleware %q, got %q", tt.chain, chain)
+			}
+			if tt.code == 405 {
+				if allow := rec.Header().Get("Allow"); !strings.Contains(allow, "GET") || !strings.Contains(allow, "DELETE") {
+					t.Fatalf("want Allow with GET and DELETE, got %q", allow)
+				}
+			}
+		})
+	}
+}
+
+func TestRouterPathValues(t *testing.T) {
+	router, _ := NewRouter(&RouterConfig{})
+	router.Group("/v1").HandleFunc("GET /repos/{owner}/{repo}/blob/{path...}", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Pattern+"|"+r.PathValue("owner")+"|"+r.PathValue("repo")+"|"+r.PathValue("path"))
+	})
+	router.Mount("/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "fallback "+r.URL.Path)
+	}))
+
+	for target, want := range map[string]string{
+		"/v1/repos/ada/a%2Fb/blob/docs/read%20me.md": "GET /v1/repos/{owner}/{repo}/blob/{path...}|ada|a/b|docs/read me.md",
+		"/v1/repos/ada/x/blob/":                      "GET /v1/repos/{owner}/{repo}/blob/{path...}|ada|x|",
+		"/elsewhere":                                 "fallback /elsewhere",
+		"/v1/repos":                                  "fallback /v1/repos",
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != want {
+			t.Fatalf("%s: want %q, got %d %q", target, want, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestRouterURL(t *testing.T) {
+	router, _ := NewRouter(&RouterConfig{})
+	router.Handle("GET /{$}", noopHandler()).Name("home")
+	files := router.Group("/v1")
+	files.Handle("GET /files/{owner}/{path...}", noopHandler()).Name("file")
+
+	tests := []struct {
+		name    string
+		params  []string
+		want    string
+		wantErr bool
+	}{
+		{"home", nil, "/", false},
+		{"file", []string{"owner", "a b", "path", "docs/read me.md"}, "/v1/files/a%20b/docs/read%20me.md", false},
+		{"file", []string{"owner", "a"}, "", true},
+		{"file", []string{"owner", "a", "path", "b", "extra", "c"}, "", true},
+		{"file", []string{"owner"}, "", true},
+		{"missing", nil, "", true},
+	}
+	for _, tt := range tests {
+		got, err := router.URL(tt.name, tt.params...)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Fatalf("URL(%q, %q): want %q, %v, got %q, %v", tt.name, tt.params, tt.want, tt.wantErr, got, err)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for duplicate name")
+		}
+	}()
+	router.Handle("GET /other", noopHandler()).Name("home")
+}
+
+func TestRouterUseAfterServe(t *testing.T) {
+	router, _ := NewRouter(&RouterConfig{})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for Use after serving")
+		}
+	}()
+	router.Use(tagMiddleware("late"))
+}
+
+func TestRouterAsServerHandler(t *testing.T) {
+	router, _ := NewRouter(&RouterConfig{})
+	router.HandleFunc("GET /ping", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "pong") })
+	srv, err := NewServer(&ServerConfig{Handler: router})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.server
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/ping")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	if b, _ := io.ReadAll(resp.Body); string(b) != "pong" {
+		t.Fatalf("want pong, got %q", b)
+	}
+}
@@ -0,0 +1,112 @@
+package xhttp
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSecurityHeadersValidation(t *testing.T) {
+	if _, err := NewSecurityHeaders(&SecurityHeadersConfig{HSTSPreload: true}); err == nil {
+		t.Fatal("expected error for preload without includeSubDomains")
+	}
+	if _, err := NewSecurityHeaders(&SecurityHeadersConfig{HSTSPreload: true, HSTSIncludeSubdomains: true, HSTSMaxAge: time.Hour}); err == nil {
+		t.Fatal("expected error for preload with short max-age")
+	}
+}
+
+func TestSecurityHeadersDefaults(t *testing.T) {
+	sh, err := NewSecurityHeaders(&SecurityHeadersConfig{})
+	if err != nil {
+		t.Fatalf("new security headers: %v", err)
+	}
+
+	var nonce string
+	h := sh.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Fatalf("HSTS should not be sent over plain HTTP, got %q", got)
+	}
+	want := map[string]string{
+		"X-Content-Type-Options": "nosniff",
+		"X-Frame-Options":        DefaultFrameOptions,
+		"Referrer-Policy":        DefaultReferrerPolicy,
+		"Permissions-Policy":     DefaultPermissionsPolicy,
+	}
+	for k, v := range want {
+		if got := rec.Header().Get(k); got != v {
+			t.Errorf("%s: want %q, got %q", k, v, got)
+		}
+	}
+
+	if nonce == "" {
+		t.Fatal("expected nonce in context")
+	}
+	csp := rec.Header().Get("Content-Security-Policy")
+	if strings.Contains(csp, NoncePlaceholder) || !strings.Contains(csp, "'nonce-"+nonce+"'") {
+		t.Fatalf("CSP should contain the request nonce, got %q", csp)
+	}
+
+	rec2 := httptest.NewRecorder()
+	first := nonce
+	h.ServeHTTP(rec2, httptest.NewRequest("GET", "/", nil))
+	if nonce == first {
+		t.Fatal("nonce should change per request")
+	}
+}
+
+func TestSecurityHeadersHSTS(t *testing.T) {
+	sh, _ := NewSecurityHeaders(&SecurityHeadersConfig{HSTSIncludeSubdomains: true, HSTSPreload: true})
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.TLS = &tls.ConnectionState{}
+	sh.Middleware(noopHandler()).ServeHTTP(rec, r)
+
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains; preload" {
+		t.Fatalf("unexpected HSTS: %q", got)
+	}
+
+	sh, _ = NewSecurityHeaders(&SecurityHeadersConfig{HSTSAlways: true})
+	rec = httptest.NewRecorder()
+	sh.Middleware(noopHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "max-age=31536000" {
+		t.Fatalf("unexpected HSTS with HSTSAlways: %q", got)
+	}
+}
+
+func TestSecurityHeadersOmitAndReportOnly(t *testing.T) {
+	sh, _ := NewSecurityHeaders(&SecurityHeadersConfig{
+		HSTSMaxAge:    -1,
+		FrameOptions:  "-",
+		CSP:           "default-src 'self'",
+		CSPReportOnly: true,
+	})
+	var nonce string
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.TLS = &tls.ConnectionState{}
+	sh.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = CSPNonce(r.Context())
+	})).ServeHTTP(rec, r)
+
+	if rec.Header().Get("Strict-Transport-Security") != "" || rec.Header().Get("X-Frame-Options") != "" {
+		t.Fatal("disabled headers should be omitted")
+	}
+	if rec.Header().Get("Content-Security-Policy") != "" {
+		t.Fatal("report-only CSP should not set the enforcing header")
+	}
+	if got := rec.Header().Get("Content-Security-Policy-Report-Only"); got != "default-src 'self'" {
+		t.Fatalf("unexpected report-only CSP: %q", got)
+	}
+	if nonce != "" {
+		t.Fatal("no nonce expected without placeholder")
+	}
+}
@@ -5,6 +5,31 @@
 // Current extensions:
 //   - [Server] wraps [http.Server] with signal-based graceful shutdown, lifecycle hooks, and sensible defaults
 //   - [Err] type and [Error] function for separating internal errors from client-safe messages in HTTP handlers
+//   - [Validate] and [DecodeJSON] for struct tag based request validation, reported as a 422 [Err] with [FieldErrors]
+//   - [RateLimiter] middleware for per-client token bucket rate limiting with RateLimit-* headers
+//   - [ConcurrencyLimiter] middleware for capping in-flight requests, with bounded queuing, load shedding, and an adaptive mode
+//   - [RealIP] middleware and [ClientIP] for resolving the real client address behind trusted reverse proxies
+//   - [Compressor] middleware for transparent gzip/deflate response compression with Accept-Encoding negotiation
+//   - [CORS] middleware for cross-origin requests with automatic preflight handling
+//   - [SecurityHeaders] middleware for HSTS, CSP with per-request nonces, and other security headers
+//   - [CSRF] middleware for signed double-submit cookie CSRF protection with Origin and Sec-Fetch-Site checks
+//   - [SessionManager] middleware for encrypted cookie sessions with key rotation, sliding expiry, and an optional [SessionStore]
+//   - [Auth] middleware with pluggable [Authenticator]s for API keys, HTTP Basic, and JWT bearer tokens
+//   - [SSE] for Server-Sent Events streams with heartbeats, per-write deadlines, and a final event on [Server] shutdown
+//   - [WebSocketUpgrader] for dependency-free RFC 6455 WebSockets with keepalive pings and a close handshake on [Server] shutdown
+//   - [Timeout] middleware for per-route handler timeouts and connection read/write deadline overrides
+//   - [Static] handler for fs.FS and embed.FS assets with content-hash ETags, immutable caching, precompressed siblings, and SPA fallback
+//   - [Metrics] registry of counters, gauges, and histograms with a Prometheus text endpoint, request metrics middleware, and server lifecycle gauges
+//   - [Tracer] middleware for W3C Trace Context propagation with spans, trace IDs in xlog lines, and batched export, e.g. with [OTLPExporter]
+//   - [AdminConfig] for an opt-in admin listener with pprof, goroutine dumps, runtime and build info, and log level and flush endpoints
+//   - [Client] for outbound calls with retries, jittered backoff honoring Retry-After, per-host circuit breakers, and [ResponseError] decoding
+//   - [ServerConfig] protocol options for HTTP/2, h2c behind TLS-terminating proxies, max concurrent streams, header size limits, and a header read timeout
+//   - [TLSConfig] policy presets following the Mozilla guidelines, cipher suite, curve, and ALPN options, and certificates from PEM or PKCS#12 in memory via [LoadPKCS12]
+//   - [Server.Stats] connection tracking with new, active, and idle counts, MaxConns and MaxConnsPerIP limits, and force-closed connections logged at shutdown
+//   - [Idempotency] middleware replaying stored responses for repeated Idempotency-Key requests, with in-memory and file [IdempotencyStore]s and a 409 for in-flight duplicates
+//   - [CheckPreconditions] for If-Match, If-None-Match, and If-Modified-Since handling as 304 and 412 [Err]s, the [ETagger] hashing middleware, and a [Cache] middleware respecting Cache-Control
+//   - [Router] on top of [http.ServeMux] with middleware groups, host routes, mounts, named-route URLs, and 404 and 405 [Err]s with an Allow header
+//   - [Proxy] reverse proxy balancing round-robin or least-connections across HTTP and Unix socket upstreams, with health checks, ejection, and 502 and 504 [Err]s
 //
 // [Server] usage:
 //
@@ -48,14 +73,18 @@ package xhttp
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
 )
 
 // Default values for config, everything else defaults to zero values.
@@ -67,35 +96,54 @@ const (
 	DefaultIdleTimeout      = 120 * time.Second
 	DefaultShutdownTimeout  = 10 * time.Second
 	DefaultAfterListenDelay = 1 * time.Second
+
+	DefaultReadHeaderTimeout    = 2 * time.Second
+	DefaultMaxHeaderBytes       = 64 << 10 // 64 KiB
+	DefaultMaxConcurrentStreams = 250
 )
 
 // ServerConfig holds configuration options for [Server].
 type ServerConfig struct {
 	Addr string // Address to listen on (e.g. ":8080"). Default is ":80", ":443" if UseTLS is true.
 
-	UseTLS      bool   // Whether to use TLS (HTTPS). If true, TLSKeyPath and TLSCertPath must be set.
+	UseTLS      bool   // Whether to use TLS (HTTPS). If true, TLSKeyPath and TLSCertPath or a TLS certificate source must be set.
 	TLSKeyPath  string // Path to the TLS private key file.
 	TLSCertPath string // Path to the TLS certificate file.
 
+	// TLS sets the TLS policy, cipher suites, curves, and ALPN protocols, and can provide the
+	// certificate from memory instead of files. Requires UseTLS. Default is [TLSModern], TLS 1.3 only.
+	TLS *TLSConfig
+
 	// Handler, typically a router or middleware chain. Required.
 	//
 	// Works with any http.Handler compatible router (chi, gorilla/mux, etc.)
 	Handler http.Handler
 
-	ReadTimeout  time.Duration // Max duration for reading the entire request, including the body. Default is 5 seconds. Negative to disable.
-	WriteTimeout time.Duration // Max duration before timing out writes of the response. Default is 10 seconds. Negative to disable.
+	ReadTimeout time.Duration // Max duration for reading the entire request, including the body. Default is 5 seconds. Negative to disable.
+
+	// ReadHeaderTimeout is the max duration for reading request headers, limiting Slowloris-style
+	// attacks separately from ReadTimeout. Default is 2 seconds. Negative to disable, falling back to ReadTimeout.
+	ReadHeaderTimeout time.Duration
+
+	MaxHeaderBytes int // Max size of request headers, larger ones get a 431 response. Default is 64 KiB.
+
+	WriteTimeout time.Duration // Max duration before timing out writes of the response. Default is 10 seconds. Negative to disable. [SSE] streams extend it per write.
 
 	// IdleTimeout is the maximum duration for which an idle connection will remain open.
 	// In plain terms, [http.Server] leaves connections open for a certain time after the last request
 	// for performance reasons. This is the maximum duration for that. Default is 120 seconds. Negative to disable.
 	//
 	// This does not affect:
-	//  - WebSocket connections (once upgraded)
+	//  - WebSocket connections (once upgraded), which use [WebSocketConfig.PingInterval] instead
 	//  - Active request/response handling
 	//  - Long-lived streaming responses (like SSE or chunked transfer)
 	IdleTimeout time.Duration
 
-	ShutdownTimeout time.Duration // Maximum duration for graceful shutdown. Default is 10 seconds. Zero or negative to disable.
+	// ShutdownTimeout is the maximum duration for graceful shutdown. Default is 10 seconds. Zero or negative to disable.
+	//
+	// During shutdown, open [SSE] streams are sent a final event and open [WebSocket]s a close
+	// frame, and both are waited for along with in-flight requests.
+	ShutdownTimeout time.Duration
 
 	// AfterListen, if non-nil, is called after the server starts listening. Simple and flexible.
 	// Useful for validating the server is up and running, e.g. by checking a health endpoint.
@@ -109,12 +157,120 @@ type ServerConfig struct {
 	//  - depending on the shutdown timeout, this may exceed the life of the server.
 	//  - if ShutdownTimeout is <= 0, this will not be called.
 	OnShutdown func()
+
+	// Protocols. HTTP/1.1 is always served, HTTP/2 over TLS by default.
+
+	DisableHTTP2 bool // Serve only HTTP/1.1 over TLS.
+
+	// H2C serves unencrypted HTTP/2 with prior knowledge alongside HTTP/1.1, for deployments
+	// behind TLS-terminating proxies that speak HTTP/2 to backends. The HTTP/1.1 Upgrade
+	// mechanism is not supported, as it's deprecated by RFC 9113.
+	H2C bool
+
+	MaxConcurrentStreams int // Max concurrent requests per HTTP/2 connection. Default is 250.
+
+	// Connection limits. Connections over a limit are closed right after accept, see [Server.Stats].
+	// Hijacked connections, e.g. [WebSocket]s, stop counting toward them.
+
+	MaxConns      int // Max open connections. Default is unlimited.
+	MaxConnsPerIP int // Max open connections per remote IP, the proxy's behind a reverse proxy. Default is unlimited.
+
+	// Logger, if non-nil, logs server lifecycle events, e.g. how many connections were
+	// force-closed when ShutdownTimeout expired.
+	Logger *xlog.Logger
+
+	// Metrics, if non-nil, exports server lifecycle gauges: start time, whether shutdown has
+	// begun, and open connections and WebSockets. Request metrics come from [Metrics.Middleware].
+	// A Metrics can be used by one Server only, NewServer fails for another.
+	Metrics *Metrics
+
+	// Admin, if non-nil, starts an admin server on a second, private listener with pprof,
+	// goroutine dumps, runtime and build info, and log level and flush endpoints. It starts
+	// and shuts down with the Server.
+	Admin *AdminConfig
 }
 
 // Server wraps [http.Server] with graceful shutdown, lifecycle hooks, and sensible defaults.
 type Server struct {
 	cfg    *ServerConfig // Configuration for the server
 	server *http.Server  // The http or https server
+	state  *serverState  // Shared with handlers for shutdown of long-lived connections
+
+	admin       *http.Server // The admin server, nil if disabled
+	adminListen func() (net.Listener, error)
+
+	started atomic.Int64 // Unix time Listen was called, for metrics
+}
+
+// serverState is shared with handlers through the base context, letting long-lived
+// connections like [SSE] and [WebSocket] take part in graceful shutdown.
+type serverState struct {
+	shutdown chan struct{} // closed when shutdown begins
+	once     sync.Once     // Shutdown runs hooks on every call
+
+	conns *connTracker // open connections, excluding hijacked ones
+
+	mu      sync.Mutex
+	sockets map[*WebSocket]struct{}
+}
+
+type serverStateKey struct{}
+
+func serverStateFrom(ctx context.Context) *serverState {
+	st, _ := ctx.Value(serverStateKey{}).(*serverState)
+	return st
+}
+
+// beginShutdown signals open streams and starts the close handshake on open WebSockets.
+func (st *serverState) beginShutdown() {
+	st.once.Do(func() { close(st.shutdown) })
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for ws := range st.sockets {
+		go ws.Close(CloseGoingAway, "server shutting down")
+	}
+}
+
+func (st *serverState) track(ws *WebSocket) {
+	st.mu.Lock()
+	st.sockets[ws] = struct{}{}
+	st.mu.Unlock()
+}
+
+func (st *serverState) untrack(ws *WebSocket) {
+	st.mu.Lock()
+	delete(st.sockets, ws)
+	st.mu.Unlock()
+}
+
+// waitSockets waits for open WebSockets to finish closing or until ctx is done.
+func (st *serverState) waitSockets(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		st.mu.Lock()
+		n := len(st.sockets)
+		st.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeSockets closes the underlying connections of open WebSockets without a close handshake,
+// returning how many were open.
+func (st *serverState) closeSockets() int {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for ws := range st.sockets {
+		ws.conn.Close()
+	}
+	return len(st.sockets)
 }
 
 // NewServer creates a new Server instance with the provided configuration.
@@ -125,8 +281,29 @@ func NewServer(cfg *ServerConfig) (*Server, error) {
 		return nil, fmt.Errorf("handler must be provided")
 	}
 
-	if copy.UseTLS && (copy.TLSKeyPath == "" || copy.TLSCertPath == "") {
-		return nil, fmt.Errorf("TLS key and cert paths must be provided when using TLS")
+	if copy.TLS != nil && !copy.UseTLS {
+		return nil, fmt.Errorf("TLS options require UseTLS")
+	}
+	tlsConfig, err := copy.TLS.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	hasPaths := copy.TLSKeyPath != "" || copy.TLSCertPath != ""
+	if copy.UseTLS && len(tlsConfig.Certificates) == 0 && (copy.TLSKeyPath == "" || copy.TLSCertPath == "") {
+		return nil, fmt.Errorf("TLS key and cert paths or a TLS certificate source must be provided when using TLS")
+	}
+	if hasPaths && len(tlsConfig.Certificates) > 0 {
+		return nil, fmt.Errorf("TLS key and cert paths and a TLS certificate source are mutually exclusive")
+	}
+
+	if copy.H2C && copy.DisableHTTP2 {
+		return nil, fmt.Errorf("H2C requires HTTP/2")
+	}
+	if copy.MaxHeaderBytes < 0 || copy.MaxConcurrentStreams < 0 {
+		return nil, fmt.Errorf("max header bytes and max concurrent streams must not be negative")
+	}
+	if copy.MaxConns < 0 || copy.MaxConnsPerIP < 0 {
+		return nil, fmt.Errorf("max conns and max conns per IP must not be negative")
 	}
 
 	// set defaults
@@ -142,6 +319,15 @@ func NewServer(cfg *ServerConfig) (*Server, error) {
 	if copy.ReadTimeout == 0 {
 		copy.ReadTimeout = DefaultReadTimeout
 	}
+	if copy.ReadHeaderTimeout == 0 {
+		copy.ReadHeaderTimeout = DefaultReadHeaderTimeout
+	}
+	if copy.MaxHeaderBytes == 0 {
+		copy.MaxHeaderBytes = DefaultMaxHeaderBytes
+	}
+	if copy.MaxConcurrentStreams == 0 {
+		copy.MaxConcurrentStreams = DefaultMaxConcurrentStreams
+	}
 	if copy.WriteTimeout == 0 {
 		copy.WriteTimeout = DefaultWriteTimeout
 	}
@@ -155,26 +341,56 @@ func NewServer(cfg *ServerConfig) (*Server, error) {
 		copy.AfterListenDelay = DefaultAfterListenDelay
 	}
 
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(!copy.DisableHTTP2)
+	protocols.SetUnencryptedHTTP2(copy.H2C)
+
 	// create http server
+	state := &serverState{
+		shutdown: make(chan struct{}),
+		conns:    newConnTracker(copy.MaxConns, copy.MaxConnsPerIP),
+		sockets:  make(map[*WebSocket]struct{}),
+	}
 	httpServer := &http.Server{
-		Addr:         copy.Addr,
-		Handler:      copy.Handler,
-		ReadTimeout:  copy.ReadTimeout,
-		WriteTimeout: copy.WriteTimeout,
-		IdleTimeout:  copy.IdleTimeout,
-		TLSConfig:    &tls.Config{MinVersion: tls.VersionTLS13},
+		Addr:              copy.Addr,
+		Handler:           copy.Handler,
+		ReadTimeout:       copy.ReadTimeout,
+		ReadHeaderTimeout: copy.ReadHeaderTimeout,
+		WriteTimeout:      copy.WriteTimeout,
+		IdleTimeout:       copy.IdleTimeout,
+		MaxHeaderBytes:    copy.MaxHeaderBytes,
+		Protocols:         protocols,
+		HTTP2:             &http.HTTP2Config{MaxConcurrentStreams: copy.MaxConcurrentStreams},
+		TLSConfig:         tlsConfig,
+		BaseContext: func(net.Listener) context.Context {
+			return context.WithValue(context.Background(), serverStateKey{}, state)
+		},
+		ConnState: state.conns.connState,
 	}
+	httpServer.RegisterOnShutdown(state.beginShutdown)
 
 	// set shutdown hook if provided
 	if copy.OnShutdown != nil && copy.ShutdownTimeout > 0 {
 		httpServer.RegisterOnShutdown(copy.OnShutdown)
 	}
 
-	// return the server
-	return &Server{
+	srv := &Server{
 		cfg:    &copy,
 		server: httpServer,
-	}, nil
+		state:  state,
+	}
+	if copy.Metrics != nil {
+		if err := copy.Metrics.registerServer(srv); err != nil {
+			return nil, fmt.Errorf("failed to register server metrics: %w", err)
+		}
+	}
+	if copy.Admin != nil {
+		if srv.admin, srv.adminListen, err = newAdminServer(copy.Admin); err != nil {
+			return nil, err
+		}
+	}
+	return srv, nil
 }
 
 // Addr returns the address the server is listening on.
@@ -185,16 +401,40 @@ func (s *Server) Addr() string {
 	return s.cfg.Addr
 }
 
+// Stats returns a snapshot of the server's connections.
+//
+// Thread-safe, can be called from any goroutine.
+func (s *Server) Stats() ServerStats {
+	var stats ServerStats
+	s.state.conns.stats(&stats)
+	s.state.mu.Lock()
+	stats.WebSockets = len(s.state.sockets)
+	s.state.mu.Unlock()
+	return stats
+}
+
 // Listen starts the server and blocks until it is shut down or an error occurs.
 func (s *Server) Listen() error {
 	// setup chans for listen and shutdown signals
 	listenErrCh := make(chan error, 1)
 	shutdownCh := make(chan os.Signal, 1)
 	signal.Notify(shutdownCh, os.Interrupt, syscall.SIGTERM)
+	s.started.Store(time.Now().Unix())
+
+	// start admin server, failing early as it's on a separate listener
+	if s.admin != nil {
+		ln, err := s.adminListen()
+		if err != nil {
+			signal.Stop(shutdownCh)
+			return fmt.Errorf("failed to start admin server: %w", err)
+		}
+		go s.admin.Serve(ln)
+	}
 
 	// start server
 	go func() {
 		if s.cfg.UseTLS {
+			// empty paths use the certificate loaded from memory
 			listenErrCh <- s.server.ListenAndServeTLS(s.cfg.TLSCertPath, s.cfg.TLSKeyPath)
 		} else {
 			listenErrCh <- s.server.ListenAndServe()
@@ -218,14 +458,12 @@ func (s *Server) Listen() error {
 			s.cfg.AfterListen()
 		case <-shutdownCh:
 			signal.Stop(shutdownCh)
-			if s.cfg.ShutdownTimeout <= 0 {
-				return s.server.Close()
-			}
-			ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
-			defer cancel()
-			return s.server.Shutdown(ctx) // blocks until all connections are closed or context times out
+			return s.Shutdown() // blocks until all connections are closed or the shutdown timeout is reached
 		case err := <-listenErrCh:
 			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				if s.admin != nil {
+					s.admin.Close()
+				}
 				if errors.Is(err, syscall.EADDRINUSE) {
 					return fmt.Errorf("address already in use: %w", err)
 				}
@@ -247,7 +485,7 @@ func (s *Server) ShutdownWithContext(ctx context.Context) error {
 	if ctx == nil {
 		return fmt.Errorf("context is nil") // prevents panic when there are active connections / cleanup wait
 	}
-	return s.server.Shutdown(ctx) // blocks
+	return s.shutdown(ctx) // blocks
 }
 
 // Shutdown gracefully stops the server, blocking until all connections are
@@ -256,9 +494,49 @@ func (s *Server) ShutdownWithContext(ctx context.Context) error {
 // Thread-safe, can be called from any goroutine.
 func (s *Server) Shutdown() error {
 	if s.cfg.ShutdownTimeout <= 0 {
-		return s.server.Close()
+		err := s.forceClose("graceful shutdown disabled")
+		if s.admin != nil {
+			s.admin.Close()
+		}
+		return err
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
 	defer cancel()
-	return s.server.Shutdown(ctx) // blocks
+	return s.shutdown(ctx) // blocks
+}
+
+// shutdown stops the http server, then waits for WebSockets to finish their close handshake,
+// which [http.Server.Shutdown] doesn't track once they are hijacked. Connections still open when
+// ctx is done are force-closed. The admin server stops last, so a stuck shutdown can still be inspected.
+func (s *Server) shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if werr := s.state.waitSockets(ctx); err == nil {
+		err = werr
+	}
+	if ctx.Err() != nil {
+		s.forceClose("shutdown deadline exceeded")
+	}
+	if s.admin != nil {
+		if aerr := s.admin.Shutdown(ctx); aerr != nil {
+			s.admin.Close() // e.g. a CPU profile still streaming
+		}
+	}
+	return err
+}
+
+// forceClose closes open connections and WebSockets without waiting for them, logging what was
+// cut off, so slow shutdowns can be traced to the connections holding them up.
+func (s *Server) forceClose(reason string) error {
+	var stats ServerStats
+	s.state.conns.stats(&stats)
+	err := s.server.Close()
+	sockets := s.state.closeSockets()
+	if n := stats.Open + sockets; n > 0 {
+		s.state.conns.addForceClosed(n)
+		if s.cfg.Logger != nil {
+			s.cfg.Logger.Warnf("xhttp: %s, force-closed %d connections (%d active, %d idle, %d new) and %d WebSockets",
+				reason, stats.Open, stats.Active, stats.Idle, stats.New, sockets)
+		}
+	}
+	return err
 }
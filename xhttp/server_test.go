@@ -1,7 +1,10 @@
 package xhttp
 
 import (
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
@@ -54,6 +57,19 @@ func TestNewServerDefaultsApplied(t *testing.T) {
 		t.Errorf("AfterListenDelay default: want %s, got %s", DefaultAfterListenDelay, got)
 	}
 
+	if got := srv.server.ReadHeaderTimeout; got != DefaultReadHeaderTimeout {
+		t.Errorf("ReadHeaderTimeout default: want %s, got %s", DefaultReadHeaderTimeout, got)
+	}
+	if got := srv.server.MaxHeaderBytes; got != DefaultMaxHeaderBytes {
+		t.Errorf("MaxHeaderBytes default: want %d, got %d", DefaultMaxHeaderBytes, got)
+	}
+	if p := srv.server.Protocols; !p.HTTP1() || !p.HTTP2() || p.UnencryptedHTTP2() {
+		t.Errorf("Protocols default: want HTTP/1.1 and HTTP/2 over TLS, got %v", p)
+	}
+	if got := srv.server.HTTP2.MaxConcurrentStreams; got != DefaultMaxConcurrentStreams {
+		t.Errorf("MaxConcurrentStreams default: want %d, got %d", DefaultMaxConcurrentStreams, got)
+	}
+
 	if srv.server.TLSConfig == nil {
 		t.Errorf("TLSConfig should never be nil (even for non-TLS servers)")
 	}
@@ -95,3 +111,53 @@ func TestServerListenLifecycle(t *testing.T) {
 		t.Fatalf("server did not shut down in time")
 	}
 }
+
+func TestNewServerProtocols(t *testing.T) {
+	if _, err := NewServer(&ServerConfig{Handler: noopHandler(), H2C: true, DisableHTTP2: true}); err == nil {
+		t.Fatal("expected error for H2C without HTTP/2")
+	}
+	srv, _ := NewServer(&ServerConfig{Handler: noopHandler(), DisableHTTP2: true})
+	if p := srv.server.Protocols; !p.HTTP1() || p.HTTP2() {
+		t.Fatalf("want only HTTP/1.1, got %v", p)
+	}
+}
+
+func TestServerH2C(t *testing.T) {
+	srv, _ := NewServer(&ServerConfig{
+		H2C:            true,
+		MaxHeaderBytes: 1 << 10,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+	})
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.server
+	ts.Start()
+	defer ts.Close()
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	h2c := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	for name, client := range map[string]*http.Client{"HTTP/2.0": h2c, "HTTP/1.1": http.DefaultClient} {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != name {
+			t.Fatalf("want %s, got %s", name, body)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("X-Large", strings.Repeat("a", 8<<10))
+	resp, err := (&http.Client{Transport: &http.Transport{}}).Do(req) // on a new connection
+	if err != nil {
+		t.Fatalf("large headers: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestHeaderFieldsTooLarge {
+		t.Fatalf("want 431 for large headers, got %d", resp.StatusCode)
+	}
+}
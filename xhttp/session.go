@@ -0,0 +1,477 @@
+package xhttp
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default values for [SessionConfig].
+const (
+	DefaultSessionCookieName  = "__Host-session"
+	DefaultSessionIdleTimeout = 24 * time.Hour
+	DefaultSessionMaxAge      = 7 * 24 * time.Hour
+)
+
+// maxCookieSize is the size browsers reliably accept for a single cookie, including its name.
+const maxCookieSize = 4096
+
+// SessionConfig holds configuration options for [SessionManager].
+type SessionConfig struct {
+	// Keys used to encrypt session cookies, each at least 32 bytes. The first key encrypts new
+	// cookies, all keys are tried when decrypting, so keys can be rotated by prepending a new
+	// one and dropping the oldest once MaxAge has passed. Required.
+	Keys [][]byte
+
+	// CookieName is the name of the session cookie. Default is "__Host-session", or "session"
+	// when InsecureCookie is set, since the __Host- prefix requires the Secure flag.
+	CookieName     string
+	InsecureCookie bool          // Omit the Secure cookie flag, for UIs served over plain HTTP on a non-localhost address.
+	SameSite       http.SameSite // SameSite cookie attribute. Default is [http.SameSiteLaxMode].
+
+	// IdleTimeout expires sessions that haven't been used for this long. Sessions are renewed
+	// (sliding expiry) when used after half of it has passed. Default is 24 hours.
+	IdleTimeout time.Duration
+	// MaxAge is the absolute session lifetime, regardless of activity. Default is 7 days.
+	MaxAge time.Duration
+
+	// Store, if non-nil, keeps session values server-side, with only the session ID in the
+	// cookie. Use it for sessions that don't fit in a 4 KB cookie or must be revocable.
+	Store SessionStore
+}
+
+// SessionStore stores session values server-side for [SessionManager].
+type SessionStore interface {
+	// Load returns the values for id, or nil and no error if the session doesn't exist.
+	Load(ctx context.Context, id string) (map[string]string, error)
+	// Save stores the values for id until expiry.
+	Save(ctx context.Context, id string, values map[string]string, expiry time.Time) error
+	// Delete removes the session. Deleting a missing session is not an error.
+	Delete(ctx context.Context, id string) error
+}
+
+// SessionManager is a middleware providing cookie-based sessions, typically for login state
+// without a database.
+//
+// Cookies are encrypted and authenticated with AES-256-GCM, using keys derived from
+// [SessionConfig.Keys] with HMAC-SHA256 and the cookie name bound as additional data, so they
+// can be neither read nor tampered with by clients. Handlers access the [Session] for a request
+// through [SessionFromContext]. The cookie is only rewritten when the session changes or is
+// due for renewal.
+type SessionManager struct {
+	cfg   *SessionConfig
+	aeads []cipher.AEAD
+}
+
+// Session holds the values of a single session. It is safe for concurrent use.
+type Session struct {
+	mu       sync.Mutex
+	id       string // only used with a SessionStore
+	created  time.Time
+	issued   time.Time
+	values   map[string]string
+	oldID    string // ID to delete from the store after Regenerate
+	modified bool
+	destroy  bool
+}
+
+// sessionPayload is the encrypted cookie content.
+type sessionPayload struct {
+	ID      string            `json:"id,omitempty"`
+	Created int64             `json:"c"`
+	Issued  int64             `json:"i"`
+	Values  map[string]string `json:"v,omitempty"`
+}
+
+type sessionKey struct{}
+
+// NewSessionManager creates a new SessionManager with the provided configuration.
+func NewSessionManager(cfg *SessionConfig) (*SessionManager, error) {
+	copy := *cfg
+
+	if len(copy.Keys) == 0 {
+		return nil, fmt.Errorf("at least one session key must be provided")
+	}
+	var aeads []cipher.AEAD
+	for i, key := range copy.Keys {
+		if len(key) < 32 {
+			return nil, fmt.Errorf("session key %d must be at least 32 bytes", i)
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("xhttp session encryption"))
+		block, err := aes.NewCipher(mac.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		aeads = append(aeads, aead)
+	}
+
+	// set defaults
+
+	if copy.CookieName == "" {
+		if copy.InsecureCookie {
+			copy.CookieName = "session"
+		} else {
+			copy.CookieName = DefaultSessionCookieName
+		}
+	}
+	if copy.InsecureCookie && strings.HasPrefix(copy.CookieName, "__Host-") {
+		return nil, fmt.Errorf("cookie name %q requires a secure cookie", copy.CookieName)
+	}
+	if copy.SameSite == 0 {
+		copy.SameSite = http.SameSiteLaxMode
+	}
+	if copy.IdleTimeout <= 0 {
+		copy.IdleTimeout = DefaultSessionIdleTimeout
+	}
+	if copy.MaxAge <= 0 {
+		copy.MaxAge = DefaultSessionMaxAge
+	}
+
+	return &SessionManager{cfg: &copy, aeads: aeads}, nil
+}
+
+// Middleware loads the session for each request into the context, and writes the session
+// cookie before the response header is sent.
+func (sm *SessionManager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := sm.load(r)
+		if err != nil {
+			Error(r.Context(), w, fmt.Errorf("failed to load session: %w", err))
+			return
+		}
+		w.Header().Add("Vary", "Cookie")
+		ctx := context.WithValue(r.Context(), sessionKey{}, s)
+		sw := &sessionWriter{ResponseWriter: w, commit: func() { sm.save(ctx, w, s) }}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		sw.commitOnce()
+	})
+}
+
+// SessionFromContext returns the request's [Session], or nil if [SessionManager.Middleware]
+// is not in use.
+func SessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+// Get returns the value for key, or "" if not set.
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// Set sets the value for key.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.modified = true
+}
+
+// Delete removes key from the session.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.modified = true
+	}
+}
+
+// Destroy clears the session and expires its cookie, e.g. on logout.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values = make(map[string]string)
+	s.destroy = true
+}
+
+// Regenerate resets the session lifetime and, with a [SessionStore], its ID, keeping the
+// values. Call it on login to prevent session fixation.
+func (s *Session) Regenerate() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.id != "" {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+		if s.oldID == "" {
+			s.oldID = s.id
+		}
+		s.id = id
+	}
+	s.created = time.Now()
+	s.modified = true
+	s.destroy = false
+	return nil
+}
+
+// load decodes the session cookie, returning a fresh session if it is missing, invalid, or expired.
+func (sm *SessionManager) load(r *http.Request) (*Session, error) {
+	now := time.Now()
+	fresh := func() (*Session, error) {
+		s := &Session{created: now, values: make(map[string]string)}
+		if sm.cfg.Store != nil {
+			id, err := newSessionID()
+			if err != nil {
+				return nil, err
+			}
+			s.id = id
+		}
+		return s, nil
+	}
+
+	cookie, err := r.Cookie(sm.cfg.CookieName)
+	if err != nil {
+		return fresh()
+	}
+	p, ok := sm.decode(cookie.Value)
+	if !ok {
+		return fresh()
+	}
+	s := &Session{id: p.ID, created: time.Unix(p.Created, 0), issued: time.Unix(p.Issued, 0), values: p.Values}
+	if now.Sub(s.issued) > sm.cfg.IdleTimeout || now.Sub(s.created) > sm.cfg.MaxAge {
+		return fresh()
+	}
+
+	if sm.cfg.Store != nil {
+		if s.id == "" {
+			return fresh()
+		}
+		values, err := sm.cfg.Store.Load(r.Context(), s.id)
+		if err != nil {
+			return nil, err
+		}
+		if values == nil { // revoked or expired server-side
+			return fresh()
+		}
+		s.values = values
+	}
+	if s.values == nil {
+		s.values = make(map[string]string)
+	}
+	return s, nil
+}
+
+// save writes the session cookie if the session changed, was destroyed, or is due for renewal.
+func (sm *SessionManager) save(ctx context.Context, w http.ResponseWriter, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store := sm.cfg.Store
+	if s.oldID != "" && store != nil {
+		if err := store.Delete(ctx, s.oldID); err != nil {
+			logError(ctx, fmt.Errorf("failed to delete regenerated session: %w", err))
+		}
+	}
+
+	if s.destroy {
+		if store != nil && s.id != "" {
+			if err := store.Delete(ctx, s.id); err != nil {
+				logError(ctx, fmt.Errorf("failed to delete session: %w", err))
+			}
+		}
+		if !s.issued.IsZero() {
+			http.SetCookie(w, sm.cookie("", -1))
+		}
+		return
+	}
+
+	now := time.Now()
+	renew := !s.issued.IsZero() && now.Sub(s.issued) > sm.cfg.IdleTimeout/2
+	if !s.modified && !renew {
+		return
+	}
+	if s.issued.IsZero() && len(s.values) == 0 {
+		return // don't hand out cookies for empty sessions
+	}
+
+	expiry := now.Add(sm.cfg.IdleTimeout)
+	if absolute := s.created.Add(sm.cfg.MaxAge); absolute.Before(expiry) {
+		expiry = absolute
+	}
+	p := sessionPayload{ID: s.id, Created: s.created.Unix(), Issued: now.Unix()}
+	if store != nil {
+		if err := store.Save(ctx, s.id, s.values, expiry); err != nil {
+			logError(ctx, fmt.Errorf("failed to save session: %w", err))
+			return
+		}
+	} else {
+		p.Values = s.values
+	}
+
+	value, err := sm.encode(p)
+	if err != nil {
+		logError(ctx, fmt.Errorf("failed to encode session: %w", err))
+		return
+	}
+	if len(sm.cfg.CookieName)+len(value) > maxCookieSize {
+		logError(ctx, fmt.Errorf("session cookie too large (%d bytes), use a SessionStore", len(value)))
+		return
+	}
+	http.SetCookie(w, sm.cookie(value, int(time.Until(expiry).Seconds())))
+}
+
+func (sm *SessionManager) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     sm.cfg.CookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   !sm.cfg.InsecureCookie,
+		HttpOnly: true,
+		SameSite: sm.cfg.SameSite,
+	}
+}
+
+func (sm *SessionManager) encode(p sessionPayload) (string, error) {
+	plain, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	aead := sm.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plain, []byte(sm.cfg.CookieName))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (sm *SessionManager) decode(value string) (sessionPayload, bool) {
+	var p sessionPayload
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return p, false
+	}
+	for _, aead := range sm.aeads {
+		if len(sealed) < aead.NonceSize() {
+			return p, false
+		}
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(sm.cfg.CookieName))
+		if err != nil {
+			continue
+		}
+		return p, json.Unmarshal(plain, &p) == nil
+	}
+	return p, false
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// sessionWriter runs commit right before the response header is written, since cookies
+// can't be set after that.
+type sessionWriter struct {
+	http.ResponseWriter
+	commit    func()
+	committed bool
+}
+
+func (sw *sessionWriter) commitOnce() {
+	if !sw.committed {
+		sw.committed = true
+		sw.commit()
+	}
+}
+
+func (sw *sessionWriter) WriteHeader(code int) {
+	sw.commitOnce()
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *sessionWriter) Write(p []byte) (int, error) {
+	sw.commitOnce()
+	return sw.ResponseWriter.Write(p)
+}
+
+func (sw *sessionWriter) Flush() {
+	sw.commitOnce()
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+// Unwrap allows [http.ResponseController] to reach the underlying writer.
+func (sw *sessionWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// MemorySessionStore is an in-memory [SessionStore], for single-process apps and tests.
+// Sessions are lost on restart.
+type MemorySessionStore struct {
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	lastSweep time.Time
+}
+
+type memorySession struct {
+	values map[string]string
+	expiry time.Time
+}
+
+// NewMemorySessionStore creates a new, empty MemorySessionStore.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+// Load implements [SessionStore].
+func (ms *MemorySessionStore) Load(_ context.Context, id string) (map[string]string, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	s, ok := ms.sessions[id]
+	if !ok || time.Now().After(s.expiry) {
+		delete(ms.sessions, id)
+		return nil, nil
+	}
+	return maps.Clone(s.values), nil
+}
+
+// Save implements [SessionStore]. Expired sessions are swept lazily, at most once a minute.
+func (ms *MemorySessionStore) Save(_ context.Context, id string, values map[string]string, expiry time.Time) error {
+	if id == "" {
+		return errors.New("empty session id")
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if now := time.Now(); now.Sub(ms.lastSweep) > time.Minute {
+		ms.lastSweep = now
+		for k, s := range ms.sessions {
+			if now.After(s.expiry) {
+				delete(ms.sessions, k)
+			}
+		}
+	}
+	ms.sessions[id] = memorySession{values: maps.Clone(values), expiry: expiry}
+	return nil
+}
+
+// Delete implements [SessionStore].
+func (ms *MemorySessionStore) Delete(_ context.Context, id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.sessions, id)
+	return nil
+}
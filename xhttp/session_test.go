@@ -0,0 +1,164 @@
+package xhttp
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testSessionKey = bytes.Repeat([]byte("s"), 32)
+
+func TestNewSessionManagerValidation(t *testing.T) {
+	if _, err := NewSessionManager(&SessionConfig{}); err == nil {
+		t.Fatal("expected error without keys")
+	}
+	if _, err := NewSessionManager(&SessionConfig{Keys: [][]byte{[]byte("short")}}); err == nil {
+		t.Fatal("expected error for short key")
+	}
+}
+
+// sessionRequest runs h through sm with the given cookie, returning the response cookie if any.
+func sessionRequest(t *testing.T, sm *SessionManager, cookie *http.Cookie, h func(s *Session)) *http.Cookie {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	sm.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h(SessionFromContext(r.Context()))
+		w.Write([]byte("ok"))
+	})).ServeHTTP(rec, r)
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sm.cfg.CookieName {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestSessionRoundTrip(t *testing.T) {
+	sm, _ := NewSessionManager(&SessionConfig{Keys: [][]byte{testSessionKey}})
+
+	if c := sessionRequest(t, sm, nil, func(s *Session) {}); c != nil {
+		t.Fatal("empty session should not set a cookie")
+	}
+
+	cookie := sessionRequest(t, sm, nil, func(s *Session) { s.Set("user", "alice") })
+	if cookie == nil || !cookie.Secure || !cookie.HttpOnly || cookie.MaxAge <= 0 {
+		t.Fatalf("unexpected cookie: %+v", cookie)
+	}
+	if bytes.Contains([]byte(cookie.Value), []byte("alice")) {
+		t.Fatal("cookie should be encrypted")
+	}
+
+	var got string
+	if c := sessionRequest(t, sm, cookie, func(s *Session) { got = s.Get("user") }); c != nil {
+		t.Fatal("unchanged session should not rewrite the cookie")
+	}
+	if got != "alice" {
+		t.Fatalf("want alice, got %q", got)
+	}
+
+	// tampering yields a fresh session
+	tampered := *cookie
+	tampered.Value = cookie.Value[:len(cookie.Value)-2] + "AA"
+	sessionRequest(t, sm, &tampered, func(s *Session) { got = s.Get("user") })
+	if got != "" {
+		t.Fatal("tampered cookie should not decode")
+	}
+
+	// destroy expires the cookie
+	c := sessionRequest(t, sm, cookie, func(s *Session) { s.Destroy() })
+	if c == nil || c.MaxAge >= 0 {
+		t.Fatalf("destroy should expire the cookie, got %+v", c)
+	}
+}
+
+func TestSessionKeyRotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte("o"), 32)
+	sm, _ := NewSessionManager(&SessionConfig{Keys: [][]byte{oldKey}})
+	cookie := sessionRequest(t, sm, nil, func(s *Session) { s.Set("k", "v") })
+
+	rotated, _ := NewSessionManager(&SessionConfig{Keys: [][]byte{testSessionKey, oldKey}})
+	var got string
+	sessionRequest(t, rotated, cookie, func(s *Session) { got = s.Get("k") })
+	if got != "v" {
+		t.Fatal("rotated manager should decode cookies from old keys")
+	}
+
+	dropped, _ := NewSessionManager(&SessionConfig{Keys: [][]byte{testSessionKey}})
+	sessionRequest(t, dropped, cookie, func(s *Session) { got = s.Get("k") })
+	if got != "" {
+		t.Fatal("dropped key should no longer decode")
+	}
+}
+
+func TestSessionExpiryAndRenewal(t *testing.T) {
+	sm, _ := NewSessionManager(&SessionConfig{Keys: [][]byte{testSessionKey}, IdleTimeout: time.Hour})
+	now := time.Now()
+
+	encode := func(created, issued time.Time) *http.Cookie {
+		v, err := sm.encode(sessionPayload{Created: created.Unix(), Issued: issued.Unix(), Values: map[string]string{"k": "v"}})
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		return &http.Cookie{Name: sm.cfg.CookieName, Value: v}
+	}
+
+	var got string
+	sessionRequest(t, sm, encode(now, now.Add(-2*time.Hour)), func(s *Session) { got = s.Get("k") })
+	if got != "" {
+		t.Fatal("idle session should have expired")
+	}
+	sessionRequest(t, sm, encode(now.Add(-8*24*time.Hour), now), func(s *Session) { got = s.Get("k") })
+	if got != "" {
+		t.Fatal("session past MaxAge should have expired")
+	}
+
+	c := sessionRequest(t, sm, encode(now, now.Add(-45*time.Minute)), func(s *Session) { got = s.Get("k") })
+	if got != "v" || c == nil {
+		t.Fatal("session past half its idle timeout should be renewed")
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	store := NewMemorySessionStore()
+	sm, _ := NewSessionManager(&SessionConfig{Keys: [][]byte{testSessionKey}, Store: store})
+
+	cookie := sessionRequest(t, sm, nil, func(s *Session) { s.Set("user", "bob") })
+	if cookie == nil || len(store.sessions) != 1 {
+		t.Fatal("session should be saved to the store")
+	}
+
+	var got string
+	sessionRequest(t, sm, cookie, func(s *Session) { got = s.Get("user") })
+	if got != "bob" {
+		t.Fatalf("want bob, got %q", got)
+	}
+
+	// regenerate moves the session to a new ID
+	newCookie := sessionRequest(t, sm, cookie, func(s *Session) {
+		if err := s.Regenerate(); err != nil {
+			t.Fatalf("regenerate: %v", err)
+		}
+	})
+	if newCookie == nil || len(store.sessions) != 1 {
+		t.Fatal("regenerate should replace the stored session")
+	}
+	sessionRequest(t, sm, cookie, func(s *Session) { got = s.Get("user") })
+	if got != "" {
+		t.Fatal("old session ID should be revoked")
+	}
+	sessionRequest(t, sm, newCookie, func(s *Session) { got = s.Get("user") })
+	if got != "bob" {
+		t.Fatal("values should survive regenerate")
+	}
+
+	sessionRequest(t, sm, newCookie, func(s *Session) { s.Destroy() })
+	if len(store.sessions) != 0 {
+		t.Fatal("destroy should delete the stored session")
+	}
+}
@@ -0,0 +1,272 @@
+package xhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default values for [SSEConfig].
+const (
+	DefaultSSEHeartbeat    = 15 * time.Second
+	DefaultSSEWriteTimeout = 10 * time.Second
+)
+
+// ErrSSEClosed is returned by [SSE.Send] after the stream has been closed, either by
+// [SSE.Close] or because the client disconnected or the [Server] is shutting down.
+var ErrSSEClosed = errors.New("sse stream closed")
+
+// SSEEvent is a single Server-Sent Event. Data may span multiple lines, and at least one of
+// Data or Retry should be set, as browsers ignore events without data.
+type SSEEvent struct {
+	ID    string        // Sets the client's Last-Event-ID for reconnection. Must not contain newlines.
+	Event string        // Event type, dispatched to addEventListener. Default on the client is "message". Must not contain newlines.
+	Data  string        // Payload, sent as one "data:" line per line.
+	Retry time.Duration // Reconnection delay hint for the client. Zero to omit.
+}
+
+// SSEConfig holds configuration options for [SSE].
+type SSEConfig struct {
+	// Heartbeat is the interval between comment lines sent to keep idle streams alive through
+	// proxies and to detect dead clients. Default is 15 seconds. Negative to disable.
+	Heartbeat time.Duration
+
+	// WriteTimeout is the deadline for each write, extended before every event and heartbeat, so
+	// streams outlive [ServerConfig.WriteTimeout] while stalled clients are still dropped. Default is 10 seconds.
+	WriteTimeout time.Duration
+
+	Retry time.Duration // Reconnection delay hint sent when the stream opens. Zero to omit.
+
+	// ShutdownEvent is sent when the [Server] begins shutting down, before the stream is closed.
+	// Default is event "shutdown" with data "server is shutting down".
+	ShutdownEvent *SSEEvent
+}
+
+// SSE is a Server-Sent Events stream (text/event-stream) on a single response.
+//
+// Closing the client connection or shutting down the [Server] closes the stream and its
+// [SSE.Done] channel. On shutdown the ShutdownEvent is sent first, so clients can tell a
+// planned restart from a network error, and the handler returning lets the connection close
+// cleanly instead of being killed at [ServerConfig.ShutdownTimeout].
+//
+// Send is safe for concurrent use. Usage:
+//
+//	func events(w http.ResponseWriter, r *http.Request) {
+//		sse, err := xhttp.NewSSE(w, r, &xhttp.SSEConfig{})
+//		if err != nil {
+//			xhttp.Error(r.Context(), w, err)
+//			return
+//		}
+//		defer sse.Close()
+//		for {
+//			select {
+//			case msg := <-updates:
+//				if err := sse.Send(&xhttp.SSEEvent{Event: "update", Data: msg}); err != nil {
+//					return
+//				}
+//			case <-sse.Done():
+//				return
+//			}
+//		}
+//	}
+type SSE struct {
+	cfg *SSEConfig
+	w   http.ResponseWriter
+	rc  *http.ResponseController
+	r   *http.Request
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+	stop   chan struct{} // closed by Close to end the background goroutine
+	once   sync.Once
+}
+
+// NewSSE starts an event stream on w, sending the response headers immediately. It fails if
+// w doesn't support flushing. The caller must call [SSE.Close] when done, typically deferred.
+func NewSSE(w http.ResponseWriter, r *http.Request, cfg *SSEConfig) (*SSE, error) {
+	copy := *cfg
+
+	if copy.ShutdownEvent != nil && copy.ShutdownEvent.Data == "" && copy.ShutdownEvent.Retry == 0 {
+		return nil, fmt.Errorf("shutdown event must have data or a retry delay")
+	}
+
+	// set defaults
+
+	if copy.Heartbeat == 0 {
+		copy.Heartbeat = DefaultSSEHeartbeat
+	}
+	if copy.WriteTimeout <= 0 {
+		copy.WriteTimeout = DefaultSSEWriteTimeout
+	}
+	if copy.ShutdownEvent == nil {
+		copy.ShutdownEvent = &SSEEvent{Event: "shutdown", Data: "server is shutting down"}
+	}
+
+	s := &SSE{
+		cfg:  &copy,
+		w:    w,
+		rc:   http.NewResponseController(w),
+		r:    r,
+		done: make(chan struct{}),
+		stop: make(chan struct{}),
+	}
+
+	// the read deadline would otherwise cancel the request context once ReadTimeout passes
+	if err := s.rc.SetReadDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return nil, fmt.Errorf("failed to clear read deadline: %w", err)
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("X-Accel-Buffering", "no") // disable nginx response buffering
+	h.Del("Content-Length")
+
+	var opening strings.Builder
+	if copy.Retry > 0 {
+		writeEvent(&opening, &SSEEvent{Retry: copy.Retry})
+	} else {
+		opening.WriteString(": stream open\n\n")
+	}
+	if err := s.write(opening.String()); err != nil {
+		return nil, fmt.Errorf("failed to start event stream: %w", err)
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// run sends heartbeats and handles disconnects and server shutdown until the stream closes.
+func (s *SSE) run() {
+	var tick <-chan time.Time
+	if s.cfg.Heartbeat > 0 {
+		ticker := time.NewTicker(s.cfg.Heartbeat)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	var shutdown chan struct{}
+	if st := serverStateFrom(s.r.Context()); st != nil {
+		shutdown = st.shutdown
+	}
+
+	for {
+		select {
+		case <-tick:
+			s.mu.Lock()
+			if !s.closed && s.write(": heartbeat\n\n") != nil {
+				s.closeLocked()
+			}
+			s.mu.Unlock()
+		case <-shutdown:
+			s.mu.Lock()
+			if !s.closed {
+				var b strings.Builder
+				writeEvent(&b, s.cfg.ShutdownEvent)
+				_ = s.write(b.String())
+				s.closeLocked()
+			}
+			s.mu.Unlock()
+			return
+		case <-s.r.Context().Done():
+			s.Close()
+			return
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Send writes an event and flushes it to the client. It returns [ErrSSEClosed] once the
+// stream is closed, and closes the stream on write errors.
+func (s *SSE) Send(ev *SSEEvent) error {
+	if strings.ContainsAny(ev.ID, "\r\n\x00") || strings.ContainsAny(ev.Event, "\r\n") {
+		return fmt.Errorf("event id and type must not contain newlines")
+	}
+	var b strings.Builder
+	writeEvent(&b, ev)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrSSEClosed
+	}
+	if err := s.write(b.String()); err != nil {
+		s.closeLocked()
+		return fmt.Errorf("failed to send event: %w", err)
+	}
+	return nil
+}
+
+// SendJSON sends v encoded as JSON in the data field of an event of the given type.
+func (s *SSE) SendJSON(event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode event data: %w", err)
+	}
+	return s.Send(&SSEEvent{Event: event, Data: string(data)})
+}
+
+// LastEventID returns the Last-Event-ID sent by a reconnecting client, for resuming the stream.
+func (s *SSE) LastEventID() string {
+	return s.r.Header.Get("Last-Event-ID")
+}
+
+// Done returns a channel closed when the stream closes, because the client disconnected,
+// the [Server] began shutting down, a write failed, or [SSE.Close] was called.
+func (s *SSE) Done() <-chan struct{} {
+	return s.done
+}
+
+// Close closes the stream and stops heartbeats. The response is complete once the handler
+// returns. Safe to call multiple times.
+func (s *SSE) Close() {
+	s.mu.Lock()
+	s.closeLocked()
+	s.mu.Unlock()
+	s.once.Do(func() { close(s.stop) })
+}
+
+func (s *SSE) closeLocked() {
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+// write writes and flushes p, extending the write deadline first. The caller must hold mu or
+// have exclusive access.
+func (s *SSE) write(p string) error {
+	err := s.rc.SetWriteDeadline(time.Now().Add(s.cfg.WriteTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	if _, err := s.w.Write([]byte(p)); err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+func writeEvent(b *strings.Builder, ev *SSEEvent) {
+	if ev.ID != "" {
+		b.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Event != "" {
+		b.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+	}
+	if ev.Data != "" {
+		data := strings.ReplaceAll(strings.ReplaceAll(ev.Data, "\r\n", "\n"), "\r", "\n")
+		for line := range strings.SplitSeq(data, "\n") {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+}
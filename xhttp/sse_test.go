@@ -0,0 +1,208 @@
+package xhttp
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteEvent(t *testing.T) {
+	var b strings.Builder
+	writeEvent(&b, &SSEEvent{ID: "7", Event: "update", Data: "line one\r\nline two", Retry: 3 * time.Second})
+	want := "id: 7\nevent: update\nretry: 3000\ndata: line one\ndata: line two\n\n"
+	if b.String() != want {
+		t.Fatalf("want %q, got %q", want, b.String())
+	}
+}
+
+// readEvent reads lines up to the next blank line, skipping comments.
+func readEvent(t *testing.T, br *bufio.Reader) string {
+	t.Helper()
+	var ev strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v (got %q)", err, ev.String())
+		}
+		if line == "\n" {
+			if ev.Len() > 0 {
+				return ev.String()
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, ":") {
+			ev.WriteString(line)
+		}
+	}
+}
+
+func TestSSEStream(t *testing.T) {
+	var stream *SSE
+	opened := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sse, err := NewSSE(w, r, &SSEConfig{Retry: time.Second})
+		if err != nil {
+			t.Errorf("new sse: %v", err)
+			return
+		}
+		defer sse.Close()
+		if sse.LastEventID() != "41" {
+			t.Errorf("want Last-Event-ID 41, got %q", sse.LastEventID())
+		}
+		stream = sse
+		close(opened)
+		if err := sse.Send(&SSEEvent{ID: "42", Data: "hello"}); err != nil {
+			t.Errorf("send: %v", err)
+		}
+		if err := sse.SendJSON("obj", map[string]int{"n": 1}); err != nil {
+			t.Errorf("send json: %v", err)
+		}
+		<-sse.Done()
+	}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Last-Event-ID", "41")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	br := bufio.NewReader(resp.Body)
+	if ev := readEvent(t, br); ev != "retry: 1000\n" {
+		t.Fatalf("unexpected opening event %q", ev)
+	}
+	if ev := readEvent(t, br); ev != "id: 42\ndata: hello\n" {
+		t.Fatalf("unexpected event %q", ev)
+	}
+	if ev := readEvent(t, br); ev != "event: obj\ndata: {\"n\":1}\n" {
+		t.Fatalf("unexpected event %q", ev)
+	}
+
+	<-opened
+	resp.Body.Close() // disconnect
+	select {
+	case <-stream.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream not closed after client disconnect")
+	}
+	if err := stream.Send(&SSEEvent{Data: "late"}); !errors.Is(err, ErrSSEClosed) {
+		t.Fatalf("want ErrSSEClosed, got %v", err)
+	}
+}
+
+func TestSSEInvalidEvent(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sse, err := NewSSE(rec, httptest.NewRequest("GET", "/", nil), &SSEConfig{Heartbeat: -1})
+	if err != nil {
+		t.Fatalf("new sse: %v", err)
+	}
+	defer sse.Close()
+	if err := sse.Send(&SSEEvent{Event: "a\nb", Data: "x"}); err == nil {
+		t.Fatal("expected error for newline in event type")
+	}
+	if _, err := NewSSE(rec, httptest.NewRequest("GET", "/", nil), &SSEConfig{ShutdownEvent: &SSEEvent{Event: "bye"}}); err == nil {
+		t.Fatal("expected error for shutdown event without data")
+	}
+}
+
+func TestSSEHeartbeatOutlivesServerTimeouts(t *testing.T) {
+	srv, err := NewServer(&ServerConfig{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sse, err := NewSSE(w, r, &SSEConfig{Heartbeat: 50 * time.Millisecond})
+			if err != nil {
+				t.Errorf("new sse: %v", err)
+				return
+			}
+			defer sse.Close()
+			time.Sleep(400 * time.Millisecond)
+			sse.Send(&SSEEvent{Data: "still here"})
+			<-sse.Done()
+		}),
+		ReadTimeout:  100 * time.Millisecond,
+		WriteTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.server
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	heartbeats := 0
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("stream ended early after %d heartbeats: %v", heartbeats, err)
+		}
+		if line == ": heartbeat\n" {
+			heartbeats++
+		}
+		if line == "data: still here\n" {
+			break
+		}
+	}
+	if heartbeats < 3 {
+		t.Fatalf("want at least 3 heartbeats, got %d", heartbeats)
+	}
+}
+
+func TestSSEServerShutdown(t *testing.T) {
+	handlerDone := make(chan struct{})
+	srv, err := NewServer(&ServerConfig{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer close(handlerDone)
+			sse, err := NewSSE(w, r, &SSEConfig{})
+			if err != nil {
+				t.Errorf("new sse: %v", err)
+				return
+			}
+			defer sse.Close()
+			<-sse.Done()
+		}),
+		ShutdownTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.server
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	br.ReadString('\n') // opening comment
+
+	start := time.Now()
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- srv.Shutdown() }()
+
+	if ev := readEvent(t, br); ev != "event: shutdown\ndata: server is shutting down\n" {
+		t.Fatalf("unexpected shutdown event %q", ev)
+	}
+	<-handlerDone
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("shutdown waited for the timeout (%v)", d)
+	}
+}
@@ -0,0 +1,232 @@
+package xhttp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultStaticIndex is the default index file served by [Static].
+const DefaultStaticIndex = "index.html"
+
+// fingerprintPattern matches a hash-like segment before the extension, e.g. "app.3f9a2c1d.js"
+// or "index-B9x3kQ2a.css".
+var fingerprintPattern = regexp.MustCompile(`[.-]([A-Za-z0-9_]{8,})\.[A-Za-z0-9]+$`)
+
+// StaticConfig holds configuration options for [Static].
+type StaticConfig struct {
+	FS fs.FS // Files to serve, e.g. an embed.FS or os.DirFS. Use fs.Sub to serve a subdirectory. Required.
+
+	Index string // File served for directory paths. Default is "index.html".
+
+	// SPA serves the root Index for unknown paths without a file extension, so client-side
+	// routes like "/settings/profile" load the app. Unknown paths with an extension still 404.
+	SPA bool
+
+	// MaxAge is the Cache-Control max-age for files that aren't fingerprinted. Default is 0,
+	// sending "no-cache" so clients revalidate with the ETag on every use.
+	MaxAge time.Duration
+
+	// Fingerprinted reports whether a file name contains a content hash, so it can be cached as
+	// immutable for a year. Default matches a segment of 8 or more letters and digits, including
+	// at least one of each, before the extension, e.g. "app.3f9a2c1d.js" or "index-B9x3kQ2a.css".
+	Fingerprinted func(name string) bool
+
+	// ErrorHandler sends the 404 and 405 [Err]s, e.g. to render a custom not found page. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// Static is an [http.Handler] serving files from an [fs.FS], built for assets embedded in the
+// binary with embed.FS, where [http.FileServer] can't cache well since modification times are zero.
+//
+// Files are indexed once when created, so serve fixed sets of files, like embedded or deployed
+// assets. Each file gets a strong ETag from a hash of its content, computed once, and
+// conditional and range requests are handled by [http.ServeContent].
+//
+// Precompressed siblings, e.g. "app.js.br" and "app.js.gz" next to "app.js", are served with the
+// matching Content-Encoding to clients that accept them, so assets can be compressed at build time.
+//
+// Usage:
+//
+//	//go:embed dist
+//	var dist embed.FS
+//
+//	sub, _ := fs.Sub(dist, "dist")
+//	static, _ := xhttp.NewStatic(&xhttp.StaticConfig{FS: sub, SPA: true})
+//	mux.Handle("/", static)
+type Static struct {
+	cfg   *StaticConfig
+	files map[string]*staticFile
+}
+
+type staticFile struct {
+	etag   string
+	br, gz *staticFile // precompressed siblings, nil if absent
+}
+
+// NewStatic creates a new Static with the provided configuration, hashing every file in FS.
+func NewStatic(cfg *StaticConfig) (*Static, error) {
+	copy := *cfg
+
+	if copy.FS == nil {
+		return nil, fmt.Errorf("file system must be provided")
+	}
+	if copy.MaxAge < 0 {
+		return nil, fmt.Errorf("max age must not be negative")
+	}
+
+	// set defaults
+
+	if copy.Index == "" {
+		copy.Index = DefaultStaticIndex
+	}
+	if copy.Fingerprinted == nil {
+		copy.Fingerprinted = isFingerprinted
+	}
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	s := &Static{cfg: &copy, files: make(map[string]*staticFile)}
+	err := fs.WalkDir(copy.FS, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		etag, err := hashFile(copy.FS, name)
+		if err != nil {
+			return err
+		}
+		s.files[name] = &staticFile{etag: etag}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to index static files: %w", err)
+	}
+	for name, f := range s.files {
+		f.br, f.gz = s.files[name+".br"], s.files[name+".gz"]
+	}
+	if copy.SPA && s.files[copy.Index] == nil {
+		return nil, fmt.Errorf("SPA requires %q in the file system root", copy.Index)
+	}
+	return s, nil
+}
+
+func hashFile(fsys fs.FS, name string) (string, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16]) + `"`, nil
+}
+
+func isFingerprinted(name string) bool {
+	m := fingerprintPattern.FindStringSubmatch(name)
+	return m != nil && strings.ContainsAny(m[1], "0123456789") &&
+		strings.ContainsAny(strings.ToLower(m[1]), "abcdefghijklmnopqrstuvwxyz")
+}
+
+// ServeHTTP implements [http.Handler].
+func (s *Static) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.cfg.ErrorHandler(r.Context(), w, &Err{
+			Code: http.StatusMethodNotAllowed,
+			Msg:  "Method not allowed",
+			Err:  fmt.Errorf("static: method %s not allowed for %s", r.Method, r.URL.Path),
+		})
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	f, name := s.lookup(name)
+	if f == nil && s.cfg.SPA && path.Ext(name) == "" {
+		f, name = s.files[s.cfg.Index], s.cfg.Index
+	}
+	if f == nil {
+		s.cfg.ErrorHandler(r.Context(), w, &Err{
+			Code: http.StatusNotFound,
+			Msg:  "Not found",
+			Err:  fmt.Errorf("static: %s not found", r.URL.Path),
+		})
+		return
+	}
+
+	h := w.Header()
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+	switch {
+	case s.cfg.Fingerprinted(name):
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	case s.cfg.MaxAge > 0:
+		h.Set("Cache-Control", "public, max-age="+strconv.FormatInt(int64(s.cfg.MaxAge.Seconds()), 10))
+	default:
+		h.Set("Cache-Control", "no-cache")
+	}
+
+	// pick a precompressed sibling, with its own ETag as it's a different representation
+	served := name
+	if f.br != nil || f.gz != nil {
+		h.Add("Vary", "Accept-Encoding")
+		accept := r.Header.Get("Accept-Encoding")
+		brQ, gzQ := encodingQ(accept, "br"), encodingQ(accept, "gzip")
+		switch {
+		case f.br != nil && brQ > 0 && (f.gz == nil || brQ >= gzQ):
+			f, served = f.br, name+".br"
+			h.Set("Content-Encoding", "br")
+		case f.gz != nil && gzQ > 0:
+			f, served = f.gz, name+".gz"
+			h.Set("Content-Encoding", "gzip")
+		}
+	}
+	h.Set("ETag", f.etag)
+
+	file, err := s.cfg.FS.Open(served)
+	if err != nil {
+		Error(r.Context(), w, fmt.Errorf("static: failed to open %s: %w", served, err))
+		return
+	}
+	defer file.Close()
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(file)
+		if err != nil {
+			Error(r.Context(), w, fmt.Errorf("static: failed to read %s: %w", served, err))
+			return
+		}
+		content = bytes.NewReader(b)
+	}
+	// the modtime is ignored in favor of the ETag, it's zero for embed.FS anyway
+	http.ServeContent(w, r, name, time.Time{}, content)
+}
+
+// lookup finds the file for a cleaned path, trying the directory index for directories.
+func (s *Static) lookup(name string) (*staticFile, string) {
+	if name == "" {
+		return s.files[s.cfg.Index], s.cfg.Index
+	}
+	if f := s.files[name]; f != nil {
+		return f, name
+	}
+	index := name + "/" + s.cfg.Index
+	if f := s.files[index]; f != nil {
+		return f, index
+	}
+	return nil, name
+}
@@ -0,0 +1,153 @@
+package xhttp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testStaticFS() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":              {Data: []byte("<h1>app</h1>")},
+		"assets/app.3f9a2c1d.js":  {Data: []byte("console.log('app')")},
+		"assets/style.css":        {Data: []byte("body{}")},
+		"assets/style.css.gz":     {Data: []byte("gzipped css")},
+		"assets/style.css.br":     {Data: []byte("brotli css")},
+		"docs/index.html":         {Data: []byte("<h1>docs</h1>")},
+		"docs/settings-guide.txt": {Data: []byte("guide")},
+	}
+}
+
+func TestNewStaticValidation(t *testing.T) {
+	if _, err := NewStatic(&StaticConfig{}); err == nil {
+		t.Fatal("expected error without FS")
+	}
+	if _, err := NewStatic(&StaticConfig{FS: fstest.MapFS{"a.txt": {}}, SPA: true}); err == nil {
+		t.Fatal("expected error for SPA without index")
+	}
+}
+
+func TestIsFingerprinted(t *testing.T) {
+	tests := map[string]bool{
+		"app.3f9a2c1d.js":     true,
+		"index-B9x3kQ2a.css":  true,
+		"chunk-ABCD1234.js":   true,
+		"settings-guide.js":   false,
+		"main.js":             false,
+		"jquery-3.7.1.min.js": false,
+		"deadbeef.js":         false,
+	}
+	for name, want := range tests {
+		if got := isFingerprinted(name); got != want {
+			t.Errorf("%s: want %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestStaticServe(t *testing.T) {
+	s, err := NewStatic(&StaticConfig{FS: testStaticFS(), SPA: true})
+	if err != nil {
+		t.Fatalf("new static: %v", err)
+	}
+
+	tests := []struct {
+		name, path, accept string
+		code               int
+		body, cache, enc   string
+	}{
+		{"root index", "/", "", 200, "<h1>app</h1>", "no-cache", ""},
+		{"fingerprinted", "/assets/app.3f9a2c1d.js", "", 200, "console.log('app')", "public, max-age=31536000, immutable", ""},
+		{"brotli preferred", "/assets/style.css", "gzip, br", 200, "brotli css", "no-cache", "br"},
+		{"gzip only", "/assets/style.css", "gzip", 200, "gzipped css", "no-cache", "gzip"},
+		{"identity", "/assets/style.css", "", 200, "body{}", "no-cache", ""},
+		{"dir index", "/docs/", "", 200, "<h1>docs</h1>", "no-cache", ""},
+		{"spa route", "/settings/profile", "", 200, "<h1>app</h1>", "no-cache", ""},
+		{"missing asset", "/assets/missing.js", "", 404, "Not found\n", "", ""},
+		{"traversal", "/../../etc/shadow.txt", "", 404, "Not found\n", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			s.ServeHTTP(rec, r)
+			if rec.Code != tt.code || rec.Body.String() != tt.body {
+				t.Fatalf("want %d %q, got %d %q", tt.code, tt.body, rec.Code, rec.Body.String())
+			}
+			if got := rec.Header().Get("Cache-Control"); got != tt.cache {
+				t.Fatalf("want Cache-Control %q, got %q", tt.cache, got)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.enc {
+				t.Fatalf("want Content-Encoding %q, got %q", tt.enc, got)
+			}
+		})
+	}
+
+	// compressed representation keeps the original content type
+	r := httptest.NewRequest("GET", "/assets/style.css", nil)
+	r.Header.Set("Accept-Encoding", "br")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	if ct := rec.Header().Get("Content-Type"); ct != "text/css; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	if rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("missing Vary header")
+	}
+}
+
+func TestStaticETag(t *testing.T) {
+	s, _ := NewStatic(&StaticConfig{FS: testStaticFS(), MaxAge: time.Minute})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/assets/style.css", nil))
+	etag := rec.Header().Get("ETag")
+	if etag == "" || rec.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Fatalf("unexpected headers %v", rec.Header())
+	}
+
+	r := httptest.NewRequest("GET", "/assets/style.css", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	if rec.Header().Get("ETag") == etag {
+		t.Fatal("compressed representation should have its own ETag")
+	}
+
+	r = httptest.NewRequest("GET", "/assets/style.css", nil)
+	r.Header.Set("If-None-Match", etag)
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, r)
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("want 304, got %d", rec.Code)
+	}
+}
+
+func TestStaticErrors(t *testing.T) {
+	var got error
+	s, _ := NewStatic(&StaticConfig{
+		FS: testStaticFS(),
+		ErrorHandler: func(ctx context.Context, w http.ResponseWriter, err error) {
+			got = err
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("custom 404"))
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/settings", nil)) // no SPA fallback
+	if rec.Body.String() != "custom 404" || got == nil {
+		t.Fatalf("want custom 404, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("POST", "/", nil))
+	if e, ok := got.(*Err); !ok || e.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" {
+		t.Fatalf("want 405 Err with Allow, got %v %v", got, rec.Header())
+	}
+}
@@ -0,0 +1,209 @@
+package xhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// TimeoutConfig holds configuration options for [Timeout]. At least one of Timeout,
+// ReadTimeout, or WriteTimeout must be set.
+type TimeoutConfig struct {
+	// Timeout is the maximum duration of the handler. When it passes, the request context is
+	// canceled and, if the handler hasn't written yet, a [Err] with Code is sent. Zero to disable.
+	Timeout time.Duration
+
+	Code int // Status sent on timeout, 503 or 504. Default is 503.
+
+	// ReadTimeout overrides [ServerConfig.ReadTimeout] for the request, measured from when the
+	// middleware runs, e.g. for large uploads. Zero keeps the server's. Negative to disable.
+	ReadTimeout time.Duration
+
+	// WriteTimeout overrides [ServerConfig.WriteTimeout] for the request, measured from when the
+	// middleware runs, e.g. for slow exports. Zero keeps the server's. Negative to disable.
+	// Raise it along with Timeout if Timeout exceeds the server's WriteTimeout, or the connection
+	// is closed before the timeout response can be written.
+	WriteTimeout time.Duration
+
+	// ErrorHandler sends the timeout [Err]. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// Timeout is a middleware for per-route handler timeouts and connection deadline overrides,
+// for routes that need limits different from the server-wide ones.
+//
+// Unlike [http.TimeoutHandler], the handler runs on the request goroutine and its response is
+// not buffered, so streaming still works. After the timeout, writes from the handler fail with
+// [http.ErrHandlerTimeout] if the timeout response was sent in its place.
+//
+// Usage:
+//
+//	export, _ := xhttp.NewTimeout(&xhttp.TimeoutConfig{Timeout: 2 * time.Minute, WriteTimeout: 3 * time.Minute})
+//	mux.Handle("/export", export.Middleware(exportHandler))
+type Timeout struct {
+	cfg *TimeoutConfig
+}
+
+// NewTimeout creates a new Timeout with the provided configuration.
+func NewTimeout(cfg *TimeoutConfig) (*Timeout, error) {
+	copy := *cfg
+
+	if copy.Timeout <= 0 && copy.ReadTimeout == 0 && copy.WriteTimeout == 0 {
+		return nil, fmt.Errorf("a timeout or deadline override must be provided")
+	}
+	if copy.Code != 0 && copy.Code != http.StatusServiceUnavailable && copy.Code != http.StatusGatewayTimeout {
+		return nil, fmt.Errorf("timeout code must be 503 or 504, got %d", copy.Code)
+	}
+
+	// set defaults
+
+	if copy.Code == 0 {
+		copy.Code = http.StatusServiceUnavailable
+	}
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	return &Timeout{cfg: &copy}, nil
+}
+
+// Middleware applies the deadlines and timeout to requests before calling next.
+func (t *Timeout) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if d := t.cfg.ReadTimeout; d != 0 {
+			setDeadline(rc.SetReadDeadline, d)
+		}
+		if d := t.cfg.WriteTimeout; d != 0 {
+			setDeadline(rc.SetWriteDeadline, d)
+		}
+		if t.cfg.Timeout <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), t.cfg.Timeout)
+		defer cancel()
+
+		tw := &timeoutWriter{w: w, h: w.Header().Clone(), ctx: ctx}
+		tw.send = func() {
+			t.cfg.ErrorHandler(r.Context(), w, &Err{
+				Code: t.cfg.Code,
+				Msg:  "Request timed out",
+				Err:  fmt.Errorf("handler for %s %s exceeded %v: %w", r.Method, r.URL.Path, t.cfg.Timeout, ctx.Err()),
+			})
+		}
+		fired := make(chan struct{})
+		stop := context.AfterFunc(ctx, func() {
+			defer close(fired)
+			tw.mu.Lock()
+			tw.expiredLocked()
+			tw.mu.Unlock()
+		})
+
+		next.ServeHTTP(tw, r.WithContext(ctx))
+
+		if !stop() {
+			<-fired // the timeout response may still be in progress
+		}
+		tw.finish()
+	})
+}
+
+// setDeadline sets a deadline d from now, or clears it if d is negative.
+func setDeadline(set func(time.Time) error, d time.Duration) {
+	deadline := time.Time{}
+	if d > 0 {
+		deadline = time.Now().Add(d)
+	}
+	_ = set(deadline) // http.ErrNotSupported leaves the server's deadline in place
+}
+
+// timeoutWriter guards the response so the timeout response and late handler writes don't
+// race. The handler gets its own header map, copied to the response when it first writes.
+//
+// Whichever of the timer and the handler takes the lock first after the deadline sends the
+// timeout response, so a handler reacting to the canceled context can't write in its place.
+type timeoutWriter struct {
+	w    http.ResponseWriter
+	h    http.Header
+	ctx  context.Context
+	send func() // sends the timeout response to w
+
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header { return tw.h }
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.expiredLocked() && !tw.wroteHeader {
+		tw.writeHeaderLocked(code)
+	}
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	tw.copyHeaderLocked()
+	tw.wroteHeader = code < 100 || code >= 200 // informational headers are followed by the final one
+	tw.w.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) copyHeaderLocked() {
+	dst := tw.w.Header()
+	clear(dst)
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	return tw.w.Write(p)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expiredLocked() {
+		return
+	}
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+	_ = http.NewResponseController(tw.w).Flush()
+}
+
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter { return tw.w }
+
+// finish copies the handler's headers for a handler that returned without writing.
+func (tw *timeoutWriter) finish() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if !tw.expiredLocked() && !tw.wroteHeader {
+		tw.copyHeaderLocked()
+	}
+}
+
+// expiredLocked sends the timeout response if the deadline passed before the handler wrote,
+// reporting whether the response was taken over.
+func (tw *timeoutWriter) expiredLocked() bool {
+	if !tw.timedOut && !tw.wroteHeader && errors.Is(tw.ctx.Err(), context.DeadlineExceeded) {
+		tw.timedOut = true
+		tw.send()
+		_ = http.NewResponseController(tw.w).Flush()
+	}
+	return tw.timedOut
+}
@@ -0,0 +1,139 @@
+package xhttp
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTimeoutValidation(t *testing.T) {
+	if _, err := NewTimeout(&TimeoutConfig{}); err == nil {
+		t.Fatal("expected error without a timeout or deadline")
+	}
+	if _, err := NewTimeout(&TimeoutConfig{Timeout: time.Second, Code: 500}); err == nil {
+		t.Fatal("expected error for code other than 503/504")
+	}
+}
+
+func TestTimeoutHandler(t *testing.T) {
+	writeErr := make(chan error, 1)
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "slow")
+		<-r.Context().Done()
+		_, err := w.Write([]byte("too late"))
+		writeErr <- err
+	})
+
+	tests := []struct {
+		name string
+		code int
+	}{
+		{"default 503", 0},
+		{"504", http.StatusGatewayTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			to, _ := NewTimeout(&TimeoutConfig{Timeout: 20 * time.Millisecond, Code: tt.code})
+			rec := httptest.NewRecorder()
+			rec.Header().Set("X-Before", "kept")
+			to.Middleware(slow).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+			want := tt.code
+			if want == 0 {
+				want = http.StatusServiceUnavailable
+			}
+			if rec.Code != want {
+				t.Fatalf("want %d, got %d", want, rec.Code)
+			}
+			if !strings.Contains(rec.Body.String(), "Request timed out") || strings.Contains(rec.Body.String(), "too late") {
+				t.Fatalf("unexpected body %q", rec.Body.String())
+			}
+			if rec.Header().Get("X-Before") != "kept" || rec.Header().Get("X-Handler") != "" {
+				t.Fatalf("unexpected headers %v", rec.Header())
+			}
+			if err := <-writeErr; !errors.Is(err, http.ErrHandlerTimeout) {
+				t.Fatalf("want ErrHandlerTimeout for late write, got %v", err)
+			}
+		})
+	}
+}
+
+func TestTimeoutAfterWrite(t *testing.T) {
+	to, _ := NewTimeout(&TimeoutConfig{Timeout: 20 * time.Millisecond})
+	h := to.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		<-r.Context().Done()
+		w.Write([]byte(" rest"))
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "partial rest" {
+		t.Fatalf("want streamed response untouched, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTimeoutFastHandler(t *testing.T) {
+	to, _ := NewTimeout(&TimeoutConfig{Timeout: time.Second})
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		code    int
+	}{
+		{"write", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", "fast")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("ok"))
+		}, http.StatusCreated},
+		{"no write", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", "fast")
+		}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			to.Middleware(tt.handler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+			if rec.Code != tt.code || rec.Header().Get("X-Handler") != "fast" {
+				t.Fatalf("want %d with handler header, got %d %v", tt.code, rec.Code, rec.Header())
+			}
+		})
+	}
+}
+
+func TestTimeoutWriteDeadlineOverride(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Write([]byte("export"))
+	})
+	longer, _ := NewTimeout(&TimeoutConfig{WriteTimeout: 2 * time.Second})
+	mux := http.NewServeMux()
+	mux.Handle("/default", slow)
+	mux.Handle("/export", longer.Middleware(slow))
+
+	srv, _ := NewServer(&ServerConfig{Handler: mux, WriteTimeout: 100 * time.Millisecond})
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.server
+	ts.Start()
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/export")
+	if err != nil {
+		t.Fatalf("get export: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "export" {
+		t.Fatalf("want body from overridden route, got %q", body)
+	}
+
+	if resp, err := http.Get(ts.URL + "/default"); err == nil {
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil && string(body) == "export" {
+			t.Fatal("default route should be cut off by the server write timeout")
+		}
+	}
+}
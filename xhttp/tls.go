@@ -0,0 +1,139 @@
+package xhttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"slices"
+)
+
+// TLSPolicy is a preset of TLS versions, cipher suites, and curves following the Mozilla
+// server side TLS guidelines, see https://wiki.mozilla.org/Security/Server_Side_TLS.
+type TLSPolicy string
+
+const (
+	// TLSModern accepts TLS 1.3 only, for clients from roughly 2020 on. The default.
+	TLSModern TLSPolicy = "modern"
+
+	// TLSIntermediate also accepts TLS 1.2 with forward secret AEAD cipher suites, for
+	// older clients and middleboxes that don't speak TLS 1.3 yet.
+	TLSIntermediate TLSPolicy = "intermediate"
+)
+
+// tlsIntermediateSuites are the TLS 1.2 suites of the intermediate policy, in preference order.
+// TLS 1.3 suites aren't configurable in Go and are all secure.
+var tlsIntermediateSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// tlsCurves are the key exchange groups of both policies, in preference order.
+var tlsCurves = []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256, tls.CurveP384}
+
+// TLSConfig holds TLS options for [Server], see [ServerConfig.TLS].
+type TLSConfig struct {
+	Policy TLSPolicy // Preset to start from. Default is TLSModern.
+
+	MinVersion uint16 // Overrides the policy's min version, tls.VersionTLS12 or tls.VersionTLS13.
+
+	// CipherSuites overrides the policy's TLS 1.2 cipher suites, e.g. tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256.
+	// Only suites from [tls.CipherSuites] are accepted, the insecure ones are rejected.
+	CipherSuites []uint16
+
+	CurvePreferences []tls.CurveID // Overrides the policy's key exchange groups. Default is X25519MLKEM768, X25519, P-256, P-384.
+
+	// NextProtos are extra ALPN protocols, e.g. "acme-tls/1". "h2" and "http/1.1" are added
+	// as enabled by [ServerConfig].
+	NextProtos []string
+
+	// Certificate sources, used instead of [ServerConfig.TLSCertPath] and [ServerConfig.TLSKeyPath].
+	// Only one source may be set.
+
+	CertPEM []byte // PEM encoded certificate chain, leaf first. Requires KeyPEM.
+	KeyPEM  []byte // PEM encoded private key.
+
+	PKCS12         []byte // PKCS#12 (.p12 or .pfx) bundle with the key and chain, see [LoadPKCS12].
+	PKCS12Password string
+}
+
+// tlsConfig builds the [tls.Config] for cfg, with certificates loaded if it has a source.
+// A nil cfg uses the modern policy.
+func (cfg *TLSConfig) tlsConfig() (*tls.Config, error) {
+	var copy TLSConfig
+	if cfg != nil {
+		copy = *cfg
+	}
+
+	if copy.MinVersion != 0 && copy.MinVersion != tls.VersionTLS12 && copy.MinVersion != tls.VersionTLS13 {
+		return nil, fmt.Errorf("TLS min version must be TLS 1.2 or TLS 1.3")
+	}
+	if (copy.CertPEM != nil || copy.KeyPEM != nil) && copy.PKCS12 != nil {
+		return nil, fmt.Errorf("TLS PEM and PKCS#12 sources are mutually exclusive")
+	}
+
+	// set defaults
+
+	if copy.Policy == "" {
+		copy.Policy = TLSModern
+	}
+
+	config := &tls.Config{
+		CurvePreferences: slices.Clone(tlsCurves),
+		NextProtos:       slices.Clone(copy.NextProtos),
+	}
+	switch copy.Policy {
+	case TLSModern:
+		config.MinVersion = tls.VersionTLS13
+	case TLSIntermediate:
+		config.MinVersion = tls.VersionTLS12
+		config.CipherSuites = slices.Clone(tlsIntermediateSuites)
+	default:
+		return nil, fmt.Errorf("unknown TLS policy %q", copy.Policy)
+	}
+	if copy.MinVersion != 0 {
+		config.MinVersion = copy.MinVersion
+	}
+	if copy.CurvePreferences != nil {
+		config.CurvePreferences = slices.Clone(copy.CurvePreferences)
+	}
+	if copy.CipherSuites != nil {
+		if config.MinVersion == tls.VersionTLS13 {
+			return nil, fmt.Errorf("TLS cipher suites require TLS 1.2, TLS 1.3 suites aren't configurable")
+		}
+		for _, id := range copy.CipherSuites {
+			if !secureTLS12Suite(id) {
+				return nil, fmt.Errorf("TLS cipher suite %s is insecure or not a TLS 1.2 suite", tls.CipherSuiteName(id))
+			}
+		}
+		config.CipherSuites = slices.Clone(copy.CipherSuites)
+	}
+
+	// load certificate
+	switch {
+	case copy.CertPEM != nil || copy.KeyPEM != nil:
+		cert, err := tls.X509KeyPair(copy.CertPEM, copy.KeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS PEM certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	case copy.PKCS12 != nil:
+		cert, err := LoadPKCS12(copy.PKCS12, copy.PKCS12Password)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS PKCS#12 certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func secureTLS12Suite(id uint16) bool {
+	for _, s := range tls.CipherSuites() {
+		if s.ID == id {
+			return slices.Contains(s.SupportedVersions, tls.VersionTLS12)
+		}
+	}
+	return false
+}
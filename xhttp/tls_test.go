@@ -0,0 +1,138 @@
+package xhttp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestTLSConfigPolicies(t *testing.T) {
+	modern, err := (*TLSConfig)(nil).tlsConfig()
+	if err != nil {
+		t.Fatalf("modern: %v", err)
+	}
+	if modern.MinVersion != tls.VersionTLS13 || modern.CipherSuites != nil {
+		t.Fatalf("want TLS 1.3 only by default, got %x %v", modern.MinVersion, modern.CipherSuites)
+	}
+
+	inter, err := (&TLSConfig{Policy: TLSIntermediate, NextProtos: []string{"acme-tls/1"}}).tlsConfig()
+	if err != nil {
+		t.Fatalf("intermediate: %v", err)
+	}
+	if inter.MinVersion != tls.VersionTLS12 || len(inter.CipherSuites) != len(tlsIntermediateSuites) {
+		t.Fatalf("want TLS 1.2 with intermediate suites, got %x %v", inter.MinVersion, inter.CipherSuites)
+	}
+	if !slices.Equal(inter.CurvePreferences, tlsCurves) || !slices.Equal(inter.NextProtos, []string{"acme-tls/1"}) {
+		t.Fatalf("unexpected curves %v or protos %v", inter.CurvePreferences, inter.NextProtos)
+	}
+
+	custom, err := (&TLSConfig{
+		MinVersion:       tls.VersionTLS12,
+		CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		CurvePreferences: []tls.CurveID{tls.X25519},
+	}).tlsConfig()
+	if err != nil {
+		t.Fatalf("custom: %v", err)
+	}
+	if custom.MinVersion != tls.VersionTLS12 || len(custom.CipherSuites) != 1 || len(custom.CurvePreferences) != 1 {
+		t.Fatalf("overrides not applied: %+v", custom)
+	}
+}
+
+func TestTLSConfigValidation(t *testing.T) {
+	certPEM, _ := os.ReadFile("testdata/leaf.pem")
+	keyPEM, _ := os.ReadFile("testdata/leaf.key")
+	p12, _ := os.ReadFile("testdata/aes.p12")
+
+	tests := []struct {
+		name string
+		cfg  TLSConfig
+	}{
+		{"unknown policy", TLSConfig{Policy: "old"}},
+		{"min version", TLSConfig{MinVersion: tls.VersionTLS11}},
+		{"suites with TLS 1.3", TLSConfig{CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}}},
+		{"insecure suite", TLSConfig{Policy: TLSIntermediate, CipherSuites: []uint16{tls.TLS_RSA_WITH_RC4_128_SHA}}},
+		{"TLS 1.3 suite", TLSConfig{Policy: TLSIntermediate, CipherSuites: []uint16{tls.TLS_AES_128_GCM_SHA256}}},
+		{"PEM without key", TLSConfig{CertPEM: certPEM}},
+		{"both sources", TLSConfig{CertPEM: certPEM, KeyPEM: keyPEM, PKCS12: p12, PKCS12Password: "secret"}},
+		{"wrong password", TLSConfig{PKCS12: p12, PKCS12Password: "nope"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.cfg.tlsConfig(); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+
+	// server level sources
+	if _, err := NewServer(&ServerConfig{Handler: noopHandler(), TLS: &TLSConfig{}}); err == nil {
+		t.Fatal("expected error for TLS options without UseTLS")
+	}
+	_, err := NewServer(&ServerConfig{
+		Handler: noopHandler(), UseTLS: true, TLSCertPath: "cert.pem", TLSKeyPath: "key.pem",
+		TLS: &TLSConfig{CertPEM: certPEM, KeyPEM: keyPEM},
+	})
+	if err == nil {
+		t.Fatal("expected error for paths and PEM")
+	}
+}
+
+func TestServerTLSFromMemory(t *testing.T) {
+	certPEM, _ := os.ReadFile("testdata/leaf.pem")
+	keyPEM, _ := os.ReadFile("testdata/leaf.key")
+	p12, _ := os.ReadFile("testdata/aes.p12")
+	ca, _ := os.ReadFile("testdata/ca.pem")
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca)
+
+	sources := map[string]*TLSConfig{
+		"pem":    {CertPEM: certPEM, KeyPEM: keyPEM},
+		"pkcs12": {Policy: TLSIntermediate, PKCS12: p12, PKCS12Password: "secret"},
+	}
+	for name, tc := range sources {
+		t.Run(name, func(t *testing.T) {
+			srv, err := NewServer(&ServerConfig{Handler: noopHandler(), UseTLS: true, TLS: tc})
+			if err != nil {
+				t.Fatalf("new server: %v", err)
+			}
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			go srv.server.ServeTLS(ln, "", "")
+			defer srv.server.Close()
+
+			// a TLS 1.2 only client is served by the intermediate policy alone
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{RootCAs: roots, MaxVersion: tls.VersionTLS12},
+				ForceAttemptHTTP2: true,
+			}}
+			resp, err := client.Get("https://localhost:" + portOf(ln) + "/")
+			if tc.Policy != TLSIntermediate {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatal("want TLS 1.2 rejected by the modern policy")
+				}
+				client.Transport.(*http.Transport).TLSClientConfig.MaxVersion = 0
+				resp, err = client.Get("https://localhost:" + portOf(ln) + "/")
+			}
+			if err != nil {
+				t.Fatalf("get: %v", err)
+			}
+			resp.Body.Close()
+			if resp.ProtoMajor != 2 {
+				t.Fatalf("want HTTP/2 negotiated by ALPN, got %s", resp.Proto)
+			}
+		})
+	}
+}
+
+func portOf(ln net.Listener) string {
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
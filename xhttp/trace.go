@@ -0,0 +1,428 @@
+package xhttp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	mrand "math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+// Default values for [TracerConfig].
+const (
+	DefaultTraceBatchSize     = 512
+	DefaultTraceQueueSize     = 2048
+	DefaultTraceFlushInterval = 5 * time.Second
+)
+
+// TraceID identifies a trace, shared by all its spans.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether the ID is non-zero.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// IsValid reports whether the ID is non-zero.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// SpanContext is the part of a span propagated across process boundaries, see
+// https://www.w3.org/TR/trace-context/.
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool   // Whether the trace is recorded and exported.
+	TraceState string // Vendor-specific tracestate header, propagated as is.
+}
+
+// IsValid reports whether both IDs are non-zero.
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// Traceparent formats the span context as a traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value. Versions after 00 are parsed as 00,
+// ignoring any additional fields, as the spec requires.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, fmt.Errorf("malformed traceparent %q", s)
+	}
+	version, err := decodeLowerHex(s[:2], 1)
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return sc, fmt.Errorf("invalid traceparent version in %q", s)
+	}
+	traceID, err1 := decodeLowerHex(s[3:35], 16)
+	spanID, err2 := decodeLowerHex(s[36:52], 8)
+	flags, err3 := decodeLowerHex(s[53:55], 1)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return sc, fmt.Errorf("malformed traceparent %q", s)
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("traceparent %q has a zero ID", s)
+	}
+	return sc, nil
+}
+
+// decodeLowerHex decodes n bytes of lowercase hex, as uppercase is invalid in traceparent.
+func decodeLowerHex(s string, n int) ([]byte, error) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, fmt.Errorf("invalid hex %q", s)
+	}
+	return hex.DecodeString(s)
+}
+
+// SpanKind describes the relationship of a span to its parent and children. Values match OTLP.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1 // An operation within the process.
+	SpanKindServer   SpanKind = 2 // Handling an incoming request.
+	SpanKindClient   SpanKind = 3 // Making an outgoing request.
+)
+
+// SpanData is an ended span, as passed to a [SpanExporter].
+type SpanData struct {
+	Name        string
+	Kind        SpanKind
+	SpanContext SpanContext
+	Parent      SpanID // Zero for root spans.
+	Start, End  time.Time
+	Attributes  map[string]any // Values are strings, bools, ints, int64s, or float64s.
+	Err         string         // Non-empty if the operation failed.
+}
+
+// SpanExporter sends ended, sampled spans to a tracing backend, e.g. [OTLPExporter].
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+}
+
+// TracerConfig holds configuration options for [Tracer].
+type TracerConfig struct {
+	// Exporter receives sampled spans in batches. Default is nil, only propagating trace context
+	// and adding trace IDs to logs.
+	Exporter SpanExporter
+
+	// SampleRatio is the fraction of new traces sampled. Requests continuing a trace follow the
+	// caller's decision instead. Default is 1. Negative to sample none.
+	SampleRatio float64
+
+	BatchSize     int           // Max spans per export. Default is 512.
+	QueueSize     int           // Max spans waiting for export, more are dropped. Default is 2048.
+	FlushInterval time.Duration // Max duration spans wait for export. Default is 5 seconds.
+
+	OnExportError func(err error) // Called when an export fails. Default discards errors.
+}
+
+// Tracer creates spans, propagates W3C Trace Context (traceparent and tracestate headers),
+// and batches ended spans to an exporter in the background.
+//
+// Spans and their IDs are placed in the request context, where [xlog] context functions pick
+// up the IDs, so log lines from [Error] and handlers can be correlated with traces.
+//
+// Usage:
+//
+//	exporter, _ := xhttp.NewOTLPExporter(&xhttp.OTLPExporterConfig{ServiceName: "myapp"})
+//	tracer, _ := xhttp.NewTracer(&xhttp.TracerConfig{Exporter: exporter})
+//	defer tracer.Shutdown(context.Background())
+//	handler = tracer.Middleware(mux)
+//
+//	// in handlers
+//	ctx, span := tracer.Start(r.Context(), "load user")
+//	defer span.End()
+//	xhttp.InjectTraceContext(ctx, outgoing.Header) // propagate to upstream calls
+type Tracer struct {
+	cfg *TracerConfig
+
+	mu     sync.Mutex
+	queue  []SpanData
+	closed bool
+
+	flush chan struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+// NewTracer creates a new Tracer with the provided configuration. Call [Tracer.Shutdown]
+// to export remaining spans.
+func NewTracer(cfg *TracerConfig) (*Tracer, error) {
+	copy := *cfg
+
+	if copy.SampleRatio > 1 {
+		return nil, fmt.Errorf("sample ratio must not exceed 1")
+	}
+	if copy.BatchSize < 0 || copy.QueueSize < 0 || copy.FlushInterval < 0 {
+		return nil, fmt.Errorf("batch size, queue size, and flush interval must not be negative")
+	}
+
+	// set defaults
+
+	if copy.SampleRatio == 0 {
+		copy.SampleRatio = 1
+	}
+	if copy.BatchSize == 0 {
+		copy.BatchSize = DefaultTraceBatchSize
+	}
+	if copy.QueueSize == 0 {
+		copy.QueueSize = DefaultTraceQueueSize
+	}
+	if copy.FlushInterval == 0 {
+		copy.FlushInterval = DefaultTraceFlushInterval
+	}
+	if copy.OnExportError == nil {
+		copy.OnExportError = func(error) {}
+	}
+
+	t := &Tracer{cfg: &copy, flush: make(chan struct{}, 1), done: make(chan struct{})}
+	if copy.Exporter != nil {
+		t.wg.Add(1)
+		go t.run()
+	}
+	return t, nil
+}
+
+// Middleware starts a server span for each request, continuing the caller's trace if the
+// request has a valid traceparent header. The span is named after the method and route
+// pattern, and marked failed for 5xx responses.
+func (t *Tracer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var parent SpanContext
+		if sc, err := ParseTraceparent(r.Header.Get("traceparent")); err == nil {
+			parent = sc
+			parent.TraceState = strings.Join(r.Header.Values("tracestate"), ",")
+		}
+		ctx, span := t.start(r.Context(), parent, r.Method, SpanKindServer)
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("url.path", r.URL.Path)
+
+		sw := &statusWriter{ResponseWriter: w}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(sw, r) // a ServeMux sets r.Pattern
+
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		span.SetAttribute("http.response.status_code", status)
+		if pattern := r.Pattern; pattern != "" {
+			// the route without the pattern's method, which differs for HEAD requests to GET routes
+			_, host, path := splitPattern(pattern)
+			route := host + path
+			span.mu.Lock()
+			span.name = r.Method + " " + route
+			span.mu.Unlock()
+			span.SetAttribute("http.route", route)
+		}
+		if status >= 500 {
+			span.SetError(fmt.Errorf("%d %s", status, http.StatusText(status)))
+		}
+		span.End()
+	})
+}
+
+// Start starts an internal span, a child of the span in ctx if any, returning a context
+// holding it. The caller must call [Span.End].
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	return t.start(ctx, SpanFromContext(ctx).SpanContext(), name, SpanKindInternal)
+}
+
+func (t *Tracer) start(ctx context.Context, parent SpanContext, name string, kind SpanKind) (context.Context, *Span) {
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now()}
+	if parent.IsValid() {
+		s.sc = parent
+		s.parent = parent.SpanID
+	} else {
+		rand.Read(s.sc.TraceID[:])
+		s.sc.Sampled = mrand.Float64() < t.cfg.SampleRatio
+	}
+	rand.Read(s.sc.SpanID[:])
+	ctx = context.WithValue(ctx, spanKey{}, s)
+	return xlog.WithTraceIDs(ctx, s.sc.TraceID.String(), s.sc.SpanID.String()), s
+}
+
+func (t *Tracer) enqueue(s SpanData) {
+	if t.cfg.Exporter == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || len(t.queue) >= t.cfg.QueueSize {
+		return
+	}
+	t.queue = append(t.queue, s)
+	if len(t.queue) >= t.cfg.BatchSize {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(t.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		case <-t.flush:
+		}
+		t.export(context.Background())
+	}
+}
+
+// export sends queued spans in batches.
+func (t *Tracer) export(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		n := min(len(t.queue), t.cfg.BatchSize)
+		batch := t.queue[:n:n]
+		t.queue = t.queue[n:]
+		t.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		if err := t.cfg.Exporter.ExportSpans(ctx, batch); err != nil {
+			t.cfg.OnExportError(err)
+			return err
+		}
+	}
+}
+
+// Shutdown stops the background export and exports remaining spans, until ctx is done.
+// Spans ended afterwards are dropped.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.mu.Unlock()
+	if t.cfg.Exporter == nil {
+		return nil
+	}
+	close(t.done)
+	t.wg.Wait()
+	return t.export(ctx)
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the span placed in ctx by [Tracer.Middleware] or [Tracer.Start],
+// or nil. Span methods are no-ops on nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// InjectTraceContext sets the traceparent and tracestate headers of an outgoing request
+// from the span in ctx, propagating the trace to the upstream service.
+func InjectTraceContext(ctx context.Context, h http.Header) {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	h.Set("traceparent", sc.Traceparent())
+	if sc.TraceState != "" {
+		h.Set("tracestate", sc.TraceState)
+	} else {
+		h.Del("tracestate")
+	}
+}
+
+// Span is a timed operation within a trace, see [Tracer.Start]. Safe for concurrent use.
+type Span struct {
+	tracer *Tracer
+	kind   SpanKind
+	sc     SpanContext
+	parent SpanID
+	start  time.Time
+
+	mu    sync.Mutex
+	name  string
+	attrs map[string]any
+	err   string
+	ended bool
+}
+
+// SpanContext returns the span's propagated context, or the zero SpanContext for a nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.sc
+}
+
+// SetAttribute records a key-value pair describing the operation. Values should be strings,
+// bools, ints, int64s, or float64s, others are exported formatted with fmt.
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.attrs == nil {
+		s.attrs = make(map[string]any)
+	}
+	s.attrs[key] = value
+}
+
+// SetError marks the operation as failed.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.err = err.Error()
+	s.mu.Unlock()
+}
+
+// End ends the span, queuing it for export if sampled. Calls after the first are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := SpanData{
+		Name:        s.name,
+		Kind:        s.kind,
+		SpanContext: s.sc,
+		Parent:      s.parent,
+		Start:       s.start,
+		End:         time.Now(),
+		Attributes:  maps.Clone(s.attrs),
+		Err:         s.err,
+	}
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		s.tracer.enqueue(data)
+	}
+}
@@ -0,0 +1,195 @@
+package xhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Data-Corruption/stdx/xlog"
+)
+
+// recordingExporter collects exported spans.
+type recordingExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+	err   error
+}
+
+func (e *recordingExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, spans...)
+	return e.err
+}
+
+func TestParseTraceparent(t *testing.T) {
+	sc, err := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || !sc.Sampled {
+		t.Fatalf("unexpected span context %+v", sc)
+	}
+	if got := sc.Traceparent(); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Fatalf("round trip: got %q", got)
+	}
+
+	valid := []string{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-09-future", // later versions may add fields
+	}
+	for _, s := range valid {
+		if _, err := ParseTraceparent(s); err != nil {
+			t.Errorf("%q: unexpected error %v", s, err)
+		}
+	}
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", // uppercase
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01", // zero trace ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", // zero span ID
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", // forbidden version
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-x",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",
+	}
+	for _, s := range invalid {
+		if _, err := ParseTraceparent(s); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestTracerMiddleware(t *testing.T) {
+	exp := &recordingExporter{}
+	tracer, err := NewTracer(&TracerConfig{Exporter: exp, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("new tracer: %v", err)
+	}
+
+	var inner SpanContext
+	var logTrace, logSpan string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracer.Start(r.Context(), "load user")
+		inner = span.SpanContext()
+		logTrace, logSpan = xlog.TraceIDsFromContext(ctx)
+
+		out := http.Header{}
+		InjectTraceContext(ctx, out)
+		if out.Get("traceparent") != inner.Traceparent() || out.Get("tracestate") != "vendor=abc" {
+			t.Errorf("unexpected injected headers %v", out)
+		}
+		span.End()
+	})
+	mux.HandleFunc("GET /fail", func(w http.ResponseWriter, r *http.Request) {
+		Error(r.Context(), w, errors.New("boom"))
+	})
+	h := tracer.Middleware(mux)
+
+	r := httptest.NewRequest("GET", "/users/7", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	r.Header.Set("tracestate", "vendor=abc")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/fail", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("HEAD", "/fail", nil))
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+
+	if inner.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || logTrace != inner.TraceID.String() || logSpan != inner.SpanID.String() {
+		t.Fatalf("trace not continued: %+v, logs %s %s", inner, logTrace, logSpan)
+	}
+	if len(exp.spans) != 4 {
+		t.Fatalf("want 4 spans, got %d", len(exp.spans))
+	}
+	child, server, failed, head := exp.spans[0], exp.spans[1], exp.spans[2], exp.spans[3]
+	if child.Name != "load user" || child.Kind != SpanKindInternal || child.Parent != server.SpanContext.SpanID {
+		t.Fatalf("unexpected child span %+v", child)
+	}
+	if server.Name != "GET /users/{id}" || server.Kind != SpanKindServer || server.Parent.String() != "00f067aa0ba902b7" ||
+		server.Attributes["http.route"] != "/users/{id}" || server.Attributes["http.response.status_code"] != 200 {
+		t.Fatalf("unexpected server span %+v", server)
+	}
+	if failed.Parent.IsValid() || failed.Err == "" || failed.SpanContext.TraceID == server.SpanContext.TraceID {
+		t.Fatalf("unexpected root span %+v", failed)
+	}
+	if head.Name != "HEAD /fail" || head.Attributes["http.route"] != "/fail" {
+		t.Fatalf("unexpected HEAD span %+v", head)
+	}
+}
+
+func TestTracerSampling(t *testing.T) {
+	exp := &recordingExporter{}
+	tracer, _ := NewTracer(&TracerConfig{Exporter: exp, SampleRatio: -1})
+	h := tracer.Middleware(noopHandler())
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	h.ServeHTTP(httptest.NewRecorder(), r) // parent decision wins
+	tracer.Shutdown(context.Background())
+
+	if len(exp.spans) != 1 || exp.spans[0].SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("want only the sampled parent's span, got %+v", exp.spans)
+	}
+}
+
+func TestTracerBatching(t *testing.T) {
+	exp := &recordingExporter{err: errors.New("collector down")}
+	var exportErr error
+	var mu sync.Mutex
+	tracer, _ := NewTracer(&TracerConfig{
+		Exporter:  exp,
+		BatchSize: 2,
+		OnExportError: func(err error) {
+			mu.Lock()
+			exportErr = err
+			mu.Unlock()
+		},
+	})
+	defer tracer.Shutdown(context.Background())
+
+	for range 2 {
+		_, span := tracer.Start(context.Background(), "op")
+		span.End()
+		span.End() // ignored
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		err := exportErr
+		mu.Unlock()
+		if err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("full batch was not exported")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+	if len(exp.spans) != 2 {
+		t.Fatalf("want 2 spans exported, got %d", len(exp.spans))
+	}
+}
+
+func TestSpanNil(t *testing.T) {
+	span := SpanFromContext(context.Background())
+	span.SetAttribute("k", "v")
+	span.SetError(errors.New("x"))
+	span.End()
+	if span.SpanContext().IsValid() {
+		t.Fatal("nil span should have an invalid span context")
+	}
+	h := http.Header{}
+	InjectTraceContext(context.Background(), h)
+	if len(h) != 0 {
+		t.Fatalf("unexpected headers %v", h)
+	}
+}
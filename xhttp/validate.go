@@ -0,0 +1,396 @@
+package xhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ValidateTag is the struct tag read by [Validate].
+const ValidateTag = "validate"
+
+// FieldError describes a single invalid field. Field and Msg are safe for HTTP responses.
+type FieldError struct {
+	Field string `json:"field"`   // Field path, e.g. "email" or "items[2].name". Uses json tag names when present.
+	Rule  string `json:"rule"`    // Rule that failed, e.g. "required", "min", "email".
+	Msg   string `json:"message"` // Client-safe description, e.g. "must be at least 3 characters".
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+// FieldErrors is a list of [FieldError] values. It is used as the underlying error of the
+// 422 [Err] returned by [Validate], so it can be found with [errors.As] and is rendered by
+// [ErrorJoined] and [ErrorJSON].
+type FieldErrors []*FieldError
+
+func (fe FieldErrors) Error() string {
+	msgs := make([]string, len(fe))
+	for i, e := range fe {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (fe FieldErrors) Unwrap() []error {
+	errs := make([]error, len(fe))
+	for i, e := range fe {
+		errs[i] = e
+	}
+	return errs
+}
+
+// Validate checks v, a struct or pointer to a struct, against its `validate` struct tags.
+// If any field is invalid it returns an [Err] with code 422 whose underlying error is a
+// [FieldErrors] listing every failure. Malformed tags, and rules used on fields of a kind they
+// can't check, like min on a bool, return a plain error, which [Error] reports as a 500 since
+// it is a programming mistake rather than bad input.
+//
+// Rules are comma separated and applied in order:
+//   - required: value must not be the zero value (non-empty string, slice, or map; non-nil pointer)
+//   - omitempty: skip all remaining rules when the value is the zero value
+//   - min=N / max=N: numeric bounds for numbers, length bounds for strings, slices, and maps
+//   - len=N: exact length for strings, slices, and maps
+//   - enum=a|b|c: value, formatted with fmt, must be one of the listed options
+//   - email: string must be a bare email address (no display name)
+//   - regexp=PATTERN: string must match PATTERN. Must be the last rule, it consumes the rest of the tag.
+//
+// Nested structs, pointers to structs, and slices of structs are validated recursively.
+// A tag of "-" skips the field entirely.
+//
+// Example:
+//
+//	type CreateUser struct {
+//		Name  string   `json:"name" validate:"required,min=2,max=64"`
+//		Email string   `json:"email" validate:"required,email"`
+//		Role  string   `json:"role" validate:"omitempty,enum=admin|user"`
+//		Tags  []string `json:"tags" validate:"max=8"`
+//	}
+func Validate(v any) error {
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		return errors.New("validate: expected struct, got nil")
+	}
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return fmt.Errorf("validate: nil %s", rv.Type())
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("validate: expected struct, got %s", rv.Type())
+	}
+	var fields FieldErrors
+	if err := validateStruct(rv, "", &fields); err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		return &Err{Code: http.StatusUnprocessableEntity, Msg: "Validation failed", Err: fields}
+	}
+	return nil
+}
+
+// DecodeJSON decodes the request body into v, rejecting unknown fields and trailing data,
+// then runs [Validate] on it. Decoding problems are returned as an [Err] with code 400, or 413
+// if the body was limited with [http.MaxBytesReader] and exceeded that limit.
+func DecodeJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return decodeErr(err)
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		if err == nil {
+			err = errors.New("unexpected data after JSON value")
+		}
+		return decodeErr(err)
+	}
+	return Validate(v)
+}
+
+func decodeErr(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return &Err{Code: http.StatusRequestEntityTooLarge, Msg: "Request body too large", Err: err}
+	}
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		fields := FieldErrors{{Field: typeErr.Field, Rule: "type", Msg: "must be " + typeErr.Type.String()}}
+		return &Err{Code: http.StatusBadRequest, Msg: "Malformed JSON body", Err: errors.Join(fields, err)}
+	}
+	return &Err{Code: http.StatusBadRequest, Msg: "Malformed JSON body", Err: err}
+}
+
+// rules
+
+type rule struct {
+	name string
+	arg  string
+	num  float64        // parsed arg for min, max, len
+	opts []string       // parsed arg for enum
+	re   *regexp.Regexp // compiled arg for regexp
+}
+
+type fieldRules struct {
+	index int
+	name  string
+	rules []rule
+}
+
+var ruleCache sync.Map // reflect.Type -> []fieldRules
+
+func structRules(t reflect.Type) ([]fieldRules, error) {
+	if cached, ok := ruleCache.Load(t); ok {
+		return cached.([]fieldRules), nil
+	}
+	var out []fieldRules
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		tag := sf.Tag.Get(ValidateTag)
+		if tag == "-" {
+			continue
+		}
+		rules, err := parseRules(tag)
+		if err == nil {
+			err = checkRuleKinds(sf.Type, rules)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("validate: %s.%s: %w", t, sf.Name, err)
+		}
+		out = append(out, fieldRules{index: i, name: jsonName(sf), rules: rules})
+	}
+	ruleCache.Store(t, out)
+	return out, nil
+}
+
+func parseRules(tag string) ([]rule, error) {
+	var rules []rule
+	for tag != "" {
+		var part string
+		if strings.HasPrefix(tag, "regexp=") {
+			part, tag = tag, ""
+		} else {
+			part, tag, _ = strings.Cut(tag, ",")
+		}
+		name, arg, hasArg := strings.Cut(strings.TrimSpace(part), "=")
+		r := rule{name: name, arg: arg}
+		switch name {
+		case "":
+			continue
+		case "required", "omitempty", "email":
+			if hasArg {
+				return nil, fmt.Errorf("rule %q takes no argument", name)
+			}
+		case "min", "max", "len":
+			n, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: invalid number %q", name, arg)
+			}
+			r.num = n
+		case "enum":
+			if arg == "" {
+				return nil, fmt.Errorf("rule %q requires at least one option", name)
+			}
+			r.opts = strings.Split(arg, "|")
+		case "regexp":
+			re, err := regexp.Compile(arg)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", name, err)
+			}
+			r.re = re
+		default:
+			return nil, fmt.Errorf("unknown rule %q", name)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// checkRuleKinds reports rules that can't apply to fields of type t, like min on a bool.
+// Interface fields are checked when validated, as their kind isn't known before.
+func checkRuleKinds(t reflect.Type, rules []rule) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface {
+		return nil
+	}
+	for _, r := range rules {
+		ok := true
+		switch r.name {
+		case "min", "max":
+			_, _, ok = measure(reflect.Zero(t))
+		case "len":
+			_, ok, _ = measure(reflect.Zero(t))
+		case "email", "regexp":
+			ok = t.Kind() == reflect.String
+		}
+		if !ok {
+			return fmt.Errorf("rule %q can't be used on a %s", r.name, t)
+		}
+	}
+	return nil
+}
+
+func jsonName(sf reflect.StructField) string {
+	if name, _, _ := strings.Cut(sf.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return sf.Name
+}
+
+// checking
+
+func validateStruct(rv reflect.Value, prefix string, out *FieldErrors) error {
+	fields, err := structRules(rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range fields {
+		fv := rv.Field(f.index)
+		path := f.name
+		if prefix != "" {
+			path = prefix + "." + f.name
+		}
+		if fe := checkRules(fv, f.rules); fe != nil {
+			fe.Field = path
+			*out = append(*out, fe)
+			continue // don't report nested failures for a field that is already invalid
+		}
+		if err := validateNested(fv, path, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateNested(v reflect.Value, path string, out *FieldErrors) error {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		return validateStruct(v, path, out)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := validateNested(v.Index(i), fmt.Sprintf("%s[%d]", path, i), out); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkRules returns the first failing rule as a FieldError without its Field set, or nil.
+func checkRules(v reflect.Value, rules []rule) *FieldError {
+	for _, r := range rules {
+		switch r.name {
+		case "required":
+			if v.IsZero() {
+				return &FieldError{Rule: r.name, Msg: "is required"}
+			}
+			continue
+		case "omitempty":
+			if v.IsZero() {
+				return nil
+			}
+			continue
+		}
+
+		// remaining rules look through pointers and interfaces, a nil one has nothing to check
+		for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				return nil
+			}
+			v = v.Elem()
+		}
+		if fe := checkRule(v, r); fe != nil {
+			return fe
+		}
+	}
+	return nil
+}
+
+func checkRule(v reflect.Value, r rule) *FieldError {
+	fail := func(format string, a ...any) *FieldError {
+		return &FieldError{Rule: r.name, Msg: fmt.Sprintf(format, a...)}
+	}
+	switch r.name {
+	case "min", "max", "len":
+		n, isLen, ok := measure(v)
+		if !ok {
+			return fail("cannot be checked with %s", r.name)
+		}
+		unit := ""
+		if isLen {
+			unit = " characters"
+			if v.Kind() != reflect.String {
+				unit = " items"
+			}
+		}
+		switch {
+		case r.name == "min" && n < r.num:
+			return fail("must be at least %s%s", r.arg, unit)
+		case r.name == "max" && n > r.num:
+			return fail("must be at most %s%s", r.arg, unit)
+		case r.name == "len" && (!isLen || n != r.num):
+			return fail("must be exactly %s%s", r.arg, unit)
+		}
+	case "enum":
+		s := fmt.Sprint(v.Interface())
+		for _, opt := range r.opts {
+			if s == opt {
+				return nil
+			}
+		}
+		return fail("must be one of: %s", strings.Join(r.opts, ", "))
+	case "email":
+		if v.Kind() != reflect.String {
+			return fail("must be a string")
+		}
+		s := v.String()
+		if addr, err := mail.ParseAddress(s); err != nil || addr.Address != s || addr.Name != "" {
+			return fail("must be a valid email address")
+		}
+	case "regexp":
+		if v.Kind() != reflect.String {
+			return fail("must be a string")
+		}
+		if !r.re.MatchString(v.String()) {
+			return fail("has an invalid format")
+		}
+	}
+	return nil
+}
+
+// measure returns the number used by min, max, and len. isLen reports whether it is a length.
+func measure(v reflect.Value) (n float64, isLen bool, ok bool) {
+	switch v.Kind() {
+	case reflect.String:
+		return float64(len([]rune(v.String()))), true, true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(v.Len()), true, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), false, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), false, true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), false, true
+	}
+	return 0, false, false
+}
@@ -0,0 +1,234 @@
+package xhttp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testAddress struct {
+	City string `json:"city" validate:"required"`
+}
+
+type testUser struct {
+	Name   string        `json:"name" validate:"required,min=2,max=8"`
+	Email  string        `json:"email" validate:"required,email"`
+	Role   string        `json:"role" validate:"omitempty,enum=admin|user"`
+	Age    int           `json:"age" validate:"min=18,max=130"`
+	Code   string        `json:"code" validate:"omitempty,regexp=^[a-z]{2,3}$"`
+	Tags   []string      `json:"tags" validate:"max=2"`
+	Home   *testAddress  `json:"home"`
+	Others []testAddress `json:"others"`
+}
+
+func validUser() testUser {
+	return testUser{Name: "bob", Email: "bob@example.com", Age: 30}
+}
+
+func fieldErrs(t *testing.T, err error) FieldErrors {
+	t.Helper()
+	var e *Err
+	if !errors.As(err, &e) || e.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("want 422 *Err, got %v", err)
+	}
+	var fields FieldErrors
+	if !errors.As(err, &fields) {
+		t.Fatalf("want FieldErrors in tree, got %v", err)
+	}
+	return fields
+}
+
+func TestValidateValid(t *testing.T) {
+	u := validUser()
+	u.Role = "admin"
+	u.Code = "abc"
+	u.Home = &testAddress{City: "Oslo"}
+	if err := Validate(&u); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateFieldErrors(t *testing.T) {
+	u := testUser{
+		Name:   "b",
+		Email:  "Bob <bob@example.com>",
+		Role:   "root",
+		Age:    12,
+		Code:   "ABC",
+		Tags:   []string{"a", "b", "c"},
+		Home:   &testAddress{},
+		Others: []testAddress{{City: "x"}, {}},
+	}
+	fields := fieldErrs(t, Validate(u))
+
+	want := map[string]string{
+		"name":           "min",
+		"email":          "email",
+		"role":           "enum",
+		"age":            "min",
+		"code":           "regexp",
+		"tags":           "max",
+		"home.city":      "required",
+		"others[1].city": "required",
+	}
+	if len(fields) != len(want) {
+		t.Fatalf("want %d field errors, got %d: %v", len(want), len(fields), fields)
+	}
+	for _, f := range fields {
+		if rule, ok := want[f.Field]; !ok || rule != f.Rule {
+			t.Errorf("unexpected field error %+v", f)
+		}
+	}
+}
+
+func TestValidateRequired(t *testing.T) {
+	fields := fieldErrs(t, Validate(&testUser{Age: 20}))
+	if len(fields) != 2 || fields[0].Field != "name" || fields[1].Field != "email" {
+		t.Fatalf("unexpected field errors: %v", fields)
+	}
+	if fields[0].Msg != "is required" {
+		t.Fatalf("unexpected message: %q", fields[0].Msg)
+	}
+}
+
+func TestValidateBadTag(t *testing.T) {
+	type bad struct {
+		A int `validate:"between=1"`
+	}
+	err := Validate(bad{})
+	if err == nil {
+		t.Fatal("expected error for unknown rule")
+	}
+	var e *Err
+	if errors.As(err, &e) {
+		t.Fatalf("malformed tag should not be an *Err, got %v", err)
+	}
+}
+
+func TestValidateRuleKinds(t *testing.T) {
+	type minBool struct {
+		A bool `validate:"min=1"`
+	}
+	type lenInt struct {
+		A *int `validate:"len=2"`
+	}
+	type emailSlice struct {
+		A []string `validate:"omitempty,email"`
+	}
+	type minNested struct {
+		A struct{ B int } `validate:"min=1"`
+	}
+	type ok struct {
+		A any     `validate:"min=1"` // checked when validated
+		B *string `validate:"omitempty,email,len=3"`
+	}
+	for _, v := range []any{minBool{}, lenInt{}, emailSlice{}, minNested{}} {
+		err := Validate(v)
+		var e *Err
+		if err == nil || errors.As(err, &e) {
+			t.Fatalf("%T: want a plain tag error even for zero values, got %v", v, err)
+		}
+	}
+	if err := Validate(ok{A: 3}); err != nil {
+		t.Fatalf("want valid, got %v", err)
+	}
+}
+
+func TestValidateNonStruct(t *testing.T) {
+	if err := Validate(42); err == nil {
+		t.Fatal("expected error for non-struct")
+	}
+	if err := Validate(nil); err == nil {
+		t.Fatal("expected error for nil")
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"valid", `{"name":"bob","email":"bob@example.com","age":30}`, 0},
+		{"invalid", `{"name":"bob","email":"nope","age":30}`, 422},
+		{"malformed", `{"name":`, 400},
+		{"unknown field", `{"name":"bob","extra":1}`, 400},
+		{"trailing data", `{"name":"bob","email":"bob@example.com","age":30} {}`, 400},
+		{"wrong type", `{"age":"old"}`, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			var u testUser
+			err := DecodeJSON(r, &u)
+			if tt.code == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var e *Err
+			if !errors.As(err, &e) || e.Code != tt.code {
+				t.Fatalf("want %d *Err, got %v", tt.code, err)
+			}
+		})
+	}
+}
+
+func TestDecodeJSONTooLarge(t *testing.T) {
+	rec := httptest.NewRecorder()
+	r := httptest.NewRequest("POST", "/", strings.NewReader(`{"name":"a very long name indeed"}`))
+	r.Body = http.MaxBytesReader(rec, r.Body, 8)
+	var u testUser
+	var e *Err
+	if err := DecodeJSON(r, &u); !errors.As(err, &e) || e.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("want 413 *Err, got %v", err)
+	}
+}
+
+func TestErrorJoinedWithFieldErrors(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ErrorJoined(context.Background(), rec, Validate(testUser{Name: "bob", Age: 20}))
+
+	if rec.Code != 422 {
+		t.Fatalf("want status 422, got %d", rec.Code)
+	}
+	if body := strings.TrimSpace(rec.Body.String()); body != "Validation failed; email: is required" {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
+
+func TestErrorJSONWithFieldErrors(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ErrorJSON(context.Background(), rec, Validate(testUser{Name: "bob", Age: 20}))
+
+	if rec.Code != 422 {
+		t.Fatalf("want status 422, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+		t.Fatalf("unexpected content type: %q", ct)
+	}
+	var body ErrorBody
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.Code != 422 || body.Message != "Validation failed" || len(body.Fields) != 1 || body.Fields[0].Field != "email" {
+		t.Fatalf("unexpected body: %+v", body)
+	}
+}
+
+func TestErrorJSONWithPlainErr(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ErrorJSON(context.Background(), rec, errors.New("secret"))
+
+	if rec.Code != 500 {
+		t.Fatalf("want status 500, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); strings.Contains(body, "secret") || strings.Contains(body, "fields") {
+		t.Fatalf("unexpected body: %q", body)
+	}
+}
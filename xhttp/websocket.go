@@ -0,0 +1,547 @@
+package xhttp
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Default values for [WebSocketConfig].
+const (
+	DefaultWSMaxMessageSize = 1 << 20 // 1 MiB
+	DefaultWSPingInterval   = 30 * time.Second
+	DefaultWSWriteTimeout   = 10 * time.Second
+)
+
+// MessageType is the type of a WebSocket data message.
+type MessageType int
+
+// WebSocket message types.
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+// WebSocket close codes, see RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure   = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005 // Reported when the peer's close frame has no code. Never sent.
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrWebSocketClosed is returned when writing to a [WebSocket] after its close handshake has started.
+var ErrWebSocketClosed = errors.New("websocket closed")
+
+// WebSocketCloseError is returned by [WebSocket.ReadMessage] when the peer closes the connection.
+type WebSocketCloseError struct {
+	Code   int    // Close code, [CloseNoStatus] if the peer sent none.
+	Reason string // Close reason, may be empty.
+}
+
+func (e *WebSocketCloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed by peer with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed by peer with code %d: %s", e.Code, e.Reason)
+}
+
+// WebSocketConfig holds configuration options for [WebSocketUpgrader].
+type WebSocketConfig struct {
+	// CheckOrigin reports whether to accept a handshake from the request's Origin. Default accepts
+	// requests without an Origin header and same-origin requests, preventing cross-site WebSocket hijacking.
+	CheckOrigin func(r *http.Request) bool
+
+	// Subprotocols lists supported subprotocols in order of preference. The first one the client
+	// also offers is selected, see [WebSocket.Subprotocol].
+	Subprotocols []string
+
+	MaxMessageSize int64 // Max size of a received message. Larger ones close the connection with 1009. Default is 1 MiB.
+
+	// PingInterval is the interval between pings. Connections that send nothing, not even a pong,
+	// for twice this long are closed. Default is 30 seconds. Negative to disable.
+	PingInterval time.Duration
+
+	WriteTimeout time.Duration // Deadline for each write, and for the peer to answer a close frame. Default is 10 seconds.
+
+	// ErrorHandler sends the [Err] for failed handshakes. Default is [Error].
+	ErrorHandler func(ctx context.Context, w http.ResponseWriter, err error)
+}
+
+// WebSocketUpgrader upgrades HTTP requests to RFC 6455 WebSocket connections, without dependencies.
+//
+// Received fragmented messages are reassembled, control frames are answered automatically,
+// and text messages are checked for valid UTF-8. Connections upgraded from a [Server] are
+// tracked, and sent a 1001 (going away) close frame when it shuts down.
+//
+// Usage:
+//
+//	ws, _ := xhttp.NewWebSocketUpgrader(&xhttp.WebSocketConfig{})
+//	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+//		conn, err := ws.Upgrade(w, r)
+//		if err != nil {
+//			return // the handshake error has been sent
+//		}
+//		defer conn.Close(xhttp.CloseNormalClosure, "")
+//		for {
+//			typ, msg, err := conn.ReadMessage()
+//			if err != nil {
+//				return
+//			}
+//			if err := conn.WriteMessage(typ, msg); err != nil {
+//				return
+//			}
+//		}
+//	})
+type WebSocketUpgrader struct {
+	cfg *WebSocketConfig
+}
+
+// NewWebSocketUpgrader creates a new WebSocketUpgrader with the provided configuration.
+func NewWebSocketUpgrader(cfg *WebSocketConfig) (*WebSocketUpgrader, error) {
+	copy := *cfg
+
+	if copy.MaxMessageSize < 0 {
+		return nil, fmt.Errorf("max message size must not be negative")
+	}
+
+	// set defaults
+
+	if copy.CheckOrigin == nil {
+		copy.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || isSameOrigin(r, origin)
+		}
+	}
+	if copy.MaxMessageSize == 0 {
+		copy.MaxMessageSize = DefaultWSMaxMessageSize
+	}
+	if copy.PingInterval == 0 {
+		copy.PingInterval = DefaultWSPingInterval
+	}
+	if copy.WriteTimeout <= 0 {
+		copy.WriteTimeout = DefaultWSWriteTimeout
+	}
+	if copy.ErrorHandler == nil {
+		copy.ErrorHandler = Error
+	}
+
+	return &WebSocketUpgrader{cfg: &copy}, nil
+}
+
+// Upgrade performs the WebSocket handshake and takes over the connection. On failure the error
+// is also sent to the client through ErrorHandler, so the handler should just return.
+func (u *WebSocketUpgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*WebSocket, error) {
+	if err := u.checkHandshake(w, r); err != nil {
+		u.cfg.ErrorHandler(r.Context(), w, err)
+		return nil, err
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		err = fmt.Errorf("failed to hijack connection for websocket: %w", err)
+		u.cfg.ErrorHandler(r.Context(), w, err)
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{}) // clear the server's request deadlines
+
+	ws := &WebSocket{
+		conn:        conn,
+		br:          brw.Reader,
+		cfg:         u.cfg,
+		subprotocol: u.selectSubprotocol(r),
+		state:       serverStateFrom(r.Context()),
+		msgs:        make(chan wsMessage),
+		closing:     make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n"
+	if ws.subprotocol != "" {
+		resp += "Sec-WebSocket-Protocol: " + ws.subprotocol + "\r\n"
+	}
+	conn.SetWriteDeadline(time.Now().Add(u.cfg.WriteTimeout))
+	if _, err := io.WriteString(conn, resp+"\r\n"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write websocket handshake: %w", err)
+	}
+
+	if ws.state != nil {
+		ws.state.track(ws)
+		select {
+		case <-ws.state.shutdown: // upgraded while shutting down
+			go ws.Close(CloseGoingAway, "server shutting down")
+		default:
+		}
+	}
+	go ws.readLoop()
+	if u.cfg.PingInterval > 0 {
+		go ws.pingLoop()
+	}
+	return ws, nil
+}
+
+func (u *WebSocketUpgrader) checkHandshake(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return &Err{Code: http.StatusMethodNotAllowed, Msg: "Method not allowed", Err: fmt.Errorf("websocket handshake with method %s", r.Method)}
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		return &Err{Code: http.StatusUpgradeRequired, Msg: "WebSocket upgrade required", Err: errors.New("websocket handshake without upgrade headers")}
+	}
+	if v := r.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return &Err{Code: http.StatusUpgradeRequired, Msg: "Unsupported WebSocket version", Err: fmt.Errorf("websocket handshake with version %q", v)}
+	}
+	if key, err := base64.StdEncoding.DecodeString(r.Header.Get("Sec-WebSocket-Key")); err != nil || len(key) != 16 {
+		return &Err{Code: http.StatusBadRequest, Msg: "Bad WebSocket handshake", Err: errors.New("websocket handshake with invalid key")}
+	}
+	if !u.cfg.CheckOrigin(r) {
+		return &Err{Code: http.StatusForbidden, Msg: "Cross-origin WebSocket not allowed", Err: fmt.Errorf("websocket handshake from origin %q", r.Header.Get("Origin"))}
+	}
+	return nil
+}
+
+func (u *WebSocketUpgrader) selectSubprotocol(r *http.Request) string {
+	for _, p := range u.cfg.Subprotocols {
+		if headerHasToken(r.Header, "Sec-WebSocket-Protocol", p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// WebSocket is an upgraded WebSocket connection. A single goroutine may call ReadMessage, while
+// WriteMessage and Close are safe for concurrent use.
+type WebSocket struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	cfg         *WebSocketConfig
+	subprotocol string
+	state       *serverState // nil if not upgraded from a Server
+
+	wmu       sync.Mutex // serializes writes
+	closeSent bool       // guarded by wmu
+
+	msgs    chan wsMessage
+	closing chan struct{} // closed once a close frame is sent
+	done    chan struct{} // closed once the connection is closed
+	err     error         // why the connection closed, set before done is closed
+}
+
+type wsMessage struct {
+	typ  MessageType
+	data []byte
+}
+
+// ReadMessage returns the next data message. It returns a [*WebSocketCloseError] when the peer
+// closes the connection, or another error if the connection failed or timed out.
+func (ws *WebSocket) ReadMessage() (MessageType, []byte, error) {
+	select {
+	case msg := <-ws.msgs:
+		return msg.typ, msg.data, nil
+	case <-ws.done:
+		return 0, nil, ws.err
+	}
+}
+
+// WriteMessage sends a message in a single frame. Text messages must be valid UTF-8.
+func (ws *WebSocket) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("invalid websocket message type %d", typ)
+	}
+	return ws.writeFrame(byte(typ), data)
+}
+
+// Close starts the close handshake with the given code and reason, then waits up to WriteTimeout
+// for the peer's reply before closing the connection. Safe to call multiple times.
+func (ws *WebSocket) Close(code int, reason string) error {
+	err := ws.sendClose(code, reason)
+	timer := time.NewTimer(ws.cfg.WriteTimeout)
+	defer timer.Stop()
+	select {
+	case <-ws.done:
+	case <-timer.C:
+		ws.conn.Close()
+		<-ws.done
+	}
+	if errors.Is(err, ErrWebSocketClosed) {
+		return nil
+	}
+	return err
+}
+
+// Done returns a channel closed when the connection closes.
+func (ws *WebSocket) Done() <-chan struct{} {
+	return ws.done
+}
+
+// Subprotocol returns the negotiated subprotocol, or "" if none.
+func (ws *WebSocket) Subprotocol() string {
+	return ws.subprotocol
+}
+
+// readLoop reads frames until the connection closes, answering control frames and handing
+// data messages to ReadMessage.
+func (ws *WebSocket) readLoop() {
+	var err error
+	defer func() {
+		ws.err = err
+		ws.conn.Close()
+		if ws.state != nil {
+			ws.state.untrack(ws)
+		}
+		close(ws.done)
+	}()
+	for {
+		var msg wsMessage
+		if msg, err = ws.readMessage(); err != nil {
+			return
+		}
+		select {
+		case ws.msgs <- msg:
+		case <-ws.closing: // discard data while waiting for the peer's close frame
+		}
+	}
+}
+
+func (ws *WebSocket) pingLoop() {
+	ticker := time.NewTicker(ws.cfg.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := ws.writeFrame(opPing, nil); err != nil {
+				if !errors.Is(err, ErrWebSocketClosed) {
+					ws.conn.Close()
+				}
+				return
+			}
+		case <-ws.done:
+			return
+		}
+	}
+}
+
+func (ws *WebSocket) readMessage() (wsMessage, error) {
+	var msg wsMessage
+	for {
+		fin, op, payload, err := ws.readFrame(ws.cfg.MaxMessageSize - int64(len(msg.data)))
+		if err != nil {
+			return msg, err
+		}
+		switch op {
+		case opPing:
+			if err := ws.writeFrame(opPong, payload); err != nil && !errors.Is(err, ErrWebSocketClosed) {
+				return msg, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return msg, ws.handleClose(payload)
+		case opText, opBinary:
+			if msg.typ != 0 {
+				return msg, ws.fail(CloseProtocolError, "new message before previous one finished")
+			}
+			msg.typ = MessageType(op)
+		case opContinuation:
+			if msg.typ == 0 {
+				return msg, ws.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return msg, ws.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %#x", op))
+		}
+		msg.data = append(msg.data, payload...)
+		if fin {
+			if msg.typ == TextMessage && !utf8.Valid(msg.data) {
+				return msg, ws.fail(CloseInvalidPayload, "invalid UTF-8 in text message")
+			}
+			if msg.data == nil {
+				msg.data = []byte{}
+			}
+			return msg, nil
+		}
+	}
+}
+
+// readFrame reads a single frame, failing data frames with payloads longer than limit.
+func (ws *WebSocket) readFrame(limit int64) (fin bool, op byte, payload []byte, err error) {
+	if ws.cfg.PingInterval > 0 {
+		ws.conn.SetReadDeadline(time.Now().Add(2 * ws.cfg.PingInterval))
+	}
+	var h [8]byte
+	if _, err := io.ReadFull(ws.br, h[:2]); err != nil {
+		return false, 0, nil, fmt.Errorf("websocket read failed: %w", err)
+	}
+	fin, op = h[0]&0x80 != 0, h[0]&0x0f
+	if h[0]&0x70 != 0 {
+		return false, 0, nil, ws.fail(CloseProtocolError, "reserved bits set")
+	}
+	if h[1]&0x80 == 0 {
+		return false, 0, nil, ws.fail(CloseProtocolError, "client frame not masked")
+	}
+
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(ws.br, h[:2]); err != nil {
+			return false, 0, nil, fmt.Errorf("websocket read failed: %w", err)
+		}
+		n = uint64(binary.BigEndian.Uint16(h[:2]))
+	case 127:
+		if _, err := io.ReadFull(ws.br, h[:8]); err != nil {
+			return false, 0, nil, fmt.Errorf("websocket read failed: %w", err)
+		}
+		n = binary.BigEndian.Uint64(h[:8])
+	}
+	if op >= opClose {
+		if !fin || n > 125 {
+			return false, 0, nil, ws.fail(CloseProtocolError, "invalid control frame")
+		}
+	} else if n > math.MaxInt64 || int64(n) > limit {
+		return false, 0, nil, ws.fail(CloseMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(ws.br, mask[:]); err != nil {
+		return false, 0, nil, fmt.Errorf("websocket read failed: %w", err)
+	}
+	payload = make([]byte, n)
+	if _, err := io.ReadFull(ws.br, payload); err != nil {
+		return false, 0, nil, fmt.Errorf("websocket read failed: %w", err)
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// handleClose answers the peer's close frame, returning the resulting [WebSocketCloseError].
+func (ws *WebSocket) handleClose(payload []byte) error {
+	closeErr := &WebSocketCloseError{Code: CloseNoStatus}
+	if len(payload) == 1 {
+		return ws.fail(CloseProtocolError, "invalid close frame")
+	}
+	if len(payload) >= 2 {
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) || !utf8.ValidString(closeErr.Reason) {
+			return ws.fail(CloseProtocolError, "invalid close frame")
+		}
+	}
+	ws.sendClose(closeErr.Code, "") // echo, unless we started the handshake
+	return closeErr
+}
+
+// fail sends a close frame for a protocol violation and returns an error describing it.
+func (ws *WebSocket) fail(code int, reason string) error {
+	ws.sendClose(code, reason)
+	return fmt.Errorf("websocket protocol error: %s", reason)
+}
+
+// sendClose sends a close frame, once. Data frames can't be sent afterwards.
+func (ws *WebSocket) sendClose(code int, reason string) error {
+	var payload []byte
+	if code != CloseNoStatus {
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, reason...)
+	}
+
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+	ws.closeSent = true
+	close(ws.closing)
+	return ws.writeFrameLocked(opClose, payload)
+}
+
+func (ws *WebSocket) writeFrame(op byte, payload []byte) error {
+	ws.wmu.Lock()
+	defer ws.wmu.Unlock()
+	if ws.closeSent {
+		return ErrWebSocketClosed
+	}
+	return ws.writeFrameLocked(op, payload)
+}
+
+func (ws *WebSocket) writeFrameLocked(op byte, payload []byte) error {
+	h := make([]byte, 2, 10)
+	h[0] = 0x80 | op // server frames are unfragmented and unmasked
+	switch n := len(payload); {
+	case n <= 125:
+		h[1] = byte(n)
+	case n <= math.MaxUint16:
+		h[1] = 126
+		h = binary.BigEndian.AppendUint16(h, uint16(n))
+	default:
+		h[1] = 127
+		h = binary.BigEndian.AppendUint64(h, uint64(n))
+	}
+	ws.conn.SetWriteDeadline(time.Now().Add(ws.cfg.WriteTimeout))
+	bufs := net.Buffers{h, payload}
+	if _, err := bufs.WriteTo(ws.conn); err != nil {
+		return fmt.Errorf("websocket write failed: %w", err)
+	}
+	return nil
+}
+
+func wsAcceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011, code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// headerHasToken reports whether the comma-separated header values contain token, case-insensitively.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for t := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,374 @@
+package xhttp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wsClient is a minimal test client speaking the client side of RFC 6455.
+type wsClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	resp *http.Response
+}
+
+func dialWS(t *testing.T, url string, header http.Header) *wsClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatalf("write handshake: %v", err)
+	}
+	c := &wsClient{t: t, conn: conn, br: bufio.NewReader(conn)}
+	if c.resp, err = http.ReadResponse(c.br, req); err != nil {
+		t.Fatalf("read handshake: %v", err)
+	}
+	return c
+}
+
+func (c *wsClient) write(fin bool, op byte, payload []byte, masked bool) {
+	c.t.Helper()
+	b := []byte{op, 0}
+	if fin {
+		b[0] |= 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		b[1] = byte(n)
+	case n <= 0xffff:
+		b[1] = 126
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b[1] = 127
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	if masked {
+		b[1] |= 0x80
+		mask := []byte{1, 2, 3, 4}
+		b = append(b, mask...)
+		for i, p := range payload {
+			b = append(b, p^mask[i%4])
+		}
+	} else {
+		b = append(b, payload...)
+	}
+	if _, err := c.conn.Write(b); err != nil {
+		c.t.Fatalf("write frame: %v", err)
+	}
+}
+
+func (c *wsClient) read() (byte, []byte) {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	h := make([]byte, 2)
+	if _, err := c.br.Read(h[:1]); err != nil {
+		c.t.Fatalf("read frame: %v", err)
+	}
+	if _, err := c.br.Read(h[1:]); err != nil {
+		c.t.Fatalf("read frame: %v", err)
+	}
+	n := int(h[1] & 0x7f)
+	switch n {
+	case 126:
+		ext := make([]byte, 2)
+		c.br.Read(ext)
+		n = int(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		c.br.Read(ext)
+		n = int(binary.BigEndian.Uint64(ext))
+	}
+	payload := make([]byte, n)
+	for read := 0; read < n; {
+		m, err := c.br.Read(payload[read:])
+		if err != nil {
+			c.t.Fatalf("read payload: %v", err)
+		}
+		read += m
+	}
+	return h[0] & 0x0f, payload
+}
+
+// readClose reads frames until a close frame, returning its code.
+func (c *wsClient) readClose() int {
+	c.t.Helper()
+	for {
+		op, payload := c.read()
+		if op == opClose {
+			if len(payload) < 2 {
+				return CloseNoStatus
+			}
+			return int(binary.BigEndian.Uint16(payload))
+		}
+	}
+}
+
+func closePayload(code int) []byte {
+	return binary.BigEndian.AppendUint16(nil, uint16(code))
+}
+
+func echoServer(t *testing.T, cfg *WebSocketConfig, closed chan error) *httptest.Server {
+	t.Helper()
+	u, err := NewWebSocketUpgrader(cfg)
+	if err != nil {
+		t.Fatalf("new upgrader: %v", err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		for {
+			typ, msg, err := ws.ReadMessage()
+			if err != nil {
+				if closed != nil {
+					closed <- err
+				}
+				return
+			}
+			ws.WriteMessage(typ, msg)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func TestWebSocketHandshakeErrors(t *testing.T) {
+	u, _ := NewWebSocketUpgrader(&WebSocketConfig{})
+	tests := []struct {
+		name   string
+		method string
+		header map[string]string
+		code   int
+	}{
+		{"not upgrade", "GET", map[string]string{"Sec-WebSocket-Version": "13"}, http.StatusUpgradeRequired},
+		{"bad method", "POST", nil, http.StatusMethodNotAllowed},
+		{"bad version", "GET", map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusUpgradeRequired},
+		{"bad key", "GET", map[string]string{"Sec-WebSocket-Key": "short"}, http.StatusBadRequest},
+		{"cross origin", "GET", map[string]string{"Origin": "https://evil.example"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "http://app.example/ws", nil)
+			if tt.name != "not upgrade" {
+				r.Header.Set("Connection", "Upgrade")
+				r.Header.Set("Upgrade", "websocket")
+				r.Header.Set("Sec-WebSocket-Version", "13")
+				r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			}
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			if _, err := u.Upgrade(rec, r); err == nil {
+				t.Fatal("expected handshake error")
+			}
+			if rec.Code != tt.code {
+				t.Fatalf("want %d, got %d", tt.code, rec.Code)
+			}
+		})
+	}
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	closed := make(chan error, 1)
+	ts := echoServer(t, &WebSocketConfig{Subprotocols: []string{"v2", "v1"}}, closed)
+	c := dialWS(t, ts.URL, http.Header{"Sec-Websocket-Protocol": {"v1, v2"}})
+
+	if c.resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("want 101, got %d", c.resp.StatusCode)
+	}
+	if got := c.resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", got)
+	}
+	if got := c.resp.Header.Get("Sec-WebSocket-Protocol"); got != "v2" {
+		t.Fatalf("want subprotocol v2, got %q", got)
+	}
+
+	c.write(true, opText, []byte("hello"), true)
+	if op, msg := c.read(); op != opText || string(msg) != "hello" {
+		t.Fatalf("unexpected echo %x %q", op, msg)
+	}
+
+	// fragmented message with a ping in between
+	c.write(false, opBinary, []byte("frag"), true)
+	c.write(true, opPing, []byte("p"), true)
+	c.write(true, opContinuation, []byte(strings.Repeat("x", 200)), true)
+	if op, msg := c.read(); op != opPong || string(msg) != "p" {
+		t.Fatalf("want pong, got %x %q", op, msg)
+	}
+	if op, msg := c.read(); op != opBinary || string(msg) != "frag"+strings.Repeat("x", 200) {
+		t.Fatalf("unexpected reassembled message %x %q", op, msg)
+	}
+
+	c.write(true, opClose, closePayload(CloseNormalClosure), true)
+	if code := c.readClose(); code != CloseNormalClosure {
+		t.Fatalf("want echoed close 1000, got %d", code)
+	}
+	var closeErr *WebSocketCloseError
+	if err := <-closed; !errors.As(err, &closeErr) || closeErr.Code != CloseNormalClosure {
+		t.Fatalf("want close error 1000, got %v", err)
+	}
+}
+
+func TestWebSocketProtocolErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(c *wsClient)
+		code int
+	}{
+		{"unmasked", func(c *wsClient) { c.write(true, opText, []byte("hi"), false) }, CloseProtocolError},
+		{"too big", func(c *wsClient) { c.write(true, opBinary, make([]byte, 200), true) }, CloseMessageTooBig},
+		{"too big fragmented", func(c *wsClient) {
+			c.write(false, opBinary, make([]byte, 100), true)
+			c.write(true, opContinuation, make([]byte, 100), true)
+		}, CloseMessageTooBig},
+		{"invalid utf8", func(c *wsClient) { c.write(true, opText, []byte{0xff, 0xfe}, true) }, CloseInvalidPayload},
+		{"bad continuation", func(c *wsClient) { c.write(true, opContinuation, []byte("x"), true) }, CloseProtocolError},
+		{"fragmented control", func(c *wsClient) { c.write(false, opPing, nil, true) }, CloseProtocolError},
+		{"bad close code", func(c *wsClient) { c.write(true, opClose, closePayload(1004), true) }, CloseProtocolError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := echoServer(t, &WebSocketConfig{MaxMessageSize: 150}, nil)
+			c := dialWS(t, ts.URL, nil)
+			tt.send(c)
+			if code := c.readClose(); code != tt.code {
+				t.Fatalf("want close %d, got %d", tt.code, code)
+			}
+		})
+	}
+}
+
+func TestWebSocketPing(t *testing.T) {
+	ts := echoServer(t, &WebSocketConfig{PingInterval: 50 * time.Millisecond}, nil)
+	c := dialWS(t, ts.URL, nil)
+	if op, _ := c.read(); op != opPing {
+		t.Fatalf("want ping, got %x", op)
+	}
+}
+
+func TestWebSocketServerClose(t *testing.T) {
+	u, _ := NewWebSocketUpgrader(&WebSocketConfig{WriteTimeout: time.Second})
+	result := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		ws.WriteMessage(TextMessage, []byte("bye"))
+		result <- ws.Close(CloseNormalClosure, "done")
+		if err := ws.WriteMessage(TextMessage, []byte("late")); !errors.Is(err, ErrWebSocketClosed) {
+			t.Errorf("want ErrWebSocketClosed, got %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	c := dialWS(t, ts.URL, nil)
+	if _, msg := c.read(); string(msg) != "bye" {
+		t.Fatalf("unexpected message %q", msg)
+	}
+	if code := c.readClose(); code != CloseNormalClosure {
+		t.Fatalf("want close 1000, got %d", code)
+	}
+	c.write(true, opClose, closePayload(CloseNormalClosure), true)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("close did not complete after the peer's reply")
+	}
+}
+
+func TestWebSocketServerShutdown(t *testing.T) {
+	u, _ := NewWebSocketUpgrader(&WebSocketConfig{})
+	closed := make(chan error, 1)
+	srv, err := NewServer(&ServerConfig{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ws, err := u.Upgrade(w, r)
+			if err != nil {
+				return
+			}
+			_, _, err = ws.ReadMessage()
+			closed <- err
+		}),
+		ShutdownTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.server
+	ts.Start()
+	defer ts.Close()
+
+	c := dialWS(t, ts.URL, nil)
+	start := time.Now()
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- srv.Shutdown() }()
+
+	if code := c.readClose(); code != CloseGoingAway {
+		t.Fatalf("want close 1001, got %d", code)
+	}
+	c.write(true, opClose, closePayload(CloseGoingAway), true)
+
+	var closeErr *WebSocketCloseError
+	if err := <-closed; !errors.As(err, &closeErr) || closeErr.Code != CloseGoingAway {
+		t.Fatalf("want close error 1001, got %v", err)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("shutdown waited for the timeout (%v)", d)
+	}
+}
+
+func TestWebSocketServerShutdownForceClose(t *testing.T) {
+	u, _ := NewWebSocketUpgrader(&WebSocketConfig{WriteTimeout: 5 * time.Second})
+	srv, _ := NewServer(&ServerConfig{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ws, err := u.Upgrade(w, r); err == nil {
+				ws.ReadMessage()
+			}
+		}),
+		ShutdownTimeout: 200 * time.Millisecond,
+	})
+	ts := httptest.NewUnstartedServer(nil)
+	ts.Config = srv.server
+	ts.Start()
+	defer ts.Close()
+
+	c := dialWS(t, ts.URL, nil)
+	start := time.Now()
+	if err := srv.Shutdown(); err == nil {
+		t.Fatal("expected deadline error when the peer never answers the close frame")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("shutdown did not force-close at the timeout (%v)", d)
+	}
+	if code := c.readClose(); code != CloseGoingAway {
+		t.Fatalf("want close 1001, got %d", code)
+	}
+}
@@ -29,6 +29,10 @@
 //	ctx = xlog.IntoContext(ctx, logger) // Place logger into context
 //	xlog.Info(ctx, "Hello")             // Uses logger placed in context
 //	xlog.Warn(ctx, "Warning message")
+//
+//	// Context functions append trace IDs placed in the context
+//	ctx = xlog.WithTraceIDs(ctx, traceID, spanID)
+//	xlog.Error(ctx, "Failed") // "... Failed trace_id=<traceID> span_id=<spanID>"
 package xlog
 
 import (
@@ -62,6 +66,7 @@ type Logger struct {
 	closed  atomic.Uint32
 	level   atomic.Uint32
 	writer  *rlog.Writer
+	outputs [levelNone]*levelWriter // per level, counting lines written to writer
 	// levels use std lib log package for formatting, flags, etc.
 	debug *log.Logger
 	info  *log.Logger
@@ -71,6 +76,21 @@ type Logger struct {
 
 type ctxKey struct{}
 
+type traceKey struct{}
+
+type traceIDs struct{ trace, span string }
+
+// levelWriter counts the lines written at a level before passing them on.
+type levelWriter struct {
+	w     io.Writer
+	lines atomic.Uint64
+}
+
+func (lw *levelWriter) Write(p []byte) (int, error) {
+	lw.lines.Add(1) // log.Logger writes each entry in a single call
+	return lw.w.Write(p)
+}
+
 func IntoContext(ctx context.Context, logger *Logger) context.Context {
 	return context.WithValue(ctx, ctxKey{}, logger)
 }
@@ -101,6 +121,9 @@ func New(dirPath string, level string) (*Logger, error) {
 		warn:   log.New(io.Discard, fmt.Sprintf("[PID:%d]WARN: ", pid), log.LstdFlags),
 		error:  log.New(io.Discard, fmt.Sprintf("[PID:%d]ERROR: ", pid), log.LstdFlags),
 	}
+	for i := range l.outputs {
+		l.outputs[i] = &levelWriter{w: writer}
+	}
 	l.closed.Store(0)
 	l.level.Store(uint32(levelNone))
 	return l, l.SetLevel(level)
@@ -136,7 +159,7 @@ func (l *Logger) Debug(v ...interface{}) {
 func Debug(ctx context.Context, v ...interface{}) {
 	if l := FromContext(ctx); l != nil {
 		if l.isLevelEnabled(levelDebug) {
-			if err := l.debug.Output(2, fmt.Sprint(v...)); err != nil {
+			if err := l.debug.Output(2, withTrace(ctx, fmt.Sprint(v...))); err != nil {
 				log.Printf("logger: failed to write debug log entry: %v", err)
 			}
 		}
@@ -154,7 +177,7 @@ func (l *Logger) Debugf(format string, v ...interface{}) {
 func Debugf(ctx context.Context, format string, v ...interface{}) {
 	if l := FromContext(ctx); l != nil {
 		if l.isLevelEnabled(levelDebug) {
-			if err := l.debug.Output(2, fmt.Sprintf(format, v...)); err != nil {
+			if err := l.debug.Output(2, withTrace(ctx, fmt.Sprintf(format, v...))); err != nil {
 				log.Printf("logger: failed to write debugf log entry: %v", err)
 			}
 		}
@@ -172,7 +195,7 @@ func (l *Logger) Info(v ...interface{}) {
 func Info(ctx context.Context, v ...interface{}) {
 	if l := FromContext(ctx); l != nil {
 		if l.isLevelEnabled(levelInfo) {
-			if err := l.info.Output(2, fmt.Sprint(v...)); err != nil {
+			if err := l.info.Output(2, withTrace(ctx, fmt.Sprint(v...))); err != nil {
 				log.Printf("logger: failed to write info log entry: %v", err)
 			}
 		}
@@ -190,7 +213,7 @@ func (l *Logger) Infof(format string, v ...interface{}) {
 func Infof(ctx context.Context, format string, v ...interface{}) {
 	if l := FromContext(ctx); l != nil {
 		if l.isLevelEnabled(levelInfo) {
-			if err := l.info.Output(2, fmt.Sprintf(format, v...)); err != nil {
+			if err := l.info.Output(2, withTrace(ctx, fmt.Sprintf(format, v...))); err != nil {
 				log.Printf("logger: failed to write infof log entry: %v", err)
 			}
 		}
@@ -210,7 +233,7 @@ func (l *Logger) Print(v ...interface{}) {
 func Print(ctx context.Context, v ...interface{}) {
 	if l := FromContext(ctx); l != nil {
 		if l.isLevelEnabled(levelInfo) {
-			if err := l.info.Output(2, fmt.Sprint(v...)); err != nil {
+			if err := l.info.Output(2, withTrace(ctx, fmt.Sprint(v...))); err != nil {
 				log.Printf("logger: failed to write print log entry: %v", err)
 			}
 		}
@@ -228,7 +251,7 @@ func (l *Logger) Printf(format string, v ...interface{}) {
 func Printf(ctx context.Context, format string, v ...interface{}) {
 	if l := FromContext(ctx); l != nil {
 		if l.isLevelEnabled(levelInfo) {
-			if err := l.info.Output(2, fmt.Sprintf(format, v...)); err != nil {
+			if err := l.info.Output(2, withTrace(ctx, fmt.Sprintf(format, v...))); err != nil {
 				log.Printf("logger: failed to write print log entry: %v", err)
 			}
 		}
@@ -246,7 +269,7 @@ func (l *Logger) Warn(v ...interface{}) {
 func Warn(ctx context.Context, v ...interface{}) {
 	if l := FromContext(ctx); l != nil {
 		if l.isLevelEnabled(levelWarn) {
-			if err := l.warn.Output(2, fmt.Sprint(v...)); err != nil {
+			if err := l.warn.Output(2, withTrace(ctx, fmt.Sprint(v...))); err != nil {
 				log.Printf("logger: failed to write warn log entry: %v", err)
 			}
 		}
@@ -264,7 +287,7 @@ func (l *Logger) Warnf(format string, v ...interface{}) {
 func Warnf(ctx context.Context, format string, v ...interface{}) {
 	if l := FromContext(ctx); l != nil {
 		if l.isLevelEnabled(levelWarn) {
-			if err := l.warn.Output(2, fmt.Sprintf(format, v...)); err != nil {
+			if err := l.warn.Output(2, withTrace(ctx, fmt.Sprintf(format, v...))); err != nil {
 				log.Printf("logger: failed to write warnf log entry: %v", err)
 			}
 		}
@@ -282,7 +305,7 @@ func (l *Logger) Error(v ...interface{}) {
 func Error(ctx context.Context, v ...interface{}) {
 	if l := FromContext(ctx); l != nil {
 		if l.isLevelEnabled(levelError) {
-			if err := l.error.Output(2, fmt.Sprint(v...)); err != nil {
+			if err := l.error.Output(2, withTrace(ctx, fmt.Sprint(v...))); err != nil {
 				log.Printf("logger: failed to write error log entry: %v", err)
 			}
 		}
@@ -300,13 +323,45 @@ func (l *Logger) Errorf(format string, v ...interface{}) {
 func Errorf(ctx context.Context, format string, v ...interface{}) {
 	if l := FromContext(ctx); l != nil {
 		if l.isLevelEnabled(levelError) {
-			if err := l.error.Output(2, fmt.Sprintf(format, v...)); err != nil {
+			if err := l.error.Output(2, withTrace(ctx, fmt.Sprintf(format, v...))); err != nil {
 				log.Printf("logger: failed to write errorf log entry: %v", err)
 			}
 		}
 	}
 }
 
+// WithTraceIDs returns a copy of ctx carrying trace and span IDs, which the context logging
+// functions like [Info] and [Errorf] append to each line as "trace_id=... span_id=...", so
+// lines can be correlated with traces. Set by xhttp's tracing middleware.
+func WithTraceIDs(ctx context.Context, traceID, spanID string) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceIDs{trace: traceID, span: spanID})
+}
+
+// TraceIDsFromContext returns the trace and span IDs set by [WithTraceIDs], or empty strings.
+func TraceIDsFromContext(ctx context.Context) (traceID, spanID string) {
+	ids, _ := ctx.Value(traceKey{}).(traceIDs)
+	return ids.trace, ids.span
+}
+
+func withTrace(ctx context.Context, msg string) string {
+	ids, ok := ctx.Value(traceKey{}).(traceIDs)
+	if !ok {
+		return msg
+	}
+	return msg + " trace_id=" + ids.trace + " span_id=" + ids.span
+}
+
+// LineCounts returns the number of lines written at each level since the logger was created,
+// keyed by level name (debug, info, warn, error). Useful for exporting as metrics.
+func (l *Logger) LineCounts() map[string]uint64 {
+	return map[string]uint64{
+		"debug": l.outputs[levelDebug].lines.Load(),
+		"info":  l.outputs[levelInfo].lines.Load(),
+		"warn":  l.outputs[levelWarn].lines.Load(),
+		"error": l.outputs[levelError].lines.Load(),
+	}
+}
+
 func (l *Logger) IsClosed() bool {
 	return l.closed.Load() == 1
 }
@@ -320,6 +375,11 @@ func (l *Logger) SetFlags(debugFlag, stdFlag int) {
 	l.error.SetFlags(stdFlag)
 }
 
+// Level returns the minimum log level to output: debug, info, warn, error, or none.
+func (l *Logger) Level() string {
+	return [...]string{"debug", "info", "warn", "error", "none"}[l.level.Load()]
+}
+
 // SetLevel sets the minimum log level to output.
 // Levels are: debug, info, warn, error, none (case-insensitive)
 func (l *Logger) SetLevel(level string) error {
@@ -332,28 +392,28 @@ func (l *Logger) SetLevel(level string) error {
 	switch strings.ToLower(level) {
 	case "debug":
 		newLevel = uint32(levelDebug)
-		l.debug.SetOutput(l.writer)
-		l.info.SetOutput(l.writer)
-		l.warn.SetOutput(l.writer)
-		l.error.SetOutput(l.writer)
+		l.debug.SetOutput(l.outputs[levelDebug])
+		l.info.SetOutput(l.outputs[levelInfo])
+		l.warn.SetOutput(l.outputs[levelWarn])
+		l.error.SetOutput(l.outputs[levelError])
 	case "info":
 		newLevel = uint32(levelInfo)
 		l.debug.SetOutput(io.Discard)
-		l.info.SetOutput(l.writer)
-		l.warn.SetOutput(l.writer)
-		l.error.SetOutput(l.writer)
+		l.info.SetOutput(l.outputs[levelInfo])
+		l.warn.SetOutput(l.outputs[levelWarn])
+		l.error.SetOutput(l.outputs[levelError])
 	case "warn":
 		newLevel = uint32(levelWarn)
 		l.debug.SetOutput(io.Discard)
 		l.info.SetOutput(io.Discard)
-		l.warn.SetOutput(l.writer)
-		l.error.SetOutput(l.writer)
+		l.warn.SetOutput(l.outputs[levelWarn])
+		l.error.SetOutput(l.outputs[levelError])
 	case "error":
 		newLevel = uint32(levelError)
 		l.debug.SetOutput(io.Discard)
 		l.info.SetOutput(io.Discard)
 		l.warn.SetOutput(io.Discard)
-		l.error.SetOutput(l.writer)
+		l.error.SetOutput(l.outputs[levelError])
 	case "none":
 		newLevel = uint32(levelNone)
 		l.debug.SetOutput(io.Discard)
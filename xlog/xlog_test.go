@@ -3,6 +3,9 @@ package xlog_test
 import (
 	"context"
 	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/Data-Corruption/stdx/xlog"
@@ -41,6 +44,9 @@ func TestCloseIdempotentAndLocked(t *testing.T) {
 
 func TestSetLevelAndFlushAfterClose(t *testing.T) {
 	l, _ := xlog.New(t.TempDir(), "warn")
+	if l.Level() != "warn" {
+		t.Fatalf("want level warn, got %q", l.Level())
+	}
 	_ = l.Close()
 
 	if err := l.SetLevel("debug"); !errors.Is(err, xlog.ErrClosed) {
@@ -50,3 +56,48 @@ func TestSetLevelAndFlushAfterClose(t *testing.T) {
 		t.Fatalf("Flush after close: want ErrClosed, got %v", err)
 	}
 }
+
+func TestLineCounts(t *testing.T) {
+	l, _ := xlog.New(t.TempDir(), "info")
+	defer l.Close()
+
+	l.Debug("dropped")
+	l.Info("one")
+	xlog.Infof(xlog.IntoContext(context.Background(), l), "two %d", 2)
+	l.Error("three")
+
+	got := l.LineCounts()
+	want := map[string]uint64{"debug": 0, "info": 2, "warn": 0, "error": 1}
+	for level, n := range want {
+		if got[level] != n {
+			t.Fatalf("%s: want %d lines, got %d", level, n, got[level])
+		}
+	}
+}
+
+func TestTraceIDs(t *testing.T) {
+	dir := t.TempDir()
+	l, _ := xlog.New(dir, "info")
+	defer l.Close()
+
+	ctx := xlog.IntoContext(context.Background(), l)
+	xlog.Info(ctx, "untraced")
+	ctx = xlog.WithTraceIDs(ctx, "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7")
+	if trace, span := xlog.TraceIDsFromContext(ctx); trace != "4bf92f3577b34da6a3ce929d0e0e4736" || span != "00f067aa0ba902b7" {
+		t.Fatalf("unexpected ids %q %q", trace, span)
+	}
+	xlog.Errorf(ctx, "failed %d", 1)
+	if err := l.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "latest.log"))
+	if err != nil {
+		t.Fatalf("read log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 || strings.Contains(lines[0], "trace_id") ||
+		!strings.HasSuffix(lines[1], "failed 1 trace_id=4bf92f3577b34da6a3ce929d0e0e4736 span_id=00f067aa0ba902b7") {
+		t.Fatalf("unexpected log:\n%s", b)
+	}
+}
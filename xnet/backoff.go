@@ -0,0 +1,49 @@
+package xnet
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	DefaultBackoffBase   = 100 * time.Millisecond
+	DefaultBackoffMax    = 2 * time.Second
+	DefaultBackoffFactor = 1.7
+	DefaultBackoffJitter = 0.25
+)
+
+// Backoff computes exponentially growing delays between retries, with jitter so clients
+// retrying at the same time spread out. The zero value uses the defaults, as [Wait] does.
+type Backoff struct {
+	Base   time.Duration // Delay before the first retry. Default is 100ms.
+	Max    time.Duration // Max delay before jitter. Default is 2 seconds.
+	Factor float64       // Growth per attempt. Default is 1.7.
+	Jitter float64       // Random spread as a fraction of the delay, e.g. 0.25 for +/- 25%. Default is 0.25. Negative to disable.
+}
+
+// Delay returns the delay before retrying after the given attempt, starting at 0.
+func (b Backoff) Delay(attempt int) time.Duration {
+	base, max, factor, jitter := b.Base, b.Max, b.Factor, b.Jitter
+	if base <= 0 {
+		base = DefaultBackoffBase
+	}
+	if max <= 0 {
+		max = DefaultBackoffMax
+	}
+	if factor <= 0 {
+		factor = DefaultBackoffFactor
+	}
+	if jitter == 0 {
+		jitter = DefaultBackoffJitter
+	}
+
+	d := time.Duration(float64(base) * math.Pow(factor, float64(attempt)))
+	if d > max || d <= 0 { // overflow
+		d = max
+	}
+	if spread := int64(float64(d) * jitter); jitter > 0 && spread > 0 {
+		d += time.Duration(rand.Int63n(2*spread)) - time.Duration(spread)
+	}
+	return d
+}
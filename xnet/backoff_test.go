@@ -0,0 +1,31 @@
+package xnet
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Base: 100 * time.Millisecond, Max: time.Second, Factor: 2, Jitter: -1}
+	want := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for attempt, w := range want {
+		if got := b.Delay(attempt); got != w*time.Millisecond {
+			t.Fatalf("attempt %d: want %s, got %s", attempt, w*time.Millisecond, got)
+		}
+	}
+	if got := b.Delay(10000); got != time.Second {
+		t.Fatalf("large attempt should cap at max, got %s", got)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	var b Backoff // defaults, as used by Wait
+	for range 100 {
+		if d := b.Delay(0); d < 75*time.Millisecond || d >= 125*time.Millisecond {
+			t.Fatalf("delay %s outside +/- 25%% of 100ms", d)
+		}
+		if d := b.Delay(20); d < 1500*time.Millisecond || d >= 2500*time.Millisecond {
+			t.Fatalf("delay %s outside +/- 25%% of 2s", d)
+		}
+	}
+}
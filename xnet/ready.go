@@ -3,8 +3,6 @@ package xnet
 import (
 	"context"
 	"errors"
-	"math"
-	"math/rand"
 	"net"
 	"time"
 )
@@ -28,17 +26,7 @@ func Wait(ctx context.Context, timeout time.Duration, probes ...string) error {
 	}
 
 	// exponential backoff up to ~2s with a bit of jitter
-	nextDelay := func(attempt int) time.Duration {
-		base := 100 * time.Millisecond
-		max := 2 * time.Second
-		d := time.Duration(float64(base) * math.Pow(1.7, float64(attempt)))
-		if d > max {
-			d = max
-		}
-		// jitter +/- 25%
-		j := time.Duration(rand.Int63n(int64(d/2))) - d/4
-		return d + j
-	}
+	var backoff Backoff
 
 	for attempt := 0; ; attempt++ {
 		if hasUsableAddr() && anyProbeOK(ctx, probes) {
@@ -47,7 +35,7 @@ func Wait(ctx context.Context, timeout time.Duration, probes ...string) error {
 		select {
 		case <-ctx.Done():
 			return context.DeadlineExceeded
-		case <-time.After(nextDelay(attempt)):
+		case <-time.After(backoff.Delay(attempt)):
 		}
 	}
 }